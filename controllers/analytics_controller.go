@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/praleedsuvarna/shared-libs/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Maximum number of days a single analytics query may span
+const maxAnalyticsRangeDays = 366
+
+// GetMRContentAnalytics returns view counts for a content item bucketed by day, week or month
+func GetMRContentAnalytics(c *fiber.Ctx) error {
+	// Get content ID from params
+	contentID := c.Params("id")
+	if contentID == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Content ID is required"})
+	}
+
+	// Get user's organization ID from token
+	orgID := c.Locals("organization_id").(string)
+	objOrgID, err := primitive.ObjectIDFromHex(orgID)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid organization ID"})
+	}
+
+	objContentID, err := primitive.ObjectIDFromHex(contentID)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid content ID format"})
+	}
+
+	// Parse date range, defaulting to the last 30 days
+	to := truncateToDay(time.Now())
+	if c.Query("to") != "" {
+		to, err = time.Parse("2006-01-02", c.Query("to"))
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid 'to' date, expected YYYY-MM-DD"})
+		}
+	}
+
+	from := to.AddDate(0, 0, -29)
+	if c.Query("from") != "" {
+		from, err = time.Parse("2006-01-02", c.Query("from"))
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid 'from' date, expected YYYY-MM-DD"})
+		}
+	}
+
+	if from.After(to) {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "'from' must not be after 'to'"})
+	}
+
+	if to.Sub(from) > maxAnalyticsRangeDays*24*time.Hour {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Date range must not exceed 366 days"})
+	}
+
+	granularity := c.Query("granularity", "day")
+	if granularity != "day" && granularity != "week" && granularity != "month" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid granularity, expected day, week or month"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Make sure the content belongs to the caller's organization
+	count, err := config.GetCollection("oms_mrexperiences").CountDocuments(ctx, bson.M{
+		"_id":             objContentID,
+		"organization_id": objOrgID,
+		"is_active":       true,
+	})
+	if err != nil || count == 0 {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{"error": "MR content not found"})
+	}
+
+	// Load the daily rollups in range
+	cursor, err := config.GetCollection(viewRollupsCollection).Find(ctx, bson.M{
+		"content_id": objContentID,
+		"date":       bson.M{"$gte": from, "$lte": to},
+	}, options.Find().SetSort(bson.M{"date": 1}))
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer cursor.Close(ctx)
+
+	var rollups []models.ViewRollup
+	if err := cursor.All(ctx, &rollups); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	// Build empty buckets for the whole range so gaps show up as zero views
+	var series []fiber.Map
+	bucketIndex := make(map[time.Time]int)
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		start := bucketStart(day, granularity)
+		if _, exists := bucketIndex[start]; !exists {
+			bucketIndex[start] = len(series)
+			series = append(series, fiber.Map{
+				"period_start": start.Format("2006-01-02"),
+				"views":        int64(0),
+				"by_device":    map[string]int64{},
+				"by_platform":  map[string]int64{},
+			})
+		}
+	}
+
+	// Fold the rollups into their buckets
+	var totalViews int64
+	for _, rollup := range rollups {
+		bucket := series[bucketIndex[bucketStart(rollup.Date, granularity)]]
+		bucket["views"] = bucket["views"].(int64) + rollup.Views
+
+		byDevice := bucket["by_device"].(map[string]int64)
+		for device, views := range rollup.ByDevice {
+			byDevice[device] += views
+		}
+
+		byPlatform := bucket["by_platform"].(map[string]int64)
+		for platform, views := range rollup.ByPlatform {
+			byPlatform[platform] += views
+		}
+
+		totalViews += rollup.Views
+	}
+
+	return c.JSON(fiber.Map{
+		"content_id":  contentID,
+		"from":        from.Format("2006-01-02"),
+		"to":          to.Format("2006-01-02"),
+		"granularity": granularity,
+		"total_views": totalViews,
+		"series":      series,
+	})
+}
+
+// bucketStart returns the first day of the bucket the given day falls in
+func bucketStart(day time.Time, granularity string) time.Time {
+	day = truncateToDay(day)
+
+	switch granularity {
+	case "week":
+		// Weeks start on Monday
+		offset := (int(day.Weekday()) + 6) % 7
+		return day.AddDate(0, 0, -offset)
+	case "month":
+		return time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	return day
+}
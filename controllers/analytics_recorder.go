@@ -0,0 +1,341 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/praleedsuvarna/shared-libs/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	viewEventsCollection  = "oms_mrexperience_views"
+	viewRollupsCollection = "oms_mrexperience_view_rollups"
+)
+
+// ViewRecorder buffers view events in memory and writes them to MongoDB in batches
+// so that the public ref_id endpoint never waits on the database
+type ViewRecorder struct {
+	events        chan models.ViewEvent
+	batchSize     int
+	flushInterval time.Duration
+	done          chan struct{}
+	wg            sync.WaitGroup
+	stopOnce      sync.Once
+}
+
+// Global instance of the recorder, nil until InitViewRecorder is called
+var viewRecorder *ViewRecorder
+
+// InitViewRecorder creates the global view recorder and starts its background writer
+func InitViewRecorder() *ViewRecorder {
+	bufferSize := getEnvInt("ANALYTICS_BUFFER_SIZE", 10000)
+	batchSize := getEnvInt("ANALYTICS_BATCH_SIZE", 200)
+	flushSeconds := getEnvInt("ANALYTICS_FLUSH_INTERVAL_SECONDS", 5)
+	retentionDays := getEnvInt("ANALYTICS_VIEW_RETENTION_DAYS", 90)
+
+	ensureViewEventRetention(retentionDays)
+	ensureViewRollupIndex()
+
+	recorder := &ViewRecorder{
+		events:        make(chan models.ViewEvent, bufferSize),
+		batchSize:     batchSize,
+		flushInterval: time.Duration(flushSeconds) * time.Second,
+		done:          make(chan struct{}),
+	}
+
+	recorder.wg.Add(1)
+	go recorder.run()
+
+	viewRecorder = recorder
+	log.Printf("View recorder started (buffer: %d, batch: %d, flush every %s, raw events kept %d days)",
+		bufferSize, batchSize, recorder.flushInterval, retentionDays)
+	return recorder
+}
+
+// ensureViewEventRetention expires raw view events after the retention period; the daily
+// rollups are what is kept long term
+func ensureViewEventRetention(days int) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := config.GetCollection(viewEventsCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "timestamp", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(days * 24 * 60 * 60)),
+	})
+	if err != nil {
+		log.Printf("Error creating view events TTL index: %v", err)
+	}
+}
+
+// ensureViewRollupIndex keeps one rollup per content and day. Without it, replicas flushing
+// the first views of a day at the same time could both insert a rollup.
+func ensureViewRollupIndex() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := config.GetCollection(viewRollupsCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "content_id", Value: 1}, {Key: "date", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Printf("Error creating view rollups index: %v", err)
+	}
+}
+
+// StopViewRecorder flushes any buffered events and stops the global recorder
+func StopViewRecorder() {
+	if viewRecorder != nil {
+		viewRecorder.Stop()
+	}
+}
+
+// Record enqueues a view event without blocking; events are dropped if the buffer is full
+func (r *ViewRecorder) Record(event models.ViewEvent) {
+	select {
+	case r.events <- event:
+	default:
+		log.Printf("View recorder buffer full, dropping view event for content ID: %s", event.ContentID.Hex())
+	}
+}
+
+// Stop signals the writer to flush what it has and waits for it to exit
+func (r *ViewRecorder) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.done)
+		r.wg.Wait()
+		log.Println("View recorder stopped")
+	})
+}
+
+// run collects events into batches and flushes them on size or interval
+func (r *ViewRecorder) run() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]models.ViewEvent, 0, r.batchSize)
+
+	for {
+		select {
+		case event := <-r.events:
+			batch = append(batch, event)
+			if len(batch) >= r.batchSize {
+				r.flush(batch)
+				batch = batch[:0]
+			}
+
+		case <-ticker.C:
+			if len(batch) > 0 {
+				r.flush(batch)
+				batch = batch[:0]
+			}
+
+		case <-r.done:
+			// Drain whatever is still buffered before exiting
+			for {
+				select {
+				case event := <-r.events:
+					batch = append(batch, event)
+				default:
+					if len(batch) > 0 {
+						r.flush(batch)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush writes the raw events and increments the daily rollups for the batch
+func (r *ViewRecorder) flush(batch []models.ViewEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	docs := make([]interface{}, 0, len(batch))
+	for _, event := range batch {
+		docs = append(docs, event)
+	}
+
+	if _, err := config.GetCollection(viewEventsCollection).InsertMany(ctx, docs, options.InsertMany().SetOrdered(false)); err != nil {
+		log.Printf("Error writing %d view events: %v", len(batch), err)
+	}
+
+	writes := viewRollupWrites(batch, time.Now())
+	collection := config.GetCollection(viewRollupsCollection)
+	_, err := collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+
+	// An upsert that lost the race to create a rollup fails on the unique index; the
+	// rollup exists now, so running it again increments it
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil {
+		var retries []mongo.WriteModel
+		for _, writeErr := range bulkErr.WriteErrors {
+			if !mongo.IsDuplicateKeyError(writeErr) {
+				retries = nil
+				break
+			}
+			retries = append(retries, writes[writeErr.Index])
+		}
+		if len(retries) > 0 {
+			_, err = collection.BulkWrite(ctx, retries, options.BulkWrite().SetOrdered(false))
+		}
+	}
+	if err != nil {
+		log.Printf("Error updating view rollups for %d events: %v", len(batch), err)
+		return
+	}
+
+	log.Printf("Flushed %d view events into %d daily rollups", len(batch), len(writes))
+}
+
+// viewRollupWrites pre-aggregates a batch per content and day, so each rollup gets a single upsert
+func viewRollupWrites(batch []models.ViewEvent, now time.Time) []mongo.WriteModel {
+	type rollupKey struct {
+		contentID primitive.ObjectID
+		date      time.Time
+	}
+	type rollupDelta struct {
+		orgID      primitive.ObjectID
+		views      int64
+		byDevice   map[string]int64
+		byPlatform map[string]int64
+	}
+
+	var keys []rollupKey
+	deltas := make(map[rollupKey]*rollupDelta)
+	for _, event := range batch {
+		key := rollupKey{contentID: event.ContentID, date: truncateToDay(event.Timestamp)}
+		delta, exists := deltas[key]
+		if !exists {
+			delta = &rollupDelta{
+				orgID:      event.OrganizationID,
+				byDevice:   make(map[string]int64),
+				byPlatform: make(map[string]int64),
+			}
+			deltas[key] = delta
+			keys = append(keys, key)
+		}
+		delta.views++
+		delta.byDevice[event.DeviceClass]++
+		delta.byPlatform[event.Platform]++
+	}
+
+	writes := make([]mongo.WriteModel, 0, len(keys))
+	for _, key := range keys {
+		delta := deltas[key]
+		inc := bson.M{"views": delta.views}
+		for device, count := range delta.byDevice {
+			inc["by_device."+device] = count
+		}
+		for platform, count := range delta.byPlatform {
+			inc["by_platform."+platform] = count
+		}
+
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"content_id": key.contentID, "date": key.date}).
+			SetUpdate(bson.M{
+				"$inc":         inc,
+				"$set":         bson.M{"updated_at": now},
+				"$setOnInsert": bson.M{"organization_id": delta.orgID},
+			}).
+			SetUpsert(true))
+	}
+
+	return writes
+}
+
+// RecordView builds a view event from the request metadata and hands it to the recorder
+func RecordView(content models.MRContent, userAgent, referrer string) {
+	if viewRecorder == nil {
+		return
+	}
+
+	viewRecorder.Record(models.ViewEvent{
+		ContentID:      content.ID,
+		OrganizationID: content.OrganizationID,
+		RefID:          content.RefID,
+		DeviceClass:    classifyDevice(userAgent),
+		Platform:       detectPlatform(userAgent),
+		ReferrerHost:   referrerHost(referrer),
+		Timestamp:      time.Now().UTC(),
+	})
+}
+
+// classifyDevice maps a User-Agent string to a coarse device class
+func classifyDevice(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	if ua == "" {
+		return "unknown"
+	}
+
+	for _, marker := range []string{"bot", "crawler", "spider", "slurp", "curl", "wget", "headless", "facebookexternalhit"} {
+		if strings.Contains(ua, marker) {
+			return "bot"
+		}
+	}
+
+	switch {
+	case strings.Contains(ua, "ipad") || strings.Contains(ua, "tablet"):
+		return "tablet"
+	case strings.Contains(ua, "android") && !strings.Contains(ua, "mobile"):
+		return "tablet"
+	case strings.Contains(ua, "iphone") || strings.Contains(ua, "ipod") || strings.Contains(ua, "mobile") || strings.Contains(ua, "android"):
+		return "mobile"
+	case strings.Contains(ua, "windows") || strings.Contains(ua, "macintosh") || strings.Contains(ua, "x11") || strings.Contains(ua, "linux") || strings.Contains(ua, "cros"):
+		return "desktop"
+	}
+
+	return "unknown"
+}
+
+// detectPlatform maps a User-Agent string to a coarse operating system family
+func detectPlatform(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+
+	switch {
+	case strings.Contains(ua, "iphone") || strings.Contains(ua, "ipad") || strings.Contains(ua, "ipod"):
+		return "ios"
+	case strings.Contains(ua, "android"):
+		return "android"
+	case strings.Contains(ua, "windows"):
+		return "windows"
+	case strings.Contains(ua, "macintosh") || strings.Contains(ua, "mac os x"):
+		return "macos"
+	case strings.Contains(ua, "linux") || strings.Contains(ua, "x11") || strings.Contains(ua, "cros"):
+		return "linux"
+	}
+
+	return "other"
+}
+
+// referrerHost keeps only the host part of the Referer header
+func referrerHost(referrer string) string {
+	if referrer == "" {
+		return ""
+	}
+
+	parsed, err := url.Parse(referrer)
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(parsed.Hostname())
+}
+
+// truncateToDay returns midnight UTC of the given time
+func truncateToDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
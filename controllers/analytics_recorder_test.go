@@ -0,0 +1,158 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestViewRollupWrites(t *testing.T) {
+	first, second := primitive.NewObjectID(), primitive.NewObjectID()
+	orgID := primitive.NewObjectID()
+	day := time.Date(2026, time.March, 4, 0, 0, 0, 0, time.UTC)
+
+	batch := []models.ViewEvent{
+		{ContentID: first, OrganizationID: orgID, DeviceClass: "mobile", Platform: "ios", Timestamp: day.Add(time.Hour)},
+		{ContentID: first, OrganizationID: orgID, DeviceClass: "mobile", Platform: "android", Timestamp: day.Add(23 * time.Hour)},
+		{ContentID: first, OrganizationID: orgID, DeviceClass: "desktop", Platform: "windows", Timestamp: day.Add(25 * time.Hour)},
+		{ContentID: second, OrganizationID: orgID, DeviceClass: "desktop", Platform: "macos", Timestamp: day.Add(2 * time.Hour)},
+	}
+
+	tests := []struct {
+		contentID primitive.ObjectID
+		date      time.Time
+		inc       bson.M
+	}{
+		{first, day, bson.M{"views": int64(2), "by_device.mobile": int64(2), "by_platform.ios": int64(1), "by_platform.android": int64(1)}},
+		{first, day.AddDate(0, 0, 1), bson.M{"views": int64(1), "by_device.desktop": int64(1), "by_platform.windows": int64(1)}},
+		{second, day, bson.M{"views": int64(1), "by_device.desktop": int64(1), "by_platform.macos": int64(1)}},
+	}
+
+	writes := viewRollupWrites(batch, time.Now())
+	if len(writes) != len(tests) {
+		t.Fatalf("got %d rollup writes, want %d", len(writes), len(tests))
+	}
+
+	for i, tt := range tests {
+		write := writes[i].(*mongo.UpdateOneModel)
+		filter := write.Filter.(bson.M)
+		if filter["content_id"] != tt.contentID || !filter["date"].(time.Time).Equal(tt.date) {
+			t.Errorf("write %d filter = %v, want content %s on %s", i, filter, tt.contentID.Hex(), tt.date)
+		}
+		if write.Upsert == nil || !*write.Upsert {
+			t.Errorf("write %d is not an upsert", i)
+		}
+
+		inc := write.Update.(bson.M)["$inc"].(bson.M)
+		if len(inc) != len(tt.inc) {
+			t.Errorf("write %d $inc = %v, want %v", i, inc, tt.inc)
+		}
+		for field, want := range tt.inc {
+			if inc[field] != want {
+				t.Errorf("write %d $inc[%s] = %v, want %v", i, field, inc[field], want)
+			}
+		}
+	}
+}
+
+// TestViewRecorderFlushes records views through the batching writer and flushes from two
+// recorders at once, as two replicas do. It needs a MongoDB server in MONGO_TEST_URI.
+func TestViewRecorderFlushes(t *testing.T) {
+	database := connectTestDatabase(t)
+	ensureViewRollupIndex()
+	ctx := context.Background()
+
+	rollupViews := func(contentID primitive.ObjectID) (int64, int) {
+		t.Helper()
+		var rollups []models.ViewRollup
+		cursor, err := database.Collection(viewRollupsCollection).Find(ctx, bson.M{"content_id": contentID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cursor.All(ctx, &rollups); err != nil {
+			t.Fatal(err)
+		}
+		var views int64
+		for _, rollup := range rollups {
+			views += rollup.Views
+		}
+		return views, len(rollups)
+	}
+
+	newEvent := func(contentID primitive.ObjectID) models.ViewEvent {
+		return models.ViewEvent{ContentID: contentID, DeviceClass: "mobile", Platform: "ios", Timestamp: time.Now()}
+	}
+
+	t.Run("batches", func(t *testing.T) {
+		contentID := primitive.NewObjectID()
+		recorder := &ViewRecorder{
+			events:        make(chan models.ViewEvent, 100),
+			batchSize:     3,
+			flushInterval: time.Hour,
+			done:          make(chan struct{}),
+		}
+		recorder.wg.Add(1)
+		go recorder.run()
+
+		for i := 0; i < 7; i++ {
+			recorder.Record(newEvent(contentID))
+		}
+
+		// Two full batches are written without waiting for the interval
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			count, err := database.Collection(viewEventsCollection).CountDocuments(ctx, bson.M{"content_id": contentID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count == 6 {
+				break
+			}
+			if count > 6 || time.Now().After(deadline) {
+				t.Fatalf("%d raw events written before stopping, want 6", count)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		// Stopping flushes the partial batch
+		recorder.Stop()
+		count, err := database.Collection(viewEventsCollection).CountDocuments(ctx, bson.M{"content_id": contentID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 7 {
+			t.Errorf("%d raw events written, want 7", count)
+		}
+		if views, rollups := rollupViews(contentID); views != 7 || rollups != 1 {
+			t.Errorf("%d rollups with %d views, want 1 with 7", rollups, views)
+		}
+	})
+
+	t.Run("concurrent flushes", func(t *testing.T) {
+		contentID := primitive.NewObjectID()
+		batch := []models.ViewEvent{newEvent(contentID), newEvent(contentID), newEvent(contentID)}
+
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				(&ViewRecorder{}).flush(batch)
+			}()
+		}
+		close(start)
+		wg.Wait()
+
+		if views, rollups := rollupViews(contentID); views != 12 || rollups != 1 {
+			t.Errorf("%d rollups with %d views, want 1 with 12", rollups, views)
+		}
+	})
+}
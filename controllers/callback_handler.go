@@ -4,80 +4,98 @@ import (
 	"MRContent/models"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/nats-io/nats.go"
 	"github.com/praleedsuvarna/shared-libs/config"
 	"github.com/praleedsuvarna/shared-libs/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // MediaProcessResult represents the result from media processing
 // This structure must match the one in MediaProcessor service
 type MediaProcessResult struct {
-	ContentID      string `json:"content_id,omitempty"`
-	OriginalURL    string `json:"original_url"`
-	ProcessedURL   string `json:"processed_url"`
-	HlsURL         string `json:"hls_url,omitempty"`
-	DashURL        string `json:"dash_url,omitempty"`
-	MediaType      string `json:"media_type"`            // "image", "video", "object_3d"
-	ProcessingType string `json:"processing_type"`       // "compressed", "hls", "dash", "alpha", "stitched"
-	Orientation    string `json:"orientation,omitempty"` // Added orientation field
-	HasAlpha       bool   `json:"has_alpha,omitempty"`   // Added has_alpha field
-	Success        bool   `json:"success"`
-	Error          string `json:"error,omitempty"`
-	Timestamp      int64  `json:"timestamp"`
+	ContentID      string             `json:"content_id,omitempty"`
+	TaskID         string             `json:"task_id,omitempty"`         // Echo of TranscodeRequest.TaskID
+	CorrelationID  string             `json:"correlation_id,omitempty"`  // Echo of TranscodeRequest.CorrelationID
+	RequestVersion int                `json:"request_version,omitempty"` // Echo of TranscodeRequest.RequestVersion
+	OriginalURL    string             `json:"original_url"`
+	ProcessedURL   string             `json:"processed_url"`
+	HlsURL         string             `json:"hls_url,omitempty"`
+	DashURL        string             `json:"dash_url,omitempty"`
+	Thumbnails     map[string]string  `json:"thumbnails,omitempty"`  // Thumbnail URL per size ("320x180") for "thumbnail" results
+	MediaType      string             `json:"media_type"`            // "image", "video", "object_3d"
+	ProcessingType string             `json:"processing_type"`       // "compressed", "hls", "dash", "alpha", "stitched", "thumbnail", "poster", or a model format ("glb", "usdz")
+	Orientation    string             `json:"orientation,omitempty"` // Added orientation field
+	HasAlpha       bool               `json:"has_alpha,omitempty"`   // Added has_alpha field
+	Success        bool               `json:"success"`
+	Error          string             `json:"error,omitempty"`
+	Timestamp      int64              `json:"timestamp"`
+	SchemaVersion  int                `json:"schema_version,omitempty"` // 2 for results listing their outputs, absent or 1 otherwise
+	Outputs        []models.Rendition `json:"outputs,omitempty"`        // Every output of a v2 result, with its metadata
 }
 
-// InitCallbackHandlers initializes HTTP and NATS listeners for media processing callbacks
-func InitCallbackHandlers(app *fiber.App, nc *nats.Conn) error {
+// InitCallbackHandlers initializes the HTTP endpoint and message bus subscribers for media
+// processing callbacks
+func InitCallbackHandlers(app *fiber.App, bus MessageBus) error {
 	// HTTP endpoint for callbacks
 	app.Post("/api/media/callback", HandleMediaCallback)
 
-	// NATS subscribers for various result topics
-	if nc != nil {
-		// Subscribe to all result topics
-		topicPatterns := []string{
-			"result.compressimage",
-			"result.compressvideo",
-			"result.transcodehlsdash",
-			"result.generatealpha",
-			"result.stitchvideos",
-			"result.default",
+	// Message bus subscribers for various result topics
+	if bus != nil {
+		// Subscribe to this environment's callback topic or the shared result topics
+		if err := subscribeResultTopics(bus); err != nil {
+			return err
 		}
+	} else {
+		log.Println("Warning: message bus not provided, skipping result subscribers initialization")
+	}
 
-		for _, topic := range topicPatterns {
-			_, err := nc.Subscribe(topic, func(msg *nats.Msg) {
-				var result MediaProcessResult
-				if err := json.Unmarshal(msg.Data, &result); err != nil {
-					log.Printf("Error unmarshaling NATS message: %v", err)
-					return
-				}
+	return nil
+}
 
-				log.Printf("Received media processing result via NATS from topic %s: %+v", msg.Subject, result)
+// handleResultMessage decodes a result received on a result.* subject and processes it.
+// Results that cannot be decoded or applied are kept as dead letters.
+func handleResultMessage(msg *Message) {
+	var result MediaProcessResult
+	if err := json.Unmarshal(msg.Data, &result); err != nil {
+		log.Printf("Error unmarshaling result message: %v", err)
+		RecordDeadLetter(msg, "decode", "", err)
+		return
+	}
 
-				// Process the result
-				if err := processMediaResult(result); err != nil {
-					log.Printf("Error processing media result from NATS: %v", err)
-				}
-			})
+	// Correlation fields may travel as headers instead of in the body
+	applyCorrelationHeaders(&result, func(key string) string { return msg.Header[key] })
 
-			if err != nil {
-				return fmt.Errorf("error subscribing to NATS topic %s: %w", topic, err)
-			}
+	log.Printf("Received media processing result from topic %s: %+v", msg.Subject, result)
 
-			log.Printf("Subscribed to NATS topic: %s", topic)
-		}
-	} else {
-		log.Println("Warning: NATS connection not provided, skipping NATS subscribers initialization")
+	// Process the result
+	if err := processMediaResult(result); err != nil {
+		log.Printf("Error processing media result from message bus: %v", err)
+		RecordDeadLetter(msg, "process", result.ContentID, err)
 	}
+}
 
-	return nil
+// applyCorrelationHeaders fills correlation fields missing from the body from headers
+func applyCorrelationHeaders(result *MediaProcessResult, get func(string) string) {
+	if result.TaskID == "" {
+		result.TaskID = get(HeaderTaskID)
+	}
+	if result.CorrelationID == "" {
+		result.CorrelationID = get(HeaderCorrelationID)
+	}
+	if result.RequestVersion == 0 {
+		result.RequestVersion, _ = strconv.Atoi(get(HeaderRequestVersion))
+	}
 }
 
 // HandleMediaCallback processes HTTP callbacks from the MediaProcessor service
@@ -93,12 +111,20 @@ func HandleMediaCallback(c *fiber.Ctx) error {
 		})
 	}
 
+	applyCorrelationHeaders(&result, func(key string) string { return c.Get(key) })
+
 	log.Printf("Received media processing result via HTTP: %+v", result)
 
 	// Process the result
 	if err := processMediaResult(result); err != nil {
 		log.Printf("Error processing media result from HTTP: %v", err)
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrUnknownTask) || errors.Is(err, ErrTaskMismatch) {
+			status = http.StatusConflict
+		} else if errors.Is(err, ErrUnsupportedResultSchema) {
+			status = http.StatusBadRequest
+		}
+		return c.Status(status).JSON(fiber.Map{
 			"success": false,
 			"error":   err.Error(),
 		})
@@ -113,21 +139,82 @@ func HandleMediaCallback(c *fiber.Ctx) error {
 // processMediaResult updates the database with processed media URLs
 func processMediaResult(result MediaProcessResult) error {
 	// Skip processing if required fields are missing
-	if result.ContentID == "" || (result.ProcessedURL == "" && result.HlsURL == "" && result.DashURL == "") {
-		return fmt.Errorf("missing required fields: content_id or processed URL")
+	if result.ContentID == "" {
+		return fmt.Errorf("missing required field: content_id")
+	}
+
+	// Accept v1 and v2 results alike
+	if err := normalizeResult(&result); err != nil {
+		return err
+	}
+
+	// Only accept results for work we actually dispatched
+	if err := VerifyResultTask(result); err != nil {
+		if errors.Is(err, ErrTaskSuperseded) {
+			log.Printf("Ignoring result for superseded task %s of content ID %s", result.TaskID, result.ContentID)
+			return nil
+		}
+		if errors.Is(err, ErrDuplicateResult) {
+			log.Printf("Ignoring duplicate %s result for task %s of content ID %s", result.ProcessingType, result.TaskID, result.ContentID)
+			return nil
+		}
+		return fmt.Errorf("rejecting result for task %q of content ID %s: %w", result.TaskID, result.ContentID, err)
+	}
+
+	if result.Success && !hasProcessedOutput(result) {
+		return fmt.Errorf("missing required fields: processed URL")
+	}
+
+	// Claim the outcome this result answers before anything is written, so only one delivery
+	// of a result takes effect even when redeliveries or a superseding run race it
+	claim, err := ClaimResult(result)
+	if err != nil {
+		return fmt.Errorf("error claiming result: %w", err)
+	}
+	if claim == nil && result.TaskID != "" {
+		// Another delivery claimed the outcome, or the task was superseded, since the result
+		// was verified. Outputs the task never listed, and results without a task ID, are
+		// still stored as before.
+		if err := VerifyResultTask(result); errors.Is(err, ErrDuplicateResult) || errors.Is(err, ErrTaskSuperseded) {
+			log.Printf("Ignoring %s result for task %s of content ID %s: %v", result.ProcessingType, result.TaskID, result.ContentID, err)
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("rejecting result for task %q of content ID %s: %w", result.TaskID, result.ContentID, err)
+		}
 	}
 
 	// Skip if processing was not successful
 	if !result.Success {
 		log.Printf("Media processing failed: %s", result.Error)
-		// You might want to update the content status to "failed" or similar
-		// Mark this task as complete even though it failed
+		EmitProcessingEvent(result.ContentID, "failure", map[string]interface{}{
+			"task_id":         result.TaskID,
+			"media_type":      result.MediaType,
+			"processing_type": result.ProcessingType,
+			"original_url":    result.OriginalURL,
+			"error":           result.Error,
+		})
+		// A failure no task was waiting for belongs to a stale or superseded run and must not
+		// settle the current one
+		if claim == nil {
+			return nil
+		}
+		// The failed outcome is recorded so the content doesn't wait for it forever; the
+		// content fails, and content.failed is sent, once the rest of the run is in
+		CompleteResultClaim(claim)
 		if err := TrackProcessingComplete(result.ContentID); err != nil {
 			log.Printf("Error tracking processing completion for failed task: %v", err)
 		}
 		return nil
 	}
 
+	// Give the outcome back if the renditions can't be stored, so a redelivery can store them
+	stored := false
+	defer func() {
+		if claim != nil && !stored {
+			ReleaseResultClaim(claim)
+		}
+	}()
+
 	// Convert content ID from string to ObjectID
 	contentID, err := primitive.ObjectIDFromHex(result.ContentID)
 	if err != nil {
@@ -139,7 +226,7 @@ func processMediaResult(result MediaProcessResult) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// Get the current content
+	// Get the current content, only to find the keys of the originals
 	var content models.MRContent
 	err = collection.FindOne(ctx, bson.M{"_id": contentID}).Decode(&content)
 	if err != nil {
@@ -165,77 +252,29 @@ func processMediaResult(result MediaProcessResult) error {
 			result.ContentID)
 	}
 
-	// Handle different media types
-	switch result.MediaType {
-	case "image":
-		// Process image as before
-		if result.ProcessedURL != "" {
-			// Update the images array
-			existingImages := content.Images
-			updatedImages := updateMediaField(existingImages, "compressed", result.ProcessedURL)
-			updateOps["images"] = updatedImages
-		}
-
-	case "video":
-		// Get existing videos
-		existingVideos := content.Videos
-
-		// Process based on the processing type
-		switch result.ProcessingType {
-		case "compressed":
-			// Update the compressed video URL
-			if result.ProcessedURL != "" {
-				updatedVideos := updateMediaField(existingVideos, "compressed", result.ProcessedURL)
-				updateOps["videos"] = updatedVideos
-			}
-
-		case "hls":
-			// Handle both HLS and DASH URLs
-			updatedVideos := existingVideos
-
-			// Add HLS URL if available
-			if result.HlsURL != "" {
-				updatedVideos = updateMediaField(updatedVideos, "hls", result.HlsURL)
-			}
-
-			// Add DASH URL if available
-			if result.DashURL != "" {
-				updatedVideos = updateMediaField(updatedVideos, "dash", result.DashURL)
-			}
+	// Renditions of this result, each written on its own so results arriving at the
+	// same time never overwrite each other's keys
+	renditions := resultRenditions(content, result)
 
-			// Update videos array in database
-			updateOps["videos"] = updatedVideos
-
-		case "alpha":
-			// Update the alpha video URL
-			if result.ProcessedURL != "" {
-				updatedVideos := updateMediaField(existingVideos, "alpha", result.ProcessedURL)
-				updateOps["videos"] = updatedVideos
-			}
+	// Update the document
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": contentID}, bson.M{"$set": updateOps})
+	if err != nil {
+		return fmt.Errorf("error updating content: %w", err)
+	}
 
-		case "stitched":
-			// Update the stitched video URL
-			if result.ProcessedURL != "" {
-				updatedVideos := updateMediaField(existingVideos, "stitched", result.ProcessedURL)
-				updateOps["videos"] = updatedVideos
+	if field, ok := mediaFields[result.MediaType]; ok {
+		for _, rendition := range renditions {
+			if err := setMediaEntry(ctx, collection, contentID, field, rendition.Key, rendition.Value); err != nil {
+				return fmt.Errorf("error updating %s rendition %s: %w", field, rendition.Key, err)
 			}
 		}
-
-	case "object_3d":
-		// Process 3D objects as before
-		if result.ProcessedURL != "" {
-			existingObjects := content.Objects_3D
-			updatedObjects := updateMediaField(existingObjects, "processed", result.ProcessedURL)
-			updateOps["objects_3d"] = updatedObjects
-		}
 	}
 
-	// Update the document
-	update := bson.M{"$set": updateOps}
-	_, err = collection.UpdateOne(ctx, bson.M{"_id": contentID}, update)
-	if err != nil {
-		return fmt.Errorf("error updating content: %w", err)
+	// Keep every output with its metadata, including ladder rungs and extra sizes
+	if err := storeRenditions(ctx, collection, content, result); err != nil {
+		return fmt.Errorf("error storing renditions: %w", err)
 	}
+	stored = true
 
 	// Log the action
 	utils.LogAudit("system", fmt.Sprintf("Updated %s with %s URLs", result.MediaType, result.ProcessingType), result.ContentID)
@@ -256,69 +295,220 @@ func processMediaResult(result MediaProcessResult) error {
 	log.Printf("Completed %s processing for %s media, content ID: %s",
 		result.ProcessingType, result.MediaType, result.ContentID)
 
-	// Mark this processing task as complete
-	if err := TrackProcessingComplete(result.ContentID); err != nil {
-		log.Printf("Error tracking processing completion: %v", err)
+	EmitProcessingEvent(result.ContentID, "rendition", map[string]interface{}{
+		"task_id":         result.TaskID,
+		"media_type":      result.MediaType,
+		"processing_type": result.ProcessingType,
+		"original_url":    result.OriginalURL,
+		"processed_url":   result.ProcessedURL,
+		"hls_url":         result.HlsURL,
+		"dash_url":        result.DashURL,
+		"outputs":         len(result.Outputs),
+	})
+
+	// Close the task that expected the result once all its outcomes are in
+	if claim != nil {
+		CompleteResultClaim(claim)
+		if err := TrackProcessingComplete(result.ContentID); err != nil {
+			log.Printf("Error tracking processing completion: %v", err)
+		}
 	}
 
 	return nil
 }
 
-// Helper function to update a media field
-func updateMediaField(existingMedia []models.Media, key string, value string) []models.Media {
-	// Check if the key already exists
-	for i, media := range existingMedia {
-		if media.Key == key {
-			// Update existing key
-			existingMedia[i].Value = value
-			return existingMedia
+// resultRenditions returns the media entries a successful result sets. Entries without a URL
+// are left out so they never blank an existing rendition.
+func resultRenditions(content models.MRContent, result MediaProcessResult) []models.Media {
+	var renditions []models.Media
+
+	// Handle different media types
+	switch {
+	case isPreviewRendition(result.ProcessingType):
+		// Thumbnails and posters are stored next to the original they were made from,
+		// each thumbnail size under "<key>_<size>"
+		key := renditionKeyForOriginal(mediaForType(content, result.MediaType), result.OriginalURL, result.ProcessingType)
+		if result.ProcessedURL != "" {
+			renditions = append(renditions, models.Media{Key: key, Value: result.ProcessedURL})
+		}
+		for size, url := range result.Thumbnails {
+			if url != "" {
+				renditions = append(renditions, models.Media{Key: key + "_" + size, Value: url})
+			}
+		}
+
+	case result.MediaType == "image":
+		// Process image as before
+		if result.ProcessedURL != "" {
+			renditions = append(renditions, models.Media{Key: "compressed", Value: result.ProcessedURL})
+		}
+
+	case result.MediaType == "video":
+		// Process based on the processing type
+		switch result.ProcessingType {
+		case "hls":
+			// Handle both HLS and DASH URLs
+			if result.HlsURL != "" {
+				renditions = append(renditions, models.Media{Key: "hls", Value: result.HlsURL})
+			}
+			if result.DashURL != "" {
+				renditions = append(renditions, models.Media{Key: "dash", Value: result.DashURL})
+			}
+
+		case "compressed", "alpha", "stitched":
+			if result.ProcessedURL != "" {
+				renditions = append(renditions, models.Media{Key: result.ProcessingType, Value: result.ProcessedURL})
+			}
+		}
+
+	case result.MediaType == "object_3d":
+		// Each optimized format (e.g. "glb", "usdz") is stored under its own key,
+		// results without a processing type keep the legacy "processed" key
+		if result.ProcessedURL != "" {
+			key := "processed"
+			if result.ProcessingType != "" {
+				key = renditionKeyForOriginal(content.Objects_3D, result.OriginalURL, result.ProcessingType)
+			}
+			renditions = append(renditions, models.Media{Key: key, Value: result.ProcessedURL})
 		}
 	}
 
-	// Key doesn't exist, add it
-	return append(existingMedia, models.Media{
-		Key:   key,
-		Value: value,
-	})
+	return renditions
+}
+
+// hasProcessedOutput reports whether a successful result carries any URL to store. A
+// thumbnail result may carry only its per-size thumbnails.
+func hasProcessedOutput(result MediaProcessResult) bool {
+	if result.ProcessedURL != "" || result.HlsURL != "" || result.DashURL != "" {
+		return true
+	}
+	if isPreviewRendition(result.ProcessingType) {
+		for _, url := range result.Thumbnails {
+			if url != "" {
+				return true
+			}
+		}
+	}
+	return false
 }
 
-// InitNATSSubscribers initializes NATS subscribers for media processing callbacks
-func InitNATSSubscribers(nc *nats.Conn) error {
-	if nc == nil {
-		return fmt.Errorf("NATS connection is nil")
+// setMediaEntry atomically sets the value of one key in a content's media array: the
+// entry is updated in place when the key exists and appended otherwise. Only that entry
+// is written, so concurrent writers of other keys never overwrite each other.
+func setMediaEntry(ctx context.Context, collection *mongo.Collection, contentID primitive.ObjectID, field, key, value string) error {
+	for attempt := 0; attempt < 3; attempt++ {
+		result, err := collection.UpdateOne(ctx,
+			bson.M{"_id": contentID, field + ".k": key},
+			bson.M{"$set": bson.M{field + ".$.v": value, "updated_at": time.Now()}},
+		)
+		if err != nil || result.MatchedCount > 0 {
+			return err
+		}
+
+		// Append only while the key is still missing; if a concurrent writer added it
+		// first, the next attempt updates their entry instead
+		result, err = collection.UpdateOne(ctx,
+			bson.M{"_id": contentID, field + ".k": bson.M{"$ne": key}},
+			bson.M{
+				"$push": bson.M{field: models.Media{Key: key, Value: value}},
+				"$set":  bson.M{"updated_at": time.Now()},
+			},
+		)
+		if err != nil || result.MatchedCount > 0 {
+			return err
+		}
 	}
 
-	topicPatterns := []string{
-		"result.compressimage",
-		"result.compressvideo",
-		"result.transcodehlsdash",
-		"result.generatealpha",
-		"result.stitchvideos",
-		"result.default",
+	return fmt.Errorf("content %s not found", contentID.Hex())
+}
+
+// renditionKeyForOriginal derives the rendition key for a result from the key of the
+// original it was produced from, so "original_2" yields "usdz_2" rather than
+// overwriting the rendition of "original"
+func renditionKeyForOriginal(existingMedia []models.Media, originalURL string, base string) string {
+	for _, media := range existingMedia {
+		if strings.HasPrefix(media.Key, "original") && media.Value == originalURL {
+			return base + strings.TrimPrefix(media.Key, "original")
+		}
 	}
+	return base
+}
 
-	for _, topic := range topicPatterns {
-		_, err := nc.Subscribe(topic, func(msg *nats.Msg) {
-			var result MediaProcessResult
-			if err := json.Unmarshal(msg.Data, &result); err != nil {
-				log.Printf("Error unmarshaling NATS message: %v", err)
-				return
-			}
+// Result subscriptions by subject, nil until the result consumers are started
+var resultSubscriptions struct {
+	mutex sync.Mutex
+	subs  map[string]Subscription
+}
 
-			log.Printf("Received media processing result via NATS from topic %s: %+v", msg.Subject, result)
+// subscribeResultTopics subscribes to the result topics, in the result queue group when one
+// is configured so every result is handled once per deployment. Topics that already have a
+// valid subscription are skipped, so it also restores subscriptions lost to the connection.
+func subscribeResultTopics(bus MessageBus) error {
+	resultSubscriptions.mutex.Lock()
+	defer resultSubscriptions.mutex.Unlock()
 
-			// Process the result
-			if err := processMediaResult(result); err != nil {
-				log.Printf("Error processing media result from NATS: %v", err)
-			}
-		})
+	if resultSubscriptions.subs == nil {
+		resultSubscriptions.subs = map[string]Subscription{}
+	}
+
+	group := ResultQueueGroup()
+
+	for _, topic := range SubscriptionTopics() {
+		if sub := resultSubscriptions.subs[topic]; sub != nil && sub.IsValid() {
+			continue
+		}
+
+		sub, err := bus.Subscribe(topic, group, handleResultMessage)
 
 		if err != nil {
-			return fmt.Errorf("error subscribing to NATS topic %s: %w", topic, err)
+			return fmt.Errorf("error subscribing to result topic %s: %w", topic, err)
 		}
+		trackSubscription(sub)
+		resultSubscriptions.subs[topic] = sub
 
-		log.Printf("Subscribed to NATS topic: %s", topic)
+		if group != "" {
+			log.Printf("Subscribed to %s topic: %s (queue group %s)", bus.Name(), topic, group)
+		} else {
+			log.Printf("Subscribed to %s topic: %s", bus.Name(), topic)
+		}
 	}
 
 	return nil
 }
+
+// restoreResultSubscriptions re-subscribes result topics whose subscription is no longer
+// valid after a (re)connect. It does nothing before the result consumers were started or
+// once shutdown is draining them.
+func restoreResultSubscriptions() {
+	resultSubscriptions.mutex.Lock()
+	started := resultSubscriptions.subs != nil
+	resultSubscriptions.mutex.Unlock()
+
+	if !started || ShuttingDown() {
+		return
+	}
+
+	bus, err := GetMessageBus()
+	if err != nil {
+		log.Printf("Cannot restore result subscriptions: %v", err)
+		return
+	}
+
+	if err := subscribeResultTopics(bus); err != nil {
+		log.Printf("Error restoring result subscriptions: %v", err)
+	}
+}
+
+// resultSubscriptionCount returns the number of valid result subscriptions
+func resultSubscriptionCount() int {
+	resultSubscriptions.mutex.Lock()
+	defer resultSubscriptions.mutex.Unlock()
+
+	count := 0
+	for _, sub := range resultSubscriptions.subs {
+		if sub.IsValid() {
+			count++
+		}
+	}
+	return count
+}
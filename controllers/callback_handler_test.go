@@ -0,0 +1,344 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"context"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/praleedsuvarna/shared-libs/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestResultRenditions(t *testing.T) {
+	content := models.MRContent{
+		Videos: []models.Media{
+			{Key: "original", Value: "https://cdn.example.com/a.mp4"},
+			{Key: "original_2", Value: "https://cdn.example.com/b.mp4"},
+		},
+		Objects_3D: []models.Media{{Key: "original", Value: "https://cdn.example.com/model.fbx"}},
+	}
+
+	tests := []struct {
+		name   string
+		result MediaProcessResult
+		want   []string // "key=value", sorted
+	}{
+		{
+			name:   "compressed image",
+			result: MediaProcessResult{MediaType: "image", ProcessedURL: "https://cdn.example.com/c.jpg"},
+			want:   []string{"compressed=https://cdn.example.com/c.jpg"},
+		},
+		{
+			name:   "hls with dash",
+			result: MediaProcessResult{MediaType: "video", ProcessingType: "hls", HlsURL: "https://cdn.example.com/m.m3u8", DashURL: "https://cdn.example.com/m.mpd"},
+			want:   []string{"dash=https://cdn.example.com/m.mpd", "hls=https://cdn.example.com/m.m3u8"},
+		},
+		{
+			name:   "hls without dash",
+			result: MediaProcessResult{MediaType: "video", ProcessingType: "hls", HlsURL: "https://cdn.example.com/m.m3u8"},
+			want:   []string{"hls=https://cdn.example.com/m.m3u8"},
+		},
+		{
+			name:   "stitched video",
+			result: MediaProcessResult{MediaType: "video", ProcessingType: "stitched", ProcessedURL: "https://cdn.example.com/s.mp4"},
+			want:   []string{"stitched=https://cdn.example.com/s.mp4"},
+		},
+		{
+			name: "thumbnails of the second original",
+			result: MediaProcessResult{MediaType: "video", ProcessingType: "thumbnail", OriginalURL: "https://cdn.example.com/b.mp4",
+				ProcessedURL: "https://cdn.example.com/t.jpg", Thumbnails: map[string]string{"320x180": "https://cdn.example.com/t320.jpg"}},
+			want: []string{"thumbnail_2=https://cdn.example.com/t.jpg", "thumbnail_2_320x180=https://cdn.example.com/t320.jpg"},
+		},
+		{
+			name: "thumbnails without processed url",
+			result: MediaProcessResult{MediaType: "video", ProcessingType: "thumbnail", OriginalURL: "https://cdn.example.com/a.mp4",
+				Thumbnails: map[string]string{"320x180": "https://cdn.example.com/t320.jpg", "640x360": ""}},
+			want: []string{"thumbnail_320x180=https://cdn.example.com/t320.jpg"},
+		},
+		{
+			name:   "model format",
+			result: MediaProcessResult{MediaType: "object_3d", ProcessingType: "glb", OriginalURL: "https://cdn.example.com/model.fbx", ProcessedURL: "https://cdn.example.com/m.glb"},
+			want:   []string{"glb=https://cdn.example.com/m.glb"},
+		},
+		{
+			name:   "legacy model result",
+			result: MediaProcessResult{MediaType: "object_3d", ProcessedURL: "https://cdn.example.com/m.glb"},
+			want:   []string{"processed=https://cdn.example.com/m.glb"},
+		},
+		{
+			name:   "empty urls set nothing",
+			result: MediaProcessResult{MediaType: "video", ProcessingType: "compressed"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, rendition := range resultRenditions(content, tt.result) {
+				got = append(got, rendition.Key+"="+rendition.Value)
+			}
+			sort.Strings(got)
+			if !equalStrings(got, tt.want) {
+				t.Errorf("renditions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasProcessedOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		result MediaProcessResult
+		want   bool
+	}{
+		{"processed url", MediaProcessResult{ProcessingType: "compressed", ProcessedURL: "https://cdn.example.com/c.mp4"}, true},
+		{"hls only", MediaProcessResult{ProcessingType: "hls", HlsURL: "https://cdn.example.com/m.m3u8"}, true},
+		{"dash only", MediaProcessResult{ProcessingType: "hls", DashURL: "https://cdn.example.com/m.mpd"}, true},
+		{"thumbnails only", MediaProcessResult{ProcessingType: "thumbnail", Thumbnails: map[string]string{"320x180": "https://cdn.example.com/t.jpg"}}, true},
+		{"empty thumbnails", MediaProcessResult{ProcessingType: "thumbnail", Thumbnails: map[string]string{"320x180": ""}}, false},
+		{"thumbnails on another type", MediaProcessResult{ProcessingType: "compressed", Thumbnails: map[string]string{"320x180": "https://cdn.example.com/t.jpg"}}, false},
+		{"nothing", MediaProcessResult{ProcessingType: "compressed"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasProcessedOutput(tt.result); got != tt.want {
+				t.Errorf("hasProcessedOutput = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestConcurrentResultsKeepEveryRendition delivers the results of one run at the same time,
+// as a processor working on several renditions in parallel does, and checks none of them
+// overwrites another's keys. It needs a MongoDB server in MONGO_TEST_URI.
+func TestConcurrentResultsKeepEveryRendition(t *testing.T) {
+	collection := connectTestDatabase(t).Collection("oms_mrexperiences")
+
+	const original = "https://cdn.example.com/original.mp4"
+	results := []MediaProcessResult{
+		{MediaType: "video", ProcessingType: "hls", OriginalURL: original, Success: true,
+			HlsURL: "https://cdn.example.com/master.m3u8", DashURL: "https://cdn.example.com/manifest.mpd"},
+		{MediaType: "video", ProcessingType: "compressed", OriginalURL: original, Success: true,
+			ProcessedURL: "https://cdn.example.com/compressed.mp4"},
+		{MediaType: "video", ProcessingType: "stitched", OriginalURL: original, Success: true,
+			ProcessedURL: "https://cdn.example.com/stitched.mp4"},
+		{MediaType: "video", ProcessingType: "thumbnail", OriginalURL: original, Success: true,
+			Thumbnails: map[string]string{"320x180": "https://cdn.example.com/thumb-320.jpg", "640x360": "https://cdn.example.com/thumb-640.jpg"}},
+	}
+	want := map[string]string{
+		"original":          original,
+		"hls":               "https://cdn.example.com/master.m3u8",
+		"dash":              "https://cdn.example.com/manifest.mpd",
+		"compressed":        "https://cdn.example.com/compressed.mp4",
+		"stitched":          "https://cdn.example.com/stitched.mp4",
+		"thumbnail_320x180": "https://cdn.example.com/thumb-320.jpg",
+		"thumbnail_640x360": "https://cdn.example.com/thumb-640.jpg",
+	}
+
+	// Races are timing dependent, so run the same delivery on several documents
+	for round := 0; round < 20; round++ {
+		content := models.MRContent{
+			ID:             primitive.NewObjectID(),
+			OrganizationID: primitive.NewObjectID(),
+			RenderType:     "video",
+			Videos:         []models.Media{{Key: "original", Value: original}},
+			Status:         "processing",
+			IsActive:       true,
+			CreatedAt:      time.Now(),
+			UpdatedAt:      time.Now(),
+		}
+		if _, err := collection.InsertOne(context.Background(), content); err != nil {
+			t.Fatal(err)
+		}
+
+		start := make(chan struct{})
+		errs := make([]error, len(results))
+		var wg sync.WaitGroup
+		for i, result := range results {
+			result.ContentID = content.ID.Hex()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				errs[i] = processMediaResult(result)
+			}()
+		}
+		close(start)
+		wg.Wait()
+
+		for i, err := range errs {
+			if err != nil {
+				t.Fatalf("round %d: %s result: %v", round, results[i].ProcessingType, err)
+			}
+		}
+
+		var stored models.MRContent
+		if err := collection.FindOne(context.Background(), bson.M{"_id": content.ID}).Decode(&stored); err != nil {
+			t.Fatal(err)
+		}
+
+		got := map[string]string{}
+		for _, media := range stored.Videos {
+			if _, seen := got[media.Key]; seen {
+				t.Errorf("round %d: key %q stored twice", round, media.Key)
+			}
+			got[media.Key] = media.Value
+		}
+		for key, value := range want {
+			if got[key] != value {
+				t.Errorf("round %d: videos[%q] = %q, want %q", round, key, got[key], value)
+			}
+		}
+		if len(got) != len(want) {
+			t.Errorf("round %d: videos = %v, want %v", round, got, want)
+		}
+
+		urls := map[string]bool{}
+		for _, rendition := range stored.Renditions {
+			urls[rendition.URL] = true
+		}
+		for key, value := range want {
+			if key != "original" && !urls[value] {
+				t.Errorf("round %d: renditions lost the %s output", round, key)
+			}
+		}
+	}
+}
+
+// connectTestDatabase points the shared MongoDB client at a scratch database on the server
+// in MONGO_TEST_URI, and drops it when the test ends
+func connectTestDatabase(t *testing.T) *mongo.Database {
+	t.Helper()
+
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		t.Fatalf("MongoDB at MONGO_TEST_URI is not reachable: %v", err)
+	}
+
+	previousDB, previousConfig := config.DB, config.Config
+	config.DB = client
+	config.Config = &config.AppConfig{MongoURI: uri, DBName: "mrcontent_test_" + primitive.NewObjectID().Hex()}
+
+	database := client.Database(config.Config.DBName)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		database.Drop(ctx)
+		client.Disconnect(ctx)
+		config.DB, config.Config = previousDB, previousConfig
+	})
+
+	return database
+}
+
+// TestRedeliveredResultIsStoredOnce delivers the same result several times at once, as a
+// message bus redelivering it does, and checks only one delivery writes renditions. It needs
+// a MongoDB server in MONGO_TEST_URI.
+func TestRedeliveredResultIsStoredOnce(t *testing.T) {
+	database := connectTestDatabase(t)
+	ctx := context.Background()
+
+	const original = "https://cdn.example.com/original.jpg"
+	content := models.MRContent{
+		ID:             primitive.NewObjectID(),
+		OrganizationID: primitive.NewObjectID(),
+		Images:         []models.Media{{Key: "original", Value: original}},
+		Status:         "processing",
+		IsActive:       true,
+	}
+	if _, err := database.Collection("oms_mrexperiences").InsertOne(ctx, content); err != nil {
+		t.Fatal(err)
+	}
+
+	tasks := []models.ProcessingTask{
+		{ID: primitive.NewObjectID(), Status: "superseded", RequestVersion: 1},
+		{ID: primitive.NewObjectID(), Status: "dispatched", RequestVersion: 2},
+	}
+	for _, task := range tasks {
+		task.ContentID = content.ID
+		task.OrganizationID = content.OrganizationID
+		task.Subject = "compressimage"
+		task.MediaType = "image"
+		task.SourceKey = "original"
+		task.SourceURL = original
+		task.Outcomes = []models.ExpectedOutcome{{ResultType: "compressed", Status: "pending"}}
+		if _, err := database.Collection(processingTasksCollection).InsertOne(ctx, task); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A late result of the superseded run writes nothing
+	err := processMediaResult(MediaProcessResult{
+		ContentID: content.ID.Hex(), TaskID: tasks[0].ID.Hex(), RequestVersion: 1,
+		MediaType: "image", ProcessingType: "compressed", OriginalURL: original,
+		ProcessedURL: "https://cdn.example.com/stale.jpg", Success: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			err := processMediaResult(MediaProcessResult{
+				ContentID: content.ID.Hex(), TaskID: tasks[1].ID.Hex(), RequestVersion: 2,
+				MediaType: "image", ProcessingType: "compressed", OriginalURL: original,
+				ProcessedURL: "https://cdn.example.com/compressed.jpg", Success: true,
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	renditionEvents, err := database.Collection(processingEventsCollection).CountDocuments(ctx, bson.M{"content_id": content.ID, "type": "rendition"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renditionEvents != 1 {
+		t.Errorf("%d deliveries stored renditions, want 1", renditionEvents)
+	}
+
+	var stored models.MRContent
+	if err := database.Collection("oms_mrexperiences").FindOne(ctx, bson.M{"_id": content.ID}).Decode(&stored); err != nil {
+		t.Fatal(err)
+	}
+	for _, media := range stored.Images {
+		if media.Key == "compressed" && media.Value != "https://cdn.example.com/compressed.jpg" {
+			t.Errorf("images.compressed = %q, want the current run's output", media.Value)
+		}
+	}
+
+	var task models.ProcessingTask
+	if err := database.Collection(processingTasksCollection).FindOne(ctx, bson.M{"_id": tasks[1].ID}).Decode(&task); err != nil {
+		t.Fatal(err)
+	}
+	if task.Status != "completed" || task.Outcomes[0].Status != "succeeded" {
+		t.Errorf("task status = %s, outcome %s, want completed and succeeded", task.Status, task.Outcomes[0].Status)
+	}
+}
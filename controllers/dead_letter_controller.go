@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/praleedsuvarna/shared-libs/config"
+	"github.com/praleedsuvarna/shared-libs/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ListDeadLetters returns dead letters, newest first. Filters: status, subject, content_id, stage.
+func ListDeadLetters(c *fiber.Ctx) error {
+	limit, _ := strconv.ParseInt(c.Query("limit", "50"), 10, 64)
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	filter := bson.M{}
+	for _, field := range []string{"status", "subject", "content_id", "stage"} {
+		if value := c.Query(field); value != "" {
+			filter[field] = value
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := config.GetCollection(deadLettersCollection).Find(ctx, filter,
+		options.Find().SetSort(bson.M{"received_at": -1}).SetLimit(limit))
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer cursor.Close(ctx)
+
+	letters := []models.DeadLetter{}
+	if err := cursor.All(ctx, &letters); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"data": letters})
+}
+
+// GetDeadLetterStats returns counts of pending and recent dead letters
+func GetDeadLetterStats(c *fiber.Ctx) error {
+	stats, err := LoadDeadLetterStats()
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(stats)
+}
+
+// GetDeadLetter returns a single dead letter with its raw payload
+func GetDeadLetter(c *fiber.Ctx) error {
+	letter, status, err := findDeadLetter(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(letter)
+}
+
+// UpdateDeadLetter replaces the payload of a pending dead letter, e.g. to fix a malformed
+// result before replaying it. The first received payload is kept as original_payload.
+func UpdateDeadLetter(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+
+	letter, status, err := findDeadLetter(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+	if letter.Status != "pending" {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{"error": "Only pending dead letters can be edited"})
+	}
+
+	var request struct {
+		Payload json.RawMessage   `json:"payload"`
+		Headers map[string]string `json:"headers"`
+	}
+	if err := json.Unmarshal(c.Body(), &request); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var result MediaProcessResult
+	if err := json.Unmarshal(request.Payload, &result); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "payload is not a valid processing result: " + err.Error()})
+	}
+
+	now := time.Now()
+	set := bson.M{
+		"payload":    string(request.Payload),
+		"content_id": result.ContentID,
+		"edited_at":  now,
+		"updated_at": now,
+	}
+	if letter.OriginalPayload == "" {
+		set["original_payload"] = letter.Payload
+	}
+	if request.Headers != nil {
+		set["headers"] = request.Headers
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = config.GetCollection(deadLettersCollection).FindOneAndUpdate(ctx,
+		bson.M{"_id": letter.ID, "status": "pending"},
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&letter)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update dead letter"})
+	}
+
+	utils.LogAudit(userID, "Edited dead letter", letter.ID.Hex())
+
+	return c.JSON(letter)
+}
+
+// ReplayDeadLetter runs a pending dead letter through result processing again
+func ReplayDeadLetter(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+
+	letter, status, err := findDeadLetter(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+	if letter.Status != "pending" {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{"error": "Dead letter was already " + letter.Status})
+	}
+
+	utils.LogAudit(userID, "Replayed dead letter", letter.ID.Hex())
+
+	if err := replayDeadLetter(letter); err != nil {
+		return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"message": "Dead letter replayed successfully"})
+}
+
+// DiscardDeadLetter marks a dead letter as not worth replaying
+func DiscardDeadLetter(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+
+	letter, status, err := findDeadLetter(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := config.GetCollection(deadLettersCollection).UpdateOne(ctx,
+		bson.M{"_id": letter.ID, "status": "pending"},
+		bson.M{"$set": bson.M{"status": "discarded", "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to discard dead letter"})
+	}
+	if result.ModifiedCount == 0 {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{"error": "Dead letter was already " + letter.Status})
+	}
+
+	utils.LogAudit(userID, "Discarded dead letter", letter.ID.Hex())
+
+	return c.JSON(fiber.Map{"message": "Dead letter discarded"})
+}
+
+// findDeadLetter loads the dead letter named by the :id route parameter
+func findDeadLetter(c *fiber.Ctx) (models.DeadLetter, int, error) {
+	var letter models.DeadLetter
+
+	letterID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return letter, http.StatusBadRequest, fmt.Errorf("Invalid dead letter ID format")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = config.GetCollection(deadLettersCollection).FindOne(ctx, bson.M{"_id": letterID}).Decode(&letter)
+	if err != nil {
+		return letter, http.StatusNotFound, fmt.Errorf("Dead letter not found")
+	}
+
+	return letter, http.StatusOK, nil
+}
@@ -0,0 +1,278 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/praleedsuvarna/shared-libs/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const deadLettersCollection = "oms_dead_letters"
+
+// RecordDeadLetter stores a result message that could not be decoded or processed.
+// stage is "decode" or "process"; contentID is empty when the payload could not be decoded.
+func RecordDeadLetter(msg *Message, stage, contentID string, cause error) {
+	now := time.Now()
+	letter := models.DeadLetter{
+		ID:         primitive.NewObjectID(),
+		Subject:    msg.Subject,
+		Payload:    string(msg.Data),
+		Stage:      stage,
+		Error:      cause.Error(),
+		ContentID:  contentID,
+		Status:     "pending",
+		ReceivedAt: now,
+		UpdatedAt:  now,
+	}
+
+	if len(msg.Header) > 0 {
+		letter.Headers = msg.Header
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := config.GetCollection(deadLettersCollection).InsertOne(ctx, letter); err != nil {
+		// Last resort: keep the payload in the log so it isn't lost entirely
+		log.Printf("Error storing dead letter from %s (%v), payload: %s", msg.Subject, err, msg.Data)
+		return
+	}
+
+	log.Printf("Stored dead letter %s from %s (%s failed: %v)", letter.ID.Hex(), msg.Subject, stage, cause)
+}
+
+// replayDeadLetter decodes a dead letter's (possibly edited) payload and runs it through
+// processMediaResult again, recording the outcome on the dead letter
+func replayDeadLetter(letter models.DeadLetter) error {
+	var result MediaProcessResult
+	replayErr := json.Unmarshal([]byte(letter.Payload), &result)
+	if replayErr == nil {
+		applyCorrelationHeaders(&result, func(key string) string { return letter.Headers[key] })
+		replayErr = processMediaResult(result)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	set := bson.M{"last_replayed_at": now, "updated_at": now}
+	update := bson.M{"$set": set, "$inc": bson.M{"replay_count": 1}}
+	if replayErr != nil {
+		set["last_replay_error"] = replayErr.Error()
+	} else {
+		set["status"] = "replayed"
+		update["$unset"] = bson.M{"last_replay_error": ""}
+	}
+
+	if _, err := config.GetCollection(deadLettersCollection).UpdateOne(ctx, bson.M{"_id": letter.ID}, update); err != nil {
+		log.Printf("Error recording replay of dead letter %s: %v", letter.ID.Hex(), err)
+	}
+
+	if replayErr != nil {
+		return replayErr
+	}
+
+	log.Printf("Replayed dead letter %s for content ID %s", letter.ID.Hex(), result.ContentID)
+	return nil
+}
+
+// DeadLetterStats summarises the dead-letter store
+type DeadLetterStats struct {
+	Pending          int64            `json:"pending"`
+	LastHour         int64            `json:"last_hour"`
+	LastDay          int64            `json:"last_day"`
+	PendingBySubject map[string]int64 `json:"pending_by_subject"`
+	PendingByStage   map[string]int64 `json:"pending_by_stage"`
+	OldestPending    *time.Time       `json:"oldest_pending,omitempty"`
+}
+
+// LoadDeadLetterStats counts pending dead letters and recent arrivals
+func LoadDeadLetterStats() (DeadLetterStats, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := config.GetCollection(deadLettersCollection)
+	now := time.Now()
+	stats := DeadLetterStats{
+		PendingBySubject: map[string]int64{},
+		PendingByStage:   map[string]int64{},
+	}
+
+	var err error
+	if stats.LastHour, err = collection.CountDocuments(ctx, bson.M{"received_at": bson.M{"$gte": now.Add(-time.Hour)}}); err != nil {
+		return stats, err
+	}
+	if stats.LastDay, err = collection.CountDocuments(ctx, bson.M{"received_at": bson.M{"$gte": now.Add(-24 * time.Hour)}}); err != nil {
+		return stats, err
+	}
+
+	cursor, err := collection.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"status": "pending"}},
+		{"$group": bson.M{
+			"_id":    bson.M{"subject": "$subject", "stage": "$stage"},
+			"count":  bson.M{"$sum": 1},
+			"oldest": bson.M{"$min": "$received_at"},
+		}},
+	})
+	if err != nil {
+		return stats, err
+	}
+	defer cursor.Close(ctx)
+
+	var groups []struct {
+		ID struct {
+			Subject string `bson:"subject"`
+			Stage   string `bson:"stage"`
+		} `bson:"_id"`
+		Count  int64     `bson:"count"`
+		Oldest time.Time `bson:"oldest"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return stats, err
+	}
+
+	for _, group := range groups {
+		stats.Pending += group.Count
+		stats.PendingBySubject[group.ID.Subject] += group.Count
+		stats.PendingByStage[group.ID.Stage] += group.Count
+		if stats.OldestPending == nil || group.Oldest.Before(*stats.OldestPending) {
+			oldest := group.Oldest
+			stats.OldestPending = &oldest
+		}
+	}
+
+	return stats, nil
+}
+
+// DeadLetterMonitor alerts when dead letters arrive faster than DEAD_LETTER_ALERT_THRESHOLD
+// per DEAD_LETTER_ALERT_WINDOW_MINUTES. Alerts are logged and, when DEAD_LETTER_ALERT_URL is
+// set, posted there as JSON with a "text" field (Slack-compatible).
+type DeadLetterMonitor struct {
+	interval  time.Duration
+	window    time.Duration
+	threshold int64
+	alertURL  string
+	alerting  bool
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+// Global instance of the monitor, nil until InitDeadLetterMonitor is called
+var deadLetterMonitor *DeadLetterMonitor
+
+// InitDeadLetterMonitor starts watching the dead-letter store for growth
+func InitDeadLetterMonitor() *DeadLetterMonitor {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	monitor := &DeadLetterMonitor{
+		interval:  time.Duration(getEnvInt("DEAD_LETTER_CHECK_SECONDS", 60)) * time.Second,
+		window:    time.Duration(getEnvInt("DEAD_LETTER_ALERT_WINDOW_MINUTES", 15)) * time.Minute,
+		threshold: int64(getEnvInt("DEAD_LETTER_ALERT_THRESHOLD", 10)),
+		alertURL:  config.GetEnv("DEAD_LETTER_ALERT_URL", ""),
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+
+	go monitor.run(ctx)
+
+	deadLetterMonitor = monitor
+	log.Printf("Dead-letter monitor started (alert at %d per %s)", monitor.threshold, monitor.window)
+	return monitor
+}
+
+// StopDeadLetterMonitor stops the global monitor
+func StopDeadLetterMonitor() {
+	if deadLetterMonitor != nil {
+		deadLetterMonitor.cancel()
+		<-deadLetterMonitor.done
+		deadLetterMonitor = nil
+		log.Println("Dead-letter monitor stopped")
+	}
+}
+
+func (m *DeadLetterMonitor) run(ctx context.Context) {
+	defer close(m.done)
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.check(ctx)
+		}
+	}
+}
+
+// check alerts once when the threshold is crossed and again only after the rate has dropped
+func (m *DeadLetterMonitor) check(ctx context.Context) {
+	queryCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	recent, err := config.GetCollection(deadLettersCollection).CountDocuments(queryCtx,
+		bson.M{"received_at": bson.M{"$gte": time.Now().Add(-m.window)}})
+	if err != nil {
+		log.Printf("Error counting recent dead letters: %v", err)
+		return
+	}
+
+	if recent < m.threshold {
+		if m.alerting {
+			log.Printf("Dead letters back to normal: %d in the last %s", recent, m.window)
+		}
+		m.alerting = false
+		return
+	}
+	if m.alerting {
+		return
+	}
+	m.alerting = true
+
+	message := fmt.Sprintf("MRContent: %d processing results were dead-lettered in the last %s (threshold %d)",
+		recent, m.window, m.threshold)
+	log.Printf("ALERT: %s", message)
+
+	if m.alertURL != "" {
+		m.postAlert(ctx, message, recent)
+	}
+}
+
+func (m *DeadLetterMonitor) postAlert(ctx context.Context, message string, recent int64) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"text":      message,
+		"count":     recent,
+		"window":    m.window.String(),
+		"threshold": m.threshold,
+	})
+
+	reqCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, m.alertURL, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Error creating dead-letter alert request: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("Error sending dead-letter alert: %v", err)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		log.Printf("Dead-letter alert endpoint responded with HTTP %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestApplyCorrelationHeaders(t *testing.T) {
+	headers := map[string]string{
+		HeaderTaskID:         "65f000000000000000000003",
+		HeaderCorrelationID:  "corr-header",
+		HeaderRequestVersion: "4",
+	}
+
+	tests := []struct {
+		name    string
+		result  MediaProcessResult
+		headers map[string]string
+		want    MediaProcessResult
+	}{
+		{
+			name:    "filled from headers",
+			headers: headers,
+			want:    MediaProcessResult{TaskID: "65f000000000000000000003", CorrelationID: "corr-header", RequestVersion: 4},
+		},
+		{
+			name:    "body wins over headers",
+			result:  MediaProcessResult{TaskID: "65f000000000000000000009", CorrelationID: "corr-body", RequestVersion: 2},
+			headers: headers,
+			want:    MediaProcessResult{TaskID: "65f000000000000000000009", CorrelationID: "corr-body", RequestVersion: 2},
+		},
+		{
+			name:    "invalid version header",
+			headers: map[string]string{HeaderRequestVersion: "latest"},
+			want:    MediaProcessResult{},
+		},
+		{
+			name: "no headers",
+			want: MediaProcessResult{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := tt.result
+			applyCorrelationHeaders(&result, func(key string) string { return tt.headers[key] })
+
+			if result.TaskID != tt.want.TaskID || result.CorrelationID != tt.want.CorrelationID || result.RequestVersion != tt.want.RequestVersion {
+				t.Errorf("applyCorrelationHeaders() = %q %q %d, want %q %q %d",
+					result.TaskID, result.CorrelationID, result.RequestVersion,
+					tt.want.TaskID, tt.want.CorrelationID, tt.want.RequestVersion)
+			}
+		})
+	}
+}
+
+// TestUnprocessableResultsAreDeadLettered sends results that cannot be decoded or applied and
+// checks each is kept with its payload, and that a failed replay is recorded on it. It needs
+// a MongoDB server in MONGO_TEST_URI.
+func TestUnprocessableResultsAreDeadLettered(t *testing.T) {
+	database := connectTestDatabase(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name          string
+		msg           *Message
+		wantStage     string
+		wantContentID string
+	}{
+		{
+			name:      "undecodable",
+			msg:       &Message{Subject: "result.compressimage", Data: []byte(`{"content_id":`)},
+			wantStage: "decode",
+		},
+		{
+			name:          "unsupported schema",
+			msg:           &Message{Subject: "result.compressimage", Header: map[string]string{HeaderTaskID: "65f000000000000000000003"}, Data: []byte(`{"content_id":"65f000000000000000000004","schema_version":9,"success":true}`)},
+			wantStage:     "process",
+			wantContentID: "65f000000000000000000004",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handleResultMessage(tt.msg)
+
+			var letter models.DeadLetter
+			err := database.Collection(deadLettersCollection).FindOne(ctx, bson.M{"payload": string(tt.msg.Data)}).Decode(&letter)
+			if err != nil {
+				t.Fatalf("no dead letter stored: %v", err)
+			}
+			if letter.Stage != tt.wantStage || letter.ContentID != tt.wantContentID || letter.Status != "pending" || letter.Error == "" {
+				t.Errorf("dead letter = stage %q, content %q, status %q, error %q; want stage %q, content %q, pending with an error",
+					letter.Stage, letter.ContentID, letter.Status, letter.Error, tt.wantStage, tt.wantContentID)
+			}
+			if tt.msg.Header != nil && letter.Headers[HeaderTaskID] != tt.msg.Header[HeaderTaskID] {
+				t.Errorf("dead letter headers = %v, want %v", letter.Headers, tt.msg.Header)
+			}
+
+			if err := replayDeadLetter(letter); err == nil {
+				t.Fatal("replayDeadLetter() of an unchanged payload succeeded")
+			}
+
+			var replayed models.DeadLetter
+			if err := database.Collection(deadLettersCollection).FindOne(ctx, bson.M{"_id": letter.ID}).Decode(&replayed); err != nil {
+				t.Fatal(err)
+			}
+			if replayed.Status != "pending" || replayed.ReplayCount != 1 || replayed.LastReplayError == "" || replayed.LastReplayedAt == nil {
+				t.Errorf("after a failed replay: status %q, replays %d, error %q, replayed at %v",
+					replayed.Status, replayed.ReplayCount, replayed.LastReplayError, replayed.LastReplayedAt)
+			}
+		})
+	}
+}
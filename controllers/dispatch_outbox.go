@@ -0,0 +1,363 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/praleedsuvarna/shared-libs/config"
+	"github.com/praleedsuvarna/shared-libs/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const dispatchOutboxCollection = "oms_dispatch_outbox"
+
+// How long the relay owns an intent while dispatching it
+const outboxLease = 2 * time.Minute
+
+var (
+	outboxTransactionsOnce sync.Once
+	outboxTransactions     bool
+)
+
+// NewDispatchIntent asks for the given media keys of a content item to be processed,
+// e.g. {"videos": ["original"]}. Nil media processes all of the content's media.
+func NewDispatchIntent(content models.MRContent, media map[string][]string) models.DispatchIntent {
+	now := time.Now()
+	return models.DispatchIntent{
+		ID:             primitive.NewObjectID(),
+		ContentID:      content.ID,
+		OrganizationID: content.OrganizationID,
+		Media:          media,
+		Status:         "pending",
+		NextAttemptAt:  now,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+}
+
+// WriteWithOutbox runs a content write and stores its dispatch intents in one transaction,
+// so processing is never lost once the write succeeded. Deployments without transactions
+// (a standalone mongod) store the intents right after the write instead.
+func WriteWithOutbox(ctx context.Context, write func(ctx context.Context) error, intents ...models.DispatchIntent) error {
+	if len(intents) == 0 {
+		return write(ctx)
+	}
+
+	if outboxTransactionsSupported() {
+		err := runInTransaction(ctx, func(ctx context.Context) error {
+			if err := write(ctx); err != nil {
+				return err
+			}
+			return insertDispatchIntents(ctx, intents)
+		})
+		if err != nil {
+			return err
+		}
+
+		wakeOutboxRelay()
+		return nil
+	}
+
+	if err := write(ctx); err != nil {
+		return err
+	}
+
+	if err := insertDispatchIntents(ctx, intents); err != nil {
+		// The write already happened, so dispatch directly rather than failing the request
+		log.Printf("Error storing dispatch intents, dispatching directly: %v", err)
+		for _, intent := range intents {
+			RunInBackground(func() { relayDispatchIntent(intent) })
+		}
+		return nil
+	}
+
+	wakeOutboxRelay()
+	return nil
+}
+
+// runInTransaction runs fn in one transaction where the deployment supports them, and
+// directly otherwise
+func runInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !outboxTransactionsSupported() {
+		return fn(ctx)
+	}
+
+	session, err := config.DB.StartSession()
+	if err != nil {
+		return fmt.Errorf("error starting session: %w", err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessCtx)
+	})
+	return err
+}
+
+func insertDispatchIntents(ctx context.Context, intents []models.DispatchIntent) error {
+	documents := make([]interface{}, 0, len(intents))
+	for _, intent := range intents {
+		documents = append(documents, intent)
+	}
+
+	if _, err := config.GetCollection(dispatchOutboxCollection).InsertMany(ctx, documents); err != nil {
+		return fmt.Errorf("error storing dispatch intents: %w", err)
+	}
+	return nil
+}
+
+// skipDispatchIntents marks the pending intents of a content item as skipped, e.g. when it
+// is deleted, so the relay does not dispatch them. It runs on ctx to take part in the
+// caller's transaction.
+func skipDispatchIntents(ctx context.Context, contentID primitive.ObjectID) error {
+	now := time.Now()
+	_, err := config.GetCollection(dispatchOutboxCollection).UpdateMany(ctx,
+		bson.M{"content_id": contentID, "status": "pending"},
+		bson.M{"$set": bson.M{"status": "skipped", "updated_at": now}},
+	)
+	if err != nil {
+		return fmt.Errorf("error skipping dispatch intents: %w", err)
+	}
+	return nil
+}
+
+// outboxTransactionsSupported reports whether writes and intents can share a transaction.
+// OUTBOX_TRANSACTIONS=true|false overrides the detection, which asks the server whether it
+// is a replica set member or mongos.
+func outboxTransactionsSupported() bool {
+	switch config.GetEnv("OUTBOX_TRANSACTIONS", "auto") {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+
+	outboxTransactionsOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		var hello struct {
+			SetName string `bson:"setName"`
+			Msg     string `bson:"msg"`
+		}
+		err := config.DB.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+		if err != nil {
+			log.Printf("Error detecting transaction support, writing dispatch intents without transactions: %v", err)
+			return
+		}
+
+		outboxTransactions = hello.SetName != "" || hello.Msg == "isdbgrid"
+		log.Printf("Dispatch outbox transactions enabled: %t", outboxTransactions)
+	})
+
+	return outboxTransactions
+}
+
+// OutboxRelay dispatches pending intents from the outbox with retries, giving
+// at-least-once dispatch for content writes
+type OutboxRelay struct {
+	interval    time.Duration
+	maxAttempts int
+	wake        chan struct{}
+	cancel      context.CancelFunc
+	done        chan struct{}
+}
+
+// Global instance of the relay, nil until InitOutboxRelay is called
+var outboxRelay *OutboxRelay
+
+// InitOutboxRelay starts relaying dispatch intents. Every replica may run one: intents are
+// claimed atomically, so each is dispatched by one replica at a time.
+func InitOutboxRelay() *OutboxRelay {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	relay := &OutboxRelay{
+		interval:    time.Duration(getEnvInt("OUTBOX_INTERVAL_SECONDS", 5)) * time.Second,
+		maxAttempts: getEnvInt("OUTBOX_MAX_ATTEMPTS", 20),
+		wake:        make(chan struct{}, 1),
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+
+	go relay.run(ctx)
+
+	outboxRelay = relay
+	log.Printf("Dispatch outbox relay started (interval: %s, max attempts: %d)", relay.interval, relay.maxAttempts)
+	return relay
+}
+
+// StopOutboxRelay stops the global relay and waits for the intent in hand to be dispatched
+func StopOutboxRelay() {
+	if outboxRelay != nil {
+		outboxRelay.cancel()
+		<-outboxRelay.done
+		outboxRelay = nil
+		log.Println("Dispatch outbox relay stopped")
+	}
+}
+
+// wakeOutboxRelay lets the relay pick up new intents without waiting for its next tick
+func wakeOutboxRelay() {
+	if relay := outboxRelay; relay != nil {
+		select {
+		case relay.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (r *OutboxRelay) run(ctx context.Context) {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+		r.drain(ctx)
+	}
+}
+
+// drain dispatches due intents one at a time until none are left
+func (r *OutboxRelay) drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		intent, err := claimDispatchIntent()
+		if err == mongo.ErrNoDocuments {
+			return
+		}
+		if err != nil {
+			log.Printf("Error claiming dispatch intent: %v", err)
+			return
+		}
+
+		tasks, status, err := relayDispatchIntent(intent)
+		r.finish(intent, tasks, status, err)
+	}
+}
+
+// claimDispatchIntent atomically leases the next due intent and counts the attempt
+func claimDispatchIntent() (models.DispatchIntent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	var intent models.DispatchIntent
+	err := config.GetCollection(dispatchOutboxCollection).FindOneAndUpdate(ctx,
+		bson.M{
+			"status":          "pending",
+			"next_attempt_at": bson.M{"$lte": now},
+			"$or": []bson.M{
+				{"locked_until": bson.M{"$exists": false}},
+				{"locked_until": bson.M{"$lte": now}},
+			},
+		},
+		bson.M{
+			"$set": bson.M{"locked_until": now.Add(outboxLease), "updated_at": now},
+			"$inc": bson.M{"attempts": 1},
+		},
+		options.FindOneAndUpdate().
+			SetSort(bson.M{"next_attempt_at": 1}).
+			SetReturnDocument(options.After),
+	).Decode(&intent)
+
+	return intent, err
+}
+
+// relayDispatchIntent dispatches the media an intent asks for, as the content is now.
+// It returns the number of tasks recorded and "sent", or "skipped" when the content is gone.
+func relayDispatchIntent(intent models.DispatchIntent) (int, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	content, err := GetContentByID(ctx, intent.ContentID)
+	if err == mongo.ErrNoDocuments || (err == nil && !content.IsActive) {
+		return 0, "skipped", nil
+	}
+	if err != nil {
+		return 0, "", fmt.Errorf("error loading content: %w", err)
+	}
+
+	if len(intent.Media) > 0 {
+		content = selectMediaForProcessing(content, intent.Media)
+	}
+
+	tasks, err := DispatchProcessing(content, "")
+	if err != nil {
+		return 0, "", err
+	}
+	return tasks, "sent", nil
+}
+
+// finish records the outcome of an attempt, retrying with exponential backoff on errors
+func (r *OutboxRelay) finish(intent models.DispatchIntent, tasks int, status string, dispatchErr error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	set := bson.M{"updated_at": now}
+	update := bson.M{"$set": set, "$unset": bson.M{"locked_until": ""}}
+
+	switch {
+	case dispatchErr == nil:
+		set["status"] = status
+		set["tasks"] = tasks
+		set["sent_at"] = now
+	case intent.Attempts >= r.maxAttempts:
+		set["status"] = "failed"
+		set["last_error"] = dispatchErr.Error()
+		log.Printf("Giving up on dispatch intent %s for content ID %s after %d attempts: %v",
+			intent.ID.Hex(), intent.ContentID.Hex(), intent.Attempts, dispatchErr)
+	default:
+		backoff := 5 * time.Second * time.Duration(1<<uint(min(intent.Attempts-1, 7)))
+		set["last_error"] = dispatchErr.Error()
+		set["next_attempt_at"] = now.Add(backoff)
+		log.Printf("Dispatch intent %s for content ID %s failed (attempt %d), retrying in %s: %v",
+			intent.ID.Hex(), intent.ContentID.Hex(), intent.Attempts, backoff, dispatchErr)
+	}
+
+	if _, err := config.GetCollection(dispatchOutboxCollection).UpdateOne(ctx, bson.M{"_id": intent.ID}, update); err != nil {
+		log.Printf("Error updating dispatch intent %s: %v", intent.ID.Hex(), err)
+	}
+}
+
+// selectMediaForProcessing copies the identifying fields of a content item and only the
+// media named per field, so just new or changed originals are processed
+func selectMediaForProcessing(content models.MRContent, keys map[string][]string) models.MRContent {
+	selected := models.MRContent{
+		ID:             content.ID,
+		OrganizationID: content.OrganizationID,
+		UserID:         content.UserID,
+		Name:           content.Name,
+		RefID:          content.RefID,
+		RenderType:     content.RenderType,
+		HasAlpha:       content.HasAlpha,
+	}
+
+	pick := func(media []models.Media, wanted []string) []models.Media {
+		var picked []models.Media
+		for _, item := range media {
+			if utils.Contains(wanted, item.Key) {
+				picked = append(picked, item)
+			}
+		}
+		return picked
+	}
+
+	selected.Images = pick(content.Images, keys["images"])
+	selected.Videos = pick(content.Videos, keys["videos"])
+	selected.Objects_3D = pick(content.Objects_3D, keys["objects_3d"])
+
+	return selected
+}
@@ -0,0 +1,421 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/praleedsuvarna/shared-libs/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const processingQuotasCollection = "oms_processing_quotas"
+
+// Priority classes, in the order queued tasks are released
+var processingPriorities = []string{"high", "normal", "low"}
+
+// How long an organization's in-flight counter has to stay unchanged before the sweep
+// corrects it from the dispatched tasks. A release takes a slot and claims its task within
+// milliseconds, so a counter this quiet has no release between the two steps.
+const inFlightSettleTime = time.Minute
+
+// releaseLocks holds a mutex per organization so each replica runs one release of an
+// organization at a time. The in-flight counter is what enforces the quota across replicas.
+var releaseLocks struct {
+	mutex sync.Mutex
+	locks map[primitive.ObjectID]*sync.Mutex
+}
+
+// DispatchQueue periodically releases queued processing tasks. Tasks are normally
+// released as soon as results free a slot; the sweep picks up anything missed, e.g.
+// tasks queued while the message bus was down or slots freed by superseded tasks.
+type DispatchQueue struct {
+	interval time.Duration
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// Global instance of the dispatch queue, nil until InitDispatchQueue is called
+var dispatchQueue *DispatchQueue
+
+// InitDispatchQueue starts the background release of queued tasks
+func InitDispatchQueue() *DispatchQueue {
+	ensureQuotaIndex()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	queue := &DispatchQueue{
+		interval: time.Duration(getEnvInt("DISPATCH_QUEUE_INTERVAL_SECONDS", 10)) * time.Second,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+
+	go queue.run(ctx)
+
+	dispatchQueue = queue
+	log.Printf("Dispatch queue started (interval: %s, default max in flight: %d)",
+		queue.interval, getEnvInt("PROCESSING_MAX_IN_FLIGHT", 20))
+	return queue
+}
+
+// StopDispatchQueue stops the global dispatch queue and waits for the current sweep to end
+func StopDispatchQueue() {
+	if dispatchQueue != nil {
+		dispatchQueue.cancel()
+		<-dispatchQueue.done
+		dispatchQueue = nil
+		log.Println("Dispatch queue stopped")
+	}
+}
+
+// run releases queued tasks of every organization on each tick until the context is cancelled
+func (q *DispatchQueue) run(ctx context.Context) {
+	defer close(q.done)
+
+	ticker := time.NewTicker(q.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			q.sweep(ctx)
+		}
+	}
+}
+
+// sweep releases the queued tasks of each organization that has some
+func (q *DispatchQueue) sweep(ctx context.Context) {
+	queryCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	orgIDs, err := config.GetCollection(processingTasksCollection).Distinct(queryCtx,
+		"organization_id", bson.M{"status": "queued"})
+	if err != nil {
+		log.Printf("Error listing organizations with queued tasks: %v", err)
+		return
+	}
+
+	for _, value := range orgIDs {
+		if ctx.Err() != nil {
+			return
+		}
+		if orgID, ok := value.(primitive.ObjectID); ok {
+			reconcileInFlight(orgID)
+			ReleaseQueuedTasks(orgID)
+		}
+	}
+}
+
+// ReleaseQueuedTasks publishes an organization's queued tasks, highest priority and oldest
+// first, until its in-flight limit is reached or nothing is left in the queue. Each task
+// takes a slot of the organization's in-flight counter before it is claimed.
+func ReleaseQueuedTasks(orgID primitive.ObjectID) {
+	lock := organizationReleaseLock(orgID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	bus, err := GetMessageBus()
+	if err != nil {
+		log.Printf("Cannot release queued tasks, message bus unavailable: %v", err)
+		return
+	}
+
+	quota := organizationQuota(orgID)
+	if err := ensureQuotaDocument(orgID); err != nil {
+		log.Printf("Error preparing processing quota of organization %s: %v", orgID.Hex(), err)
+		return
+	}
+
+	for {
+		inFlight, err := takeDispatchSlot(orgID, quota.MaxInFlight)
+		if err == mongo.ErrNoDocuments {
+			return
+		}
+		if err != nil {
+			log.Printf("Error taking a dispatch slot of organization %s: %v", orgID.Hex(), err)
+			return
+		}
+
+		task, err := claimQueuedTask(orgID)
+		if err != nil {
+			freeDispatchSlots(orgID, 1)
+			if err != mongo.ErrNoDocuments {
+				log.Printf("Error claiming queued task of organization %s: %v", orgID.Hex(), err)
+			}
+			return
+		}
+
+		subject, err := publishTask(bus, task)
+		if err != nil {
+			// The bus is likely down; the task waits in the queue for the next sweep
+			log.Printf("Error publishing to %s, returning task %s to the queue: %v", subject, task.ID.Hex(), err)
+			requeueTask(task)
+			return
+		}
+		startTaskDeadline(task)
+
+		log.Printf("Published to %s topic for content ID: %s (%s priority, %d/%d in flight)",
+			subject, task.ContentID.Hex(), task.Priority, inFlight, quota.MaxInFlight)
+	}
+}
+
+// organizationReleaseLock returns the release mutex of an organization
+func organizationReleaseLock(orgID primitive.ObjectID) *sync.Mutex {
+	releaseLocks.mutex.Lock()
+	defer releaseLocks.mutex.Unlock()
+
+	if releaseLocks.locks == nil {
+		releaseLocks.locks = map[primitive.ObjectID]*sync.Mutex{}
+	}
+	lock, ok := releaseLocks.locks[orgID]
+	if !ok {
+		lock = &sync.Mutex{}
+		releaseLocks.locks[orgID] = lock
+	}
+	return lock
+}
+
+// ensureQuotaIndex keeps one quota document per organization, which the upserts of the
+// in-flight counter and of quota updates rely on
+func ensureQuotaIndex() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := config.GetCollection(processingQuotasCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "organization_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Printf("Error creating processing quotas index: %v", err)
+	}
+}
+
+// ensureQuotaDocument creates the quota document of an organization without one, so the
+// conditional updates of its in-flight counter have something to match
+func ensureQuotaDocument(orgID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	_, err := config.GetCollection(processingQuotasCollection).UpdateOne(ctx,
+		bson.M{"organization_id": orgID},
+		bson.M{"$setOnInsert": bson.M{"organization_id": orgID, "created_at": now, "updated_at": now}},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		// Another replica created it first
+		return nil
+	}
+	return err
+}
+
+// takeDispatchSlot increments an organization's in-flight counter if it is below the limit
+// and returns the new count, or mongo.ErrNoDocuments when every slot is taken
+func takeDispatchSlot(orgID primitive.ObjectID, maxInFlight int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var quota models.ProcessingQuota
+	err := config.GetCollection(processingQuotasCollection).FindOneAndUpdate(ctx,
+		bson.M{
+			"organization_id": orgID,
+			"$or": []bson.M{
+				{"in_flight": bson.M{"$exists": false}},
+				{"in_flight": bson.M{"$lt": maxInFlight}},
+			},
+		},
+		bson.M{
+			"$inc": bson.M{"in_flight": 1},
+			"$set": bson.M{"in_flight_changed_at": time.Now()},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&quota)
+
+	return quota.InFlight, err
+}
+
+// freeDispatchSlots gives back slots of an organization's in-flight counter, when a claim
+// found nothing or dispatched tasks closed
+func freeDispatchSlots(orgID primitive.ObjectID, count int64) {
+	if count <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := config.GetCollection(processingQuotasCollection).UpdateOne(ctx,
+		bson.M{"organization_id": orgID},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"in_flight": bson.M{"$max": bson.A{0, bson.M{"$subtract": bson.A{
+				bson.M{"$ifNull": bson.A{"$in_flight", 0}}, count,
+			}}}},
+			"in_flight_changed_at": time.Now(),
+		}}}},
+	)
+	if err != nil {
+		log.Printf("Error freeing %d dispatch slot(s) of organization %s: %v", count, orgID.Hex(), err)
+	}
+}
+
+// reconcileInFlight sets an organization's in-flight counter to its number of dispatched
+// tasks, e.g. after a replica died between taking a slot and claiming a task. Counters that
+// changed within inFlightSettleTime are left for a later sweep.
+func reconcileInFlight(orgID primitive.ObjectID) {
+	dispatched, err := countTasks(orgID, "dispatched")
+	if err != nil {
+		log.Printf("Error counting in-flight tasks of organization %s: %v", orgID.Hex(), err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	result, err := config.GetCollection(processingQuotasCollection).UpdateOne(ctx,
+		bson.M{
+			"organization_id": orgID,
+			"in_flight":       bson.M{"$ne": dispatched},
+			"$or": []bson.M{
+				{"in_flight_changed_at": bson.M{"$exists": false}},
+				{"in_flight_changed_at": bson.M{"$lt": now.Add(-inFlightSettleTime)}},
+			},
+		},
+		bson.M{"$set": bson.M{"in_flight": dispatched, "in_flight_changed_at": now}},
+	)
+	if err != nil {
+		log.Printf("Error reconciling in-flight count of organization %s: %v", orgID.Hex(), err)
+		return
+	}
+	if result.ModifiedCount > 0 {
+		log.Printf("Corrected in-flight count of organization %s to %d", orgID.Hex(), dispatched)
+	}
+}
+
+// claimQueuedTask atomically moves the next queued task of an organization to dispatched.
+// The short deadline lets the watchdog publish it should this replica die before it does.
+func claimQueuedTask(orgID primitive.ObjectID) (models.ProcessingTask, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	var task models.ProcessingTask
+	err := config.GetCollection(processingTasksCollection).FindOneAndUpdate(ctx,
+		bson.M{"organization_id": orgID, "status": "queued"},
+		bson.M{"$set": bson.M{
+			"status":        "dispatched",
+			"attempts":      1,
+			"deadline_at":   now.Add(time.Minute),
+			"dispatched_at": now,
+			"updated_at":    now,
+		}},
+		options.FindOneAndUpdate().
+			SetSort(bson.D{{Key: "priority_rank", Value: 1}, {Key: "queued_at", Value: 1}}).
+			SetReturnDocument(options.After),
+	).Decode(&task)
+
+	return task, err
+}
+
+// requeueTask puts a claimed task that couldn't be published back in the queue and gives
+// back its dispatch slot. Should this fail, the watchdog publishes the task once its short
+// claim deadline passes.
+func requeueTask(task models.ProcessingTask) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := config.GetCollection(processingTasksCollection).UpdateOne(ctx,
+		bson.M{"_id": task.ID, "status": "dispatched", "attempts": 1},
+		bson.M{"$set": bson.M{"status": "queued", "attempts": 0, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		log.Printf("Error returning processing task %s to the queue: %v", task.ID.Hex(), err)
+		return
+	}
+	if result.ModifiedCount > 0 {
+		freeDispatchSlots(task.OrganizationID, 1)
+	}
+}
+
+// publishTask publishes a recorded task, routed by its priority class, and returns the subject used.
+// PRIORITY_ROUTING=subject sends non-normal priorities to "<subject>.<priority>"; the X-Priority
+// header is always set.
+func publishTask(bus MessageBus, task models.ProcessingTask) (string, error) {
+	subject := task.Subject
+	if config.GetEnv("PRIORITY_ROUTING", "header") == "subject" && task.Priority != "" && task.Priority != "normal" {
+		subject += "." + task.Priority
+	}
+
+	header := correlationHeaders(task.ID.Hex(), task.CorrelationID, task.RequestVersion)
+	if task.Priority != "" {
+		header[HeaderPriority] = task.Priority
+	}
+
+	return subject, publishMessage(bus, json.RawMessage(task.Payload), subject, header)
+}
+
+// startTaskDeadline gives a freshly published task the full processing timeout of its subject
+func startTaskDeadline(task models.ProcessingTask) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := config.GetCollection(processingTasksCollection).UpdateOne(ctx,
+		bson.M{"_id": task.ID, "status": "dispatched", "attempts": 1},
+		bson.M{"$set": bson.M{"deadline_at": task.DispatchedAt.Add(processingTimeout(task.Subject))}},
+	)
+	if err != nil {
+		log.Printf("Error setting deadline of processing task %s: %v", task.ID.Hex(), err)
+	}
+}
+
+// organizationQuota returns an organization's quota, filling in the defaults from
+// PROCESSING_MAX_IN_FLIGHT and PROCESSING_DEFAULT_PRIORITY
+func organizationQuota(orgID primitive.ObjectID) models.ProcessingQuota {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	quota := models.ProcessingQuota{OrganizationID: orgID}
+	err := config.GetCollection(processingQuotasCollection).FindOne(ctx, bson.M{"organization_id": orgID}).Decode(&quota)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Printf("Error loading processing quota of organization %s: %v", orgID.Hex(), err)
+	}
+
+	if quota.MaxInFlight <= 0 {
+		quota.MaxInFlight = getEnvInt("PROCESSING_MAX_IN_FLIGHT", 20)
+	}
+	if priorityRank(quota.Priority) < 0 {
+		quota.Priority = config.GetEnv("PROCESSING_DEFAULT_PRIORITY", "normal")
+		if priorityRank(quota.Priority) < 0 {
+			quota.Priority = "normal"
+		}
+	}
+
+	return quota
+}
+
+// priorityRank returns the release order of a priority class, or -1 for an unknown class
+func priorityRank(priority string) int {
+	for rank, name := range processingPriorities {
+		if name == priority {
+			return rank
+		}
+	}
+	return -1
+}
+
+// countTasks counts an organization's tasks in the given status
+func countTasks(orgID primitive.ObjectID, status string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return config.GetCollection(processingTasksCollection).CountDocuments(ctx,
+		bson.M{"organization_id": orgID, "status": status})
+}
@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// unreachableBus is a message bus whose publishes fail, as during an outage
+type unreachableBus struct {
+	*memoryBus
+}
+
+func (unreachableBus) Publish(msg *Message) error {
+	return fmt.Errorf("connection lost")
+}
+
+// useTestMessageBus replaces the global message bus for the duration of a test
+func useTestMessageBus(t *testing.T, bus MessageBus) {
+	t.Helper()
+
+	messageBusMutex.Lock()
+	previous := messageBus
+	messageBus = bus
+	messageBusMutex.Unlock()
+
+	t.Cleanup(func() {
+		messageBusMutex.Lock()
+		messageBus = previous
+		messageBusMutex.Unlock()
+	})
+}
+
+// insertQueuedTasks queues count tasks of an organization
+func insertQueuedTasks(t *testing.T, database *mongo.Database, orgID primitive.ObjectID, count int) {
+	t.Helper()
+
+	now := time.Now()
+	for i := 0; i < count; i++ {
+		_, err := database.Collection(processingTasksCollection).InsertOne(context.Background(), models.ProcessingTask{
+			ID:             primitive.NewObjectID(),
+			ContentID:      primitive.NewObjectID(),
+			OrganizationID: orgID,
+			Subject:        "compressimage",
+			MediaType:      "image",
+			Payload:        "{}",
+			Outcomes:       []models.ExpectedOutcome{{ResultType: "compressed", Status: "pending"}},
+			Status:         "queued",
+			Priority:       "normal",
+			PriorityRank:   priorityRank("normal"),
+			QueuedAt:       now.Add(time.Duration(i) * time.Millisecond),
+			UpdatedAt:      now,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// quotaInFlight returns an organization's in-flight counter
+func quotaInFlight(t *testing.T, database *mongo.Database, orgID primitive.ObjectID) int {
+	t.Helper()
+
+	var quota models.ProcessingQuota
+	err := database.Collection(processingQuotasCollection).FindOne(context.Background(), bson.M{"organization_id": orgID}).Decode(&quota)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return quota.InFlight
+}
+
+// TestDispatchSlotsNeverExceedQuota takes slots from many goroutines at once, as releases on
+// several replicas do, and releases queued tasks concurrently. It needs a MongoDB server in
+// MONGO_TEST_URI.
+func TestDispatchSlotsNeverExceedQuota(t *testing.T) {
+	database := connectTestDatabase(t)
+	ensureQuotaIndex()
+	useTestMessageBus(t, newMemoryBus())
+	t.Setenv("PROCESSING_MAX_IN_FLIGHT", "3")
+
+	t.Run("slots", func(t *testing.T) {
+		orgID := primitive.NewObjectID()
+		if err := ensureQuotaDocument(orgID); err != nil {
+			t.Fatal(err)
+		}
+
+		var taken sync.WaitGroup
+		var mutex sync.Mutex
+		granted := 0
+		for i := 0; i < 20; i++ {
+			taken.Add(1)
+			go func() {
+				defer taken.Done()
+				_, err := takeDispatchSlot(orgID, 3)
+				if err == nil {
+					mutex.Lock()
+					granted++
+					mutex.Unlock()
+				} else if err != mongo.ErrNoDocuments {
+					t.Error(err)
+				}
+			}()
+		}
+		taken.Wait()
+
+		if granted != 3 {
+			t.Errorf("granted %d slots, want 3", granted)
+		}
+		if inFlight := quotaInFlight(t, database, orgID); inFlight != 3 {
+			t.Errorf("in_flight = %d, want 3", inFlight)
+		}
+	})
+
+	t.Run("releases", func(t *testing.T) {
+		orgID := primitive.NewObjectID()
+		insertQueuedTasks(t, database, orgID, 12)
+
+		var released sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			released.Add(1)
+			go func() {
+				defer released.Done()
+				ReleaseQueuedTasks(orgID)
+			}()
+		}
+		released.Wait()
+
+		dispatched, err := countTasks(orgID, "dispatched")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dispatched != 3 {
+			t.Errorf("%d tasks dispatched, want 3", dispatched)
+		}
+		if inFlight := quotaInFlight(t, database, orgID); inFlight != 3 {
+			t.Errorf("in_flight = %d, want 3", inFlight)
+		}
+	})
+}
+
+// TestReleaseRequeuesOnPublishFailure checks a message bus outage leaves tasks queued instead
+// of failing them. It needs a MongoDB server in MONGO_TEST_URI.
+func TestReleaseRequeuesOnPublishFailure(t *testing.T) {
+	database := connectTestDatabase(t)
+	ensureQuotaIndex()
+	useTestMessageBus(t, unreachableBus{newMemoryBus()})
+	t.Setenv("PROCESSING_MAX_IN_FLIGHT", "3")
+
+	orgID := primitive.NewObjectID()
+	insertQueuedTasks(t, database, orgID, 2)
+
+	ReleaseQueuedTasks(orgID)
+
+	for _, status := range []string{"queued", "dispatched", "failed"} {
+		count, err := countTasks(orgID, status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := int64(0)
+		if status == "queued" {
+			want = 2
+		}
+		if count != want {
+			t.Errorf("%d tasks %s, want %d", count, status, want)
+		}
+	}
+	if inFlight := quotaInFlight(t, database, orgID); inFlight != 0 {
+		t.Errorf("in_flight = %d, want 0", inFlight)
+	}
+}
@@ -0,0 +1,16 @@
+package controllers
+
+import (
+	"strconv"
+
+	"github.com/praleedsuvarna/shared-libs/config"
+)
+
+// getEnvInt reads a positive integer from the environment, falling back to the default
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(config.GetEnv(key, ""))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Background work started by requests and result handlers, which shutdown waits for
+var background struct {
+	work          sync.WaitGroup
+	mutex         sync.Mutex
+	draining      bool
+	shuttingDown  bool
+	subscriptions []Subscription
+}
+
+// RunInBackground runs fn in its own goroutine and tracks it so shutdown can wait for it.
+// Work started after draining began runs in the caller instead, which shutdown is already
+// waiting on.
+func RunInBackground(fn func()) {
+	background.mutex.Lock()
+	if background.draining {
+		background.mutex.Unlock()
+		fn()
+		return
+	}
+	background.work.Add(1)
+	background.mutex.Unlock()
+
+	go func() {
+		defer background.work.Done()
+		fn()
+	}()
+}
+
+// trackSubscription remembers a long-lived subscription so it is drained on shutdown
+func trackSubscription(sub Subscription) {
+	background.mutex.Lock()
+	background.subscriptions = append(background.subscriptions, sub)
+	background.mutex.Unlock()
+}
+
+// ShuttingDown reports whether shutdown has started draining the service
+func ShuttingDown() bool {
+	background.mutex.Lock()
+	defer background.mutex.Unlock()
+	return background.shuttingDown
+}
+
+// ShutdownTimeout is how long shutdown waits for open requests (SHUTDOWN_TIMEOUT_SECONDS).
+// Together with DrainTimeout, keep it below the grace period the platform allows between
+// SIGTERM and SIGKILL.
+func ShutdownTimeout() time.Duration {
+	return time.Duration(getEnvInt("SHUTDOWN_TIMEOUT_SECONDS", 10)) * time.Second
+}
+
+// DrainTimeout is how long shutdown then waits for message handlers and background work
+// (DRAIN_TIMEOUT_SECONDS)
+func DrainTimeout() time.Duration {
+	return time.Duration(getEnvInt("DRAIN_TIMEOUT_SECONDS", 15)) * time.Second
+}
+
+// DrainBackgroundWork stops the message bus subscriptions once the messages they already received
+// are handled, then waits for background work until ctx is done. The bus itself stays
+// open so the work can still publish; CloseMessageBus flushes and closes it afterwards.
+func DrainBackgroundWork(ctx context.Context) error {
+	background.mutex.Lock()
+	background.shuttingDown = true
+	subscriptions := background.subscriptions
+	background.subscriptions = nil
+	background.mutex.Unlock()
+
+	for _, sub := range subscriptions {
+		if err := sub.Drain(); err != nil {
+			log.Printf("Error draining subscription to %s: %v", sub.Subject(), err)
+		}
+	}
+	for _, sub := range subscriptions {
+		for sub.IsValid() && ctx.Err() == nil {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	// From here on new work runs inline, so nothing is added to the group while waiting
+	background.mutex.Lock()
+	background.draining = true
+	background.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		background.work.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -3,31 +3,405 @@ package controllers
 import (
 	"MRContent/models"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
-	"strings"
-	"sync"
 	"time"
 
 	"github.com/praleedsuvarna/shared-libs/config"
+	"github.com/praleedsuvarna/shared-libs/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// MediaProcessingTracker keeps track of ongoing media processing tasks
-type MediaProcessingTracker struct {
-	mutex           sync.Mutex
-	processingTasks map[string]int // Maps contentID to count of pending tasks
+const processingTasksCollection = "oms_processing_tasks"
+
+// Errors returned when a result cannot be matched to the work that produced it
+var (
+	ErrUnknownTask     = errors.New("result does not belong to a known processing task")
+	ErrTaskMismatch    = errors.New("result does not match its processing task")
+	ErrTaskSuperseded  = errors.New("processing task was superseded by a newer request")
+	ErrDuplicateResult = errors.New("result was already received for its processing task")
+)
+
+// RecordDispatch stores a processing task with the outcomes expected for a message
+// before it is published, so results can never arrive for an unknown task. The task
+// starts out queued; the dispatch queue publishes it once the organization has a free
+// slot. The generated task ID is written back into the dispatch request.
+func RecordDispatch(content models.MRContent, dispatch *plannedDispatch, priority string) (primitive.ObjectID, error) {
+	taskID := primitive.NewObjectID()
+	dispatch.Request.TaskID = taskID.Hex()
+
+	payload, err := json.Marshal(dispatch.Request)
+	if err != nil {
+		return primitive.NilObjectID, fmt.Errorf("error marshaling request: %w", err)
+	}
+
+	now := time.Now()
+	task := models.ProcessingTask{
+		ID:             taskID,
+		ContentID:      content.ID,
+		OrganizationID: content.OrganizationID,
+		Subject:        dispatch.Subject,
+		MediaType:      dispatch.MediaType,
+		SourceKey:      dispatch.SourceKey,
+		SourceURL:      dispatch.SourceURL,
+		CorrelationID:  dispatch.Request.CorrelationID,
+		RequestVersion: dispatch.Request.RequestVersion,
+		Payload:        string(payload),
+		Status:         "queued",
+		Priority:       priority,
+		PriorityRank:   priorityRank(priority),
+		QueuedAt:       now,
+		UpdatedAt:      now,
+	}
+
+	for _, resultType := range dispatch.ExpectedResults {
+		task.Outcomes = append(task.Outcomes, models.ExpectedOutcome{
+			ResultType: resultType,
+			Status:     "pending",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := config.GetCollection(processingTasksCollection).InsertOne(ctx, task); err != nil {
+		return primitive.NilObjectID, fmt.Errorf("error recording processing task: %w", err)
+	}
+
+	return task.ID, nil
+}
+
+// FailDispatch marks every pending outcome of a dispatched task as failed, e.g. when
+// publishing it failed, and frees its dispatch slot
+func FailDispatch(taskID primitive.ObjectID, reason string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	var task models.ProcessingTask
+	err := config.GetCollection(processingTasksCollection).FindOneAndUpdate(ctx,
+		bson.M{"_id": taskID, "status": "dispatched"},
+		bson.M{"$set": bson.M{
+			"outcomes.$[pending].status":      "failed",
+			"outcomes.$[pending].error":       reason,
+			"outcomes.$[pending].received_at": now,
+			"status":                          "failed",
+			"completed_at":                    now,
+			"updated_at":                      now,
+		}},
+		options.FindOneAndUpdate().
+			SetArrayFilters(options.ArrayFilters{
+				Filters: []interface{}{bson.M{"pending.status": "pending"}},
+			}).
+			SetProjection(bson.M{"organization_id": 1}),
+	).Decode(&task)
+	if err == mongo.ErrNoDocuments {
+		// Closed in the meantime
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error failing processing task: %w", err)
+	}
+
+	freeDispatchSlots(task.OrganizationID, 1)
+	return nil
+}
+
+// SupersedeTasks closes the still-open tasks of earlier runs for the same source asset,
+// so their late results are recognised and not applied over newer renditions. Queued
+// tasks of those runs are superseded before they are ever published.
+func SupersedeTasks(contentID, orgID primitive.ObjectID, mediaType, sourceKey string, requestVersion int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Queued and dispatched tasks are superseded separately to know how many slots to free
+	now := time.Now()
+	var superseded int64
+	for _, status := range []string{"queued", "dispatched"} {
+		result, err := config.GetCollection(processingTasksCollection).UpdateMany(ctx,
+			bson.M{
+				"content_id":      contentID,
+				"media_type":      mediaType,
+				"source_key":      sourceKey,
+				"status":          status,
+				"request_version": bson.M{"$lt": requestVersion},
+			},
+			bson.M{"$set": bson.M{
+				"outcomes.$[pending].status":      "superseded",
+				"outcomes.$[pending].received_at": now,
+				"status":                          "superseded",
+				"completed_at":                    now,
+				"updated_at":                      now,
+			}},
+			options.Update().SetArrayFilters(options.ArrayFilters{
+				Filters: []interface{}{bson.M{"pending.status": "pending"}},
+			}),
+		)
+		if err != nil {
+			return fmt.Errorf("error superseding processing tasks: %w", err)
+		}
+
+		superseded += result.ModifiedCount
+		if status == "dispatched" {
+			freeDispatchSlots(orgID, result.ModifiedCount)
+		}
+	}
+
+	if superseded > 0 {
+		log.Printf("Superseded %d earlier %s task(s) for %s of content ID %s",
+			superseded, mediaType, sourceKey, contentID.Hex())
+	}
+
+	return nil
+}
+
+// SupersedeContentTasks closes every open task of a content item, e.g. when it is deleted,
+// so queued tasks are never published and late results are turned away. It runs on ctx to
+// take part in the caller's transaction and returns the number of dispatched tasks whose
+// slots the caller must free once the write is committed.
+func SupersedeContentTasks(ctx context.Context, contentID primitive.ObjectID) (int64, error) {
+	now := time.Now()
+	var dispatched int64
+	for _, status := range []string{"queued", "dispatched"} {
+		result, err := config.GetCollection(processingTasksCollection).UpdateMany(ctx,
+			bson.M{"content_id": contentID, "status": status},
+			bson.M{"$set": bson.M{
+				"outcomes.$[pending].status":      "superseded",
+				"outcomes.$[pending].received_at": now,
+				"status":                          "superseded",
+				"completed_at":                    now,
+				"updated_at":                      now,
+			}},
+			options.Update().SetArrayFilters(options.ArrayFilters{
+				Filters: []interface{}{bson.M{"pending.status": "pending"}},
+			}),
+		)
+		if err != nil {
+			return 0, fmt.Errorf("error superseding processing tasks: %w", err)
+		}
+
+		if status == "dispatched" {
+			dispatched = result.ModifiedCount
+		}
+	}
+
+	return dispatched, nil
+}
+
+// VerifyResultTask checks that a result carrying a task ID answers a task we dispatched and
+// is still waiting for it. Redelivered and replayed results of a closed task, or of an
+// outcome that already arrived, are reported as duplicates. Results without a task ID are
+// only accepted while REQUIRE_TASK_ID is not enabled.
+func VerifyResultTask(result MediaProcessResult) error {
+	if result.TaskID == "" {
+		if config.GetEnv("REQUIRE_TASK_ID", "false") == "true" {
+			return ErrUnknownTask
+		}
+		return nil
+	}
+
+	taskID, err := primitive.ObjectIDFromHex(result.TaskID)
+	if err != nil {
+		return ErrUnknownTask
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var task models.ProcessingTask
+	err = config.GetCollection(processingTasksCollection).FindOne(ctx, bson.M{"_id": taskID}).Decode(&task)
+	if err == mongo.ErrNoDocuments {
+		return ErrUnknownTask
+	}
+	if err != nil {
+		return fmt.Errorf("error loading processing task: %w", err)
+	}
+
+	if task.ContentID.Hex() != result.ContentID ||
+		(result.CorrelationID != "" && result.CorrelationID != task.CorrelationID) ||
+		(result.RequestVersion != 0 && result.RequestVersion != task.RequestVersion) {
+		return ErrTaskMismatch
+	}
+
+	if task.Status == "superseded" {
+		return ErrTaskSuperseded
+	}
+	if task.Status != "dispatched" {
+		return ErrDuplicateResult
+	}
+
+	// An open task may still be waiting for its other outcomes
+	expected, pending := false, false
+	for _, outcome := range task.Outcomes {
+		if outcome.ResultType == result.ProcessingType {
+			expected = true
+			pending = pending || outcome.Status == "pending"
+		}
+	}
+	if expected && !pending {
+		return ErrDuplicateResult
+	}
+
+	return nil
+}
+
+// ResultClaim is the pending outcome of a dispatched task that a result has claimed
+type ResultClaim struct {
+	Task       models.ProcessingTask // The task as it is after the claim
+	ResultType string                // Processing type of the result, empty when it carried none
+	ReceivedAt time.Time             // Marks the claimed outcome
+}
+
+// ClaimResult atomically marks the pending outcome a result answers as received, before the
+// result writes anything. Only one delivery of a result can claim its outcome, so redeliveries
+// and late results of superseded runs are turned away. It returns nil when no dispatched task
+// was waiting for this result.
+func ClaimResult(result MediaProcessResult) (*ResultClaim, error) {
+	contentID, err := primitive.ObjectIDFromHex(result.ContentID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid content ID format: %w", err)
+	}
+
+	// Match on the most specific fields the result carries
+	elemMatch := bson.M{"status": "pending"}
+	if result.ProcessingType != "" {
+		elemMatch["result_type"] = result.ProcessingType
+	}
+
+	filter := bson.M{
+		"content_id": contentID,
+		"status":     "dispatched",
+		"outcomes":   bson.M{"$elemMatch": elemMatch},
+	}
+	if result.MediaType != "" {
+		filter["media_type"] = result.MediaType
+	}
+	if result.RequestVersion != 0 {
+		filter["request_version"] = result.RequestVersion
+	}
+
+	// A task ID identifies the task exactly. Without one, try the source URL first and
+	// then fall back to any task of the content, since some results (e.g. stitched
+	// videos) report a different original URL
+	filters := []bson.M{filter}
+	if taskID, err := primitive.ObjectIDFromHex(result.TaskID); err == nil {
+		filter["_id"] = taskID
+	} else if result.OriginalURL != "" {
+		strict := bson.M{"source_url": result.OriginalURL}
+		for k, v := range filter {
+			strict[k] = v
+		}
+		filters = []bson.M{strict, filter}
+	}
+
+	outcomeStatus := "succeeded"
+	if !result.Success {
+		outcomeStatus = "failed"
+	}
+
+	// MongoDB keeps milliseconds, so the claim can find its outcome again by this time
+	now := time.Now().Truncate(time.Millisecond)
+	collection := config.GetCollection(processingTasksCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The positional operator updates the outcome matched by $elemMatch; the oldest
+	// dispatch is answered first when the same source was sent more than once
+	var task models.ProcessingTask
+	for _, candidate := range filters {
+		err = collection.FindOneAndUpdate(ctx, candidate,
+			bson.M{"$set": bson.M{
+				"outcomes.$.status":      outcomeStatus,
+				"outcomes.$.error":       result.Error,
+				"outcomes.$.received_at": now,
+				"updated_at":             now,
+			}},
+			options.FindOneAndUpdate().
+				SetSort(bson.M{"dispatched_at": 1}).
+				SetReturnDocument(options.After),
+		).Decode(&task)
+		if err != mongo.ErrNoDocuments {
+			break
+		}
+	}
+
+	if err == mongo.ErrNoDocuments {
+		log.Printf("No pending task found for %s %s result of content ID %s (original: %s)",
+			result.MediaType, result.ProcessingType, result.ContentID, result.OriginalURL)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error matching result to processing task: %w", err)
+	}
+
+	return &ResultClaim{Task: task, ResultType: result.ProcessingType, ReceivedAt: now}, nil
 }
 
-// NewMediaProcessingTracker creates a new tracker
-func NewMediaProcessingTracker() *MediaProcessingTracker {
-	return &MediaProcessingTracker{
-		processingTasks: make(map[string]int),
+// ReleaseResultClaim puts a claimed outcome back to pending when its result couldn't be
+// stored, so a redelivery of the result can claim it again
+func ReleaseResultClaim(claim *ResultClaim) {
+	elemMatch := bson.M{"received_at": claim.ReceivedAt}
+	if claim.ResultType != "" {
+		elemMatch["result_type"] = claim.ResultType
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := config.GetCollection(processingTasksCollection).UpdateOne(ctx,
+		bson.M{
+			"_id":      claim.Task.ID,
+			"status":   "dispatched",
+			"outcomes": bson.M{"$elemMatch": elemMatch},
+		},
+		bson.M{
+			"$set":   bson.M{"outcomes.$.status": "pending", "updated_at": time.Now()},
+			"$unset": bson.M{"outcomes.$.error": "", "outcomes.$.received_at": ""},
+		},
+	)
+	if err != nil {
+		log.Printf("Error releasing result claim on processing task %s: %v", claim.Task.ID.Hex(), err)
 	}
 }
 
-// Global instance of the tracker
-var mediaTracker = NewMediaProcessingTracker()
+// CompleteResultClaim closes the claimed task once every outcome it expected has arrived
+// and frees its dispatch slot
+func CompleteResultClaim(claim *ResultClaim) {
+	task := claim.Task
+	if countPendingOutcomes(task) > 0 {
+		return
+	}
+
+	status := "completed"
+	for _, outcome := range task.Outcomes {
+		if outcome.Status == "failed" {
+			status = "failed"
+			break
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	closed, err := config.GetCollection(processingTasksCollection).UpdateOne(ctx,
+		bson.M{"_id": task.ID, "status": "dispatched"},
+		bson.M{"$set": bson.M{"status": status, "completed_at": now, "updated_at": now}},
+	)
+	if err != nil {
+		log.Printf("Error closing processing task %s: %v", task.ID.Hex(), err)
+	} else if closed.ModifiedCount > 0 {
+		freeDispatchSlots(task.OrganizationID, 1)
+	}
+
+	// The organization has a free slot now
+	ReleaseQueuedTasks(task.OrganizationID)
+}
 
 // TrackProcessingStart registers the start of media processing for a content item
 // and updates its status to "processing"
@@ -36,11 +410,6 @@ func TrackProcessingStart(contentID string, taskCount int) error {
 		return nil // No tasks to track
 	}
 
-	// Update tracker
-	mediaTracker.mutex.Lock()
-	mediaTracker.processingTasks[contentID] = taskCount
-	mediaTracker.mutex.Unlock()
-
 	// Update content status to "processing"
 	objContentID, err := primitive.ObjectIDFromHex(contentID)
 	if err != nil {
@@ -59,18 +428,20 @@ func TrackProcessingStart(contentID string, taskCount int) error {
 		return err
 	}
 
-	// Only update to "processing" if it's currently "draft"
-	if content.Status == "draft" {
+	// Only update to "processing" if it's currently "draft", or stalled/failed from an earlier run
+	restartable := []string{"draft", "stalled", "failed"}
+	if utils.Contains(restartable, content.Status) {
 		updateData := bson.M{
 			"$set": bson.M{
 				"status":     "processing",
 				"updated_at": time.Now(),
 			},
+			"$unset": bson.M{"status_reason": ""},
 		}
 
 		result, err := collection.UpdateOne(
 			ctx,
-			bson.M{"_id": objContentID, "status": "draft"},
+			bson.M{"_id": objContentID, "status": bson.M{"$in": restartable}},
 			updateData,
 		)
 
@@ -81,6 +452,10 @@ func TrackProcessingStart(contentID string, taskCount int) error {
 
 		if result.ModifiedCount > 0 {
 			log.Printf("Content %s status changed to 'processing', tracking %d tasks", contentID, taskCount)
+			EmitProcessingEvent(contentID, "status", map[string]interface{}{
+				"status":      "processing",
+				"total_tasks": taskCount,
+			})
 		} else {
 			log.Printf("Content %s status was not updated (may not be in 'draft' state)", contentID)
 		}
@@ -91,36 +466,26 @@ func TrackProcessingStart(contentID string, taskCount int) error {
 	return nil
 }
 
-// TrackProcessingComplete registers the completion of a media processing task
-// and updates content status to "processed" when all tasks are done
+// TrackProcessingComplete checks the recorded tasks of a content item once no dispatched
+// outcome is still pending and settles its status: "processed" when every outcome of the
+// run succeeded, "failed" otherwise. The matching webhook is sent once, on that change.
 func TrackProcessingComplete(contentID string) error {
-	// Update tracker
-	mediaTracker.mutex.Lock()
-
-	// If no tracking entry exists, nothing to do
-	if _, exists := mediaTracker.processingTasks[contentID]; !exists {
-		mediaTracker.mutex.Unlock()
-		log.Printf("No tracking information found for content ID: %s", contentID)
-		return nil
+	remainingTasks, err := countRemainingOutcomes(contentID)
+	if err != nil {
+		return err
 	}
 
-	// Decrement task count
-	mediaTracker.processingTasks[contentID]--
-	remainingTasks := mediaTracker.processingTasks[contentID]
+	EmitProcessingEvent(contentID, "progress", map[string]interface{}{
+		"remaining_tasks": remainingTasks,
+	})
 
-	// If no tasks remaining, remove from tracker
-	if remainingTasks <= 0 {
-		delete(mediaTracker.processingTasks, contentID)
-		log.Printf("All processing tasks completed for content ID: %s", contentID)
-	} else {
+	if remainingTasks > 0 {
 		log.Printf("Content %s has %d remaining processing tasks", contentID, remainingTasks)
-		mediaTracker.mutex.Unlock()
 		return nil
 	}
 
-	mediaTracker.mutex.Unlock()
+	log.Printf("All processing tasks completed for content ID: %s", contentID)
 
-	// All tasks complete, update content status to "processed"
 	objContentID, err := primitive.ObjectIDFromHex(contentID)
 	if err != nil {
 		return err
@@ -138,50 +503,112 @@ func TrackProcessingComplete(contentID string) error {
 		return err
 	}
 
-	// Only update to "processed" if it's currently "processing"
-	if content.Status == "processing" {
-		updateData := bson.M{
-			"$set": bson.M{
-				"status":     "processed",
-				"updated_at": time.Now(),
-			},
-		}
+	if content.Status != "processing" {
+		log.Printf("Content %s is in '%s' state, not settling its processing status", contentID, content.Status)
+		return nil
+	}
 
-		result, err := collection.UpdateOne(
-			ctx,
-			bson.M{"_id": objContentID, "status": "processing"},
-			updateData,
-		)
+	failures, err := runFailures(ctx, content)
+	if err != nil {
+		return err
+	}
 
-		if err != nil {
-			log.Printf("Error updating content status to processed: %v", err)
-			return err
-		}
+	status := "processed"
+	update := bson.M{
+		"$set":   bson.M{"status": status, "updated_at": time.Now()},
+		"$unset": bson.M{"status_reason": ""},
+	}
+	reason := ""
+	if len(failures) > 0 {
+		status = "failed"
+		reason = fmt.Sprintf("%d of the processing outcomes failed", len(failures))
+		update = bson.M{"$set": bson.M{"status": status, "status_reason": reason, "updated_at": time.Now()}}
+	}
 
-		if result.ModifiedCount > 0 {
-			log.Printf("Content %s status changed to 'processed', all tasks completed", contentID)
-		} else {
-			log.Printf("Content %s status was not updated to 'processed', may have been manually changed", contentID)
-		}
-	} else {
-		log.Printf("Content %s is in '%s' state, not changing to 'processed'", contentID, content.Status)
+	// Only the replica that moves the content out of "processing" notifies
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": objContentID, "status": "processing"}, update)
+	if err != nil {
+		log.Printf("Error updating content status to %s: %v", status, err)
+		return err
 	}
 
+	if result.ModifiedCount == 0 {
+		log.Printf("Content %s status was not updated to '%s', may have been manually changed", contentID, status)
+		return nil
+	}
+
+	log.Printf("Content %s status changed to '%s', all tasks completed", contentID, status)
+	content.Status = status
+
+	if status == "failed" {
+		EmitProcessingEvent(contentID, "status", map[string]interface{}{"status": status, "reason": reason})
+
+		payload := transformMRContentResponse(content)
+		payload["reason"] = reason
+		payload["failures"] = failures
+		EmitWebhookEvent(content.OrganizationID, WebhookContentFailed, payload)
+		return nil
+	}
+
+	EmitProcessingEvent(contentID, "status", map[string]interface{}{"status": status})
+	EmitWebhookEvent(content.OrganizationID, WebhookContentProcessed, transformMRContentResponse(content))
+
 	return nil
 }
 
+// runFailures lists the failed outcomes of the content's current processing run
+func runFailures(ctx context.Context, content models.MRContent) ([]map[string]interface{}, error) {
+	filter := bson.M{
+		"content_id":      content.ID,
+		"outcomes.status": "failed",
+	}
+	if content.ProcessingVersion > 0 {
+		filter["request_version"] = content.ProcessingVersion
+	}
+
+	cursor, err := config.GetCollection(processingTasksCollection).Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("error loading failed processing tasks: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var tasks []models.ProcessingTask
+	if err := cursor.All(ctx, &tasks); err != nil {
+		return nil, fmt.Errorf("error decoding failed processing tasks: %w", err)
+	}
+
+	var failures []map[string]interface{}
+	for _, task := range tasks {
+		for _, outcome := range task.Outcomes {
+			if outcome.Status != "failed" {
+				continue
+			}
+			failures = append(failures, map[string]interface{}{
+				"task_id":         task.ID.Hex(),
+				"media_type":      task.MediaType,
+				"original_url":    task.SourceURL,
+				"processing_type": outcome.ResultType,
+				"error":           outcome.Error,
+			})
+		}
+	}
+
+	return failures, nil
+}
+
 // GetProcessingStatus returns the current processing status for a content item
 func GetProcessingStatus(contentID string) (string, int, error) {
-	// First check if it's still in our tracker
-	mediaTracker.mutex.Lock()
-	remainingTasks, exists := mediaTracker.processingTasks[contentID]
-	mediaTracker.mutex.Unlock()
+	// Outstanding outcomes mean the content is still processing
+	remainingTasks, err := countRemainingOutcomes(contentID)
+	if err != nil {
+		return "", 0, err
+	}
 
-	if exists {
+	if remainingTasks > 0 {
 		return "processing", remainingTasks, nil
 	}
 
-	// If not in tracker, check database
+	// Otherwise report the stored status
 	objContentID, err := primitive.ObjectIDFromHex(contentID)
 	if err != nil {
 		return "", 0, err
@@ -200,55 +627,64 @@ func GetProcessingStatus(contentID string) (string, int, error) {
 	return content.Status, 0, nil
 }
 
-// CountMediaTasks counts the number of media processing tasks needed for a content item
-func CountMediaTasks(content models.MRContent) int {
-	taskCount := 0
-
-	// Count image processing tasks
-	imgCount := 0
-	for _, img := range content.Images {
-		if strings.HasPrefix(img.Key, "original") && img.Value != "" {
-			imgCount++
-		}
+// countRemainingOutcomes counts the pending outcomes across all open tasks of a content item,
+// including tasks still waiting in the dispatch queue
+func countRemainingOutcomes(contentID string) (int, error) {
+	objContentID, err := primitive.ObjectIDFromHex(contentID)
+	if err != nil {
+		return 0, err
 	}
 
-	if imgCount > 0 {
-		taskCount += imgCount
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := config.GetCollection(processingTasksCollection).Find(ctx, bson.M{
+		"content_id": objContentID,
+		"status":     bson.M{"$in": []string{"queued", "dispatched"}},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error loading processing tasks: %w", err)
 	}
+	defer cursor.Close(ctx)
 
-	// Count video processing tasks
-	videoCount := 0
-	alphaVideoCount := 0
+	var tasks []models.ProcessingTask
+	if err := cursor.All(ctx, &tasks); err != nil {
+		return 0, fmt.Errorf("error decoding processing tasks: %w", err)
+	}
 
-	for _, video := range content.Videos {
-		if strings.HasPrefix(video.Key, "original") && video.Value != "" {
-			videoCount++
-		}
-		if video.Key == "mask" || video.Key == "original_alpha" {
-			alphaVideoCount++
-		}
+	remaining := 0
+	for _, task := range tasks {
+		remaining += countPendingOutcomes(task)
 	}
 
-	// Video processing complexity varies based on what's needed
-	if videoCount > 0 {
-		// Based on MediaProcessor, we might need multiple processing steps:
-		// 1. For each original video: potentially stitching (if alpha exists)
-		// 2. For each original video: HLS/DASH generation
-		// 3. For each original video: compression
+	return remaining, nil
+}
 
-		if alphaVideoCount > 0 {
-			// If we have both original and alpha videos, they'll be stitched
-			taskCount += 1 // Stitching task
+// countPendingOutcomes counts the outcomes of a task that have not arrived yet
+func countPendingOutcomes(task models.ProcessingTask) int {
+	pending := 0
+	for _, outcome := range task.Outcomes {
+		if outcome.Status == "pending" {
+			pending++
 		}
-
-		// Always count compression and HLS/DASH tasks separately
-		// These run as separate asynchronous processes in the MediaProcessor
-		taskCount += videoCount * 2 // Compression + HLS/DASH for each video
 	}
+	return pending
+}
 
-	// Count 3D object processing tasks if any
-	// Currently no processing for 3D objects in the code
+// CountMediaTasks counts the number of media processing results expected for a content item,
+// using the same pipeline definition that drives dispatch
+func CountMediaTasks(content models.MRContent) int {
+	taskCount := countPlannedTasks(planDispatches(content, ResolvePipeline(content)))
 
 	log.Printf("Counted %d total processing tasks for content ID: %s", taskCount, content.ID.Hex())
 	return taskCount
 }
+
+// countPlannedTasks sums the results expected back for a dispatch plan
+func countPlannedTasks(plan []plannedDispatch) int {
+	taskCount := 0
+	for _, dispatch := range plan {
+		taskCount += len(dispatch.ExpectedResults)
+	}
+	return taskCount
+}
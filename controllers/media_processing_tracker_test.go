@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"testing"
+)
+
+func TestCountMediaTasks(t *testing.T) {
+	useConfiguredPipelines(t, nil)
+	t.Setenv("MODEL_TARGET_FORMATS", "glb,usdz")
+
+	tests := []struct {
+		name    string
+		content models.MRContent
+		want    int
+	}{
+		{name: "no media", content: models.MRContent{}, want: 0},
+		{
+			name:    "image",
+			content: models.MRContent{Images: []models.Media{{Key: "original", Value: "https://cdn.example.com/a.jpg"}}},
+			want:    3, // compressed, thumbnail, poster
+		},
+		{
+			name: "renditions are not sources",
+			content: models.MRContent{Images: []models.Media{
+				{Key: "original", Value: "https://cdn.example.com/a.jpg"},
+				{Key: "compressed", Value: "https://cdn.example.com/a-small.jpg"},
+				{Key: "original_back", Value: "https://cdn.example.com/b.jpg"},
+			}},
+			want: 4, // compressed twice, one thumbnail and poster
+		},
+		{
+			name:    "video without mask",
+			content: models.MRContent{Videos: []models.Media{{Key: "original", Value: "https://cdn.example.com/a.mp4"}}},
+			want:    4, // compressed, hls, thumbnail, poster
+		},
+		{
+			name: "video with mask",
+			content: models.MRContent{Videos: []models.Media{
+				{Key: "original", Value: "https://cdn.example.com/a.mp4"},
+				{Key: "mask", Value: "https://cdn.example.com/mask.mp4"},
+			}},
+			want: 5, // compressed, hls, stitched, thumbnail, poster
+		},
+		{
+			name:    "3D model",
+			content: models.MRContent{Objects_3D: []models.Media{{Key: "original", Value: "https://cdn.example.com/a.fbx"}}},
+			want:    4, // glb, usdz, thumbnail, poster
+		},
+		{
+			name:    "empty original",
+			content: models.MRContent{Videos: []models.Media{{Key: "original", Value: ""}}},
+			want:    0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CountMediaTasks(tt.content); got != tt.want {
+				t.Errorf("CountMediaTasks() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCountPendingOutcomes(t *testing.T) {
+	task := models.ProcessingTask{Outcomes: []models.ExpectedOutcome{
+		{ResultType: "compressed", Status: "succeeded"},
+		{ResultType: "hls", Status: "pending"},
+		{ResultType: "stitched", Status: "pending"},
+		{ResultType: "thumbnail", Status: "failed"},
+		{ResultType: "poster", Status: "superseded"},
+	}}
+
+	if got := countPendingOutcomes(task); got != 2 {
+		t.Errorf("countPendingOutcomes() = %d, want 2", got)
+	}
+	if got := countPendingOutcomes(models.ProcessingTask{}); got != 0 {
+		t.Errorf("countPendingOutcomes() of a task without outcomes = %d, want 0", got)
+	}
+}
@@ -3,95 +3,104 @@ package controllers
 import (
 	"MRContent/models"
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
-	"os"
+	"strconv"
 	"strings"
-	"sync"
+	"time"
 
-	"github.com/nats-io/nats.go"
 	"github.com/praleedsuvarna/shared-libs/config"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-var (
-	// Singleton NATS connection
-	natsConn *nats.Conn
-	natsOnce sync.Once
-	natsErr  error
+// Message headers carrying the correlation fields and priority class of requests and results
+const (
+	HeaderTaskID         = "X-Task-ID"
+	HeaderCorrelationID  = "X-Correlation-ID"
+	HeaderRequestVersion = "X-Request-Version"
+	HeaderPriority       = "X-Priority"
 )
 
 // TranscodeRequest matches the structure expected by the media processing service
 type TranscodeRequest struct {
-	VideoURL       string `json:"video_url,omitempty"`
-	ImageURL       string `json:"image_url,omitempty"`
-	AlphaVideoURL  string `json:"alphavideo_url,omitempty"`
-	ContentID      string `json:"content_id,omitempty"`
-	CallbackURL    string `json:"callback_url,omitempty"`
-	CallbackTopic  string `json:"callback_topic,omitempty"`
-	OrganizationID string `json:"organization_id,omitempty"`
+	VideoURL       string   `json:"video_url,omitempty"`
+	ImageURL       string   `json:"image_url,omitempty"`
+	AlphaVideoURL  string   `json:"alphavideo_url,omitempty"`
+	ContentID      string   `json:"content_id,omitempty"`
+	CallbackURL    string   `json:"callback_url,omitempty"`
+	CallbackTopic  string   `json:"callback_topic,omitempty"`
+	OrganizationID string   `json:"organization_id,omitempty"`
+	TaskID         string   `json:"task_id,omitempty"`         // Processing task this request belongs to
+	CorrelationID  string   `json:"correlation_id,omitempty"`  // Shared by all requests of one processing run
+	RequestVersion int      `json:"request_version,omitempty"` // Content processing version the request was made for
+	ModelURL       string   `json:"model_url,omitempty"`
+	TargetFormats  []string `json:"target_formats,omitempty"`
+	DracoCompress  bool     `json:"draco_compression,omitempty"`
+	ThumbnailAt    float64  `json:"thumbnail_at,omitempty"`    // Seconds into a video to take the thumbnail and poster frame from
+	ThumbnailSizes []string `json:"thumbnail_sizes,omitempty"` // Thumbnail sizes as "WIDTHxHEIGHT"
+	PosterSize     string   `json:"poster_size,omitempty"`     // Poster frame size as "WIDTHxHEIGHT"
 }
 
-// InitNATS initializes the NATS connection
-func InitNATS() (*nats.Conn, error) {
-	natsOnce.Do(func() {
-		// Get NATS URL from environment
-		natsURL := os.Getenv("NATS_URL")
-		if natsURL == "" {
-			// Fallback to default if not set
-			natsURL = "nats://localhost:4222"
-			log.Printf("NATS_URL not found in environment, using default: %s", natsURL)
+// GetModelTargetFormats returns the output formats requested for 3D objects,
+// configurable through MODEL_TARGET_FORMATS (comma separated)
+func GetModelTargetFormats() []string {
+	var formats []string
+	for _, format := range strings.Split(config.GetEnv("MODEL_TARGET_FORMATS", "glb,usdz"), ",") {
+		if format = strings.TrimSpace(format); format != "" {
+			formats = append(formats, format)
 		}
+	}
+	return formats
+}
 
-		// Connect to NATS
-		nc, err := nats.Connect(natsURL)
-		if err != nil {
-			natsErr = fmt.Errorf("failed to connect to NATS: %w", err)
-			log.Printf("NATS connection error: %v", natsErr)
-			return
-		}
+// GetThumbnailSettings returns the frame timestamp (THUMBNAIL_AT_SECONDS), thumbnail sizes
+// (THUMBNAIL_SIZES, comma separated) and poster size (POSTER_SIZE) used for previews
+func GetThumbnailSettings() (float64, []string, string) {
+	at, err := strconv.ParseFloat(config.GetEnv("THUMBNAIL_AT_SECONDS", "1"), 64)
+	if err != nil || at < 0 {
+		at = 1
+	}
 
-		log.Printf("Successfully connected to NATS server at %s", natsURL)
-		natsConn = nc
-	})
+	var sizes []string
+	for _, size := range strings.Split(config.GetEnv("THUMBNAIL_SIZES", "320x180,640x360"), ",") {
+		if size = strings.TrimSpace(size); size != "" {
+			sizes = append(sizes, size)
+		}
+	}
 
-	return natsConn, natsErr
+	return at, sizes, config.GetEnv("POSTER_SIZE", "1280x720")
 }
 
-// GetNATS returns the singleton NATS connection, initializing it if needed
-func GetNATS() (*nats.Conn, error) {
-	if natsConn != nil {
-		return natsConn, nil
+// ProcessMediaForContent handles media processing for a newly created MR content.
+// What gets published is driven by the pipeline resolved for the content's render_type.
+func ProcessMediaForContent(content models.MRContent) {
+	if _, err := DispatchProcessing(content, ""); err != nil {
+		log.Printf("Error processing media for content ID %s: %v", content.ID.Hex(), err)
 	}
-	return InitNATS()
 }
 
-// CloseNATS closes the NATS connection
-func CloseNATS() {
-	if natsConn != nil {
-		natsConn.Close()
-		natsConn = nil
+// DispatchProcessing records the processing tasks planned for a content item and hands them
+// to the dispatch queue. An empty priority uses the organization's priority class. It returns
+// the number of tasks recorded, which is zero when the pipeline has nothing to process.
+func DispatchProcessing(content models.MRContent, priority string) (int, error) {
+	// Nothing can be processed without the message bus
+	if _, err := GetMessageBus(); err != nil {
+		return 0, fmt.Errorf("message bus unavailable: %w", err)
 	}
-}
 
-// ProcessMediaForContent handles media processing for a newly created MR content
-func ProcessMediaForContent(content models.MRContent) {
-	// Get NATS connection
-	nc, err := GetNATS()
-	if err != nil {
-		log.Printf("Error getting NATS connection, cannot process media: %v", err)
-		return
-	}
+	// Expand the pipeline into concrete requests
+	pipeline := ResolvePipeline(content)
+	plan := planDispatches(content, pipeline)
 
 	// Count how many processing tasks will be needed
-	taskCount := CountMediaTasks(content)
+	taskCount := countPlannedTasks(plan)
 
 	if taskCount <= 0 {
 		log.Printf("No media processing tasks identified for content ID: %s", content.ID.Hex())
-		return
+		return 0, nil
 	}
 
 	// Start tracking and update status to "processing"
@@ -101,247 +110,66 @@ func ProcessMediaForContent(content models.MRContent) {
 		// Continue with processing anyway
 	}
 
-	// Process media assets asynchronously
-	go func() {
-		// contentIDStr := content.ID.Hex()
-		orgIDStr := content.OrganizationID.Hex()
-		log.Printf("Starting media processing for content ID: %s, organization ID: %s", contentIDStr, orgIDStr)
-
-		// Process images if any
-		for _, img := range content.Images {
-			if strings.HasPrefix(img.Key, "original") && img.Value != "" {
-				log.Printf("Processing original image: %s", img.Value)
-
-				// Create a request with ContentID
-				request := TranscodeRequest{
-					ImageURL:       img.Value,
-					ContentID:      contentIDStr,
-					OrganizationID: orgIDStr,
-				}
-
-				// Process the image
-				processImage(nc, request)
-			}
-		}
-
-		// Process videos if any
-		// Look for both original and mask videos
-		var originalVideoURL string
-		var maskVideoURL string
-
-		// First, find the original video URL
-		for _, video := range content.Videos {
-			if strings.HasPrefix(video.Key, "original") && video.Value != "" {
-				originalVideoURL = video.Value
-				break
-			}
-		}
-
-		// Then, find the mask video URL (if exists)
-		for _, video := range content.Videos {
-			if strings.HasPrefix(video.Key, "mask") && video.Value != "" {
-				maskVideoURL = video.Value
-				break
-			}
-		}
-
-		// If we have an original video, proceed with processing
-		if originalVideoURL != "" {
-			log.Printf("Processing original video: %s", originalVideoURL)
-
-			// Create base request with ContentID
-			baseRequest := TranscodeRequest{
-				VideoURL:       originalVideoURL,
-				ContentID:      contentIDStr,
-				OrganizationID: orgIDStr,
-			}
-
-			// If we have a mask video, add it to the request
-			if maskVideoURL != "" {
-				log.Printf("Found mask video: %s", maskVideoURL)
-				baseRequest.AlphaVideoURL = maskVideoURL
-			}
-
-			// Publish to createexperience topic for combined processing
-			if err := publishToNATS(nc, baseRequest, "createexperience"); err != nil {
-				log.Printf("Error publishing to createexperience: %v", err)
-
-				// Mark all video tasks as complete since they failed
-				// Each video normally accounts for multiple tasks (potentially stitching + compression + HLS/DASH)
-				// We need to complete them all on error
-				for i := 0; i < 3; i++ {
-					if err := TrackProcessingComplete(contentIDStr); err != nil {
-						log.Printf("Error marking failed task as complete: %v", err)
-					}
-				}
-			} else {
-				log.Printf("Published to createexperience topic for content ID: %s", contentIDStr)
-			}
-		}
-		// Process videos if any
-		// for _, video := range content.Videos {
-		// 	if strings.HasPrefix(video.Key, "original") && video.Value != "" {
-		// 		log.Printf("Processing original video: %s", video.Value)
-
-		// 		// Create base request with ContentID
-		// 		baseRequest := TranscodeRequest{
-		// 			VideoURL:       video.Value,
-		// 			ContentID:      contentIDStr,
-		// 			OrganizationID: orgIDStr,
-		// 		}
-
-		// 		// Process for HLS/DASH streaming
-		// 		processVideoHLSDASH(nc, baseRequest)
-
-		// 		// Process for compression
-		// 		processVideoCompression(nc, baseRequest)
-
-		// 		// Check if we need alpha video processing
-		// 		if content.HasAlpha {
-		// 			// Find the alpha video URL
-		// 			var alphaVideoURL string
-		// 			for _, alphaVideo := range content.Videos {
-		// 				if strings.HasPrefix(alphaVideo.Key, "original_alpha") && alphaVideo.Value != "" {
-		// 					alphaVideoURL = alphaVideo.Value
-		// 					break
-		// 				}
-		// 			}
-
-		// 			// Generate alpha if not already available
-		// 			if alphaVideoURL == "" {
-		// 				log.Printf("Generating alpha channel for video: %s", video.Value)
-		// 				generateAlphaVideo(nc, baseRequest)
-		// 			} else {
-		// 				// If both normal and alpha videos are available, stitch them
-		// 				log.Printf("Stitching video with alpha: %s + %s", video.Value, alphaVideoURL)
-
-		// 				// Create request with both videos and ContentID
-		// 				stitchRequest := TranscodeRequest{
-		// 					VideoURL:       video.Value,
-		// 					AlphaVideoURL:  alphaVideoURL,
-		// 					ContentID:      contentIDStr,
-		// 					OrganizationID: orgIDStr,
-		// 				}
-
-		// 				stitchVideos(nc, stitchRequest)
-		// 			}
-		// 		}
-		// 	}
-		// }
-
-		// Process 3D objects if any
-		for _, obj := range content.Objects_3D {
-			if strings.HasPrefix(obj.Key, "original") && obj.Value != "" {
-				log.Printf("Processing original 3D object: %s", obj.Value)
-				// Add specific processing for 3D objects here if needed
-				log.Printf("Found 3D object to process: %s", obj.Value)
-			}
-		}
-
-		log.Printf("Completed queueing media processing tasks for content ID: %s", contentIDStr)
-	}()
-}
-
-// processImage sends a request to compress an image
-func processImage(nc *nats.Conn, request TranscodeRequest) {
-	// Ensure we're using the request object directly
-	if request.ImageURL == "" {
-		log.Printf("Error: Missing image URL in request")
-		return
-	}
-
-	// Publish to NATS subject
-	if err := publishToNATS(nc, request, "compressimage"); err != nil {
-		log.Printf("Error publishing image compression request: %v", err)
-		return
+	// Every run gets a new version so results of earlier runs can be told apart
+	requestVersion, err := nextProcessingVersion(content.ID)
+	if err != nil {
+		log.Printf("Error incrementing processing version: %v", err)
 	}
+	correlationID := primitive.NewObjectID().Hex()
 
-	log.Printf("Image compression request published for content ID: %s, image: %s",
-		request.ContentID, request.ImageURL)
-}
-
-// processVideoHLSDASH sends a request to transcode a video for HLS/DASH streaming
-func processVideoHLSDASH(nc *nats.Conn, request TranscodeRequest) {
-	// Ensure we're using the request object directly
-	if request.VideoURL == "" {
-		log.Printf("Error: Missing video URL in request")
-		return
+	// Unless the caller chose one, the organization's priority class applies to every task of this run
+	if priority == "" {
+		priority = organizationQuota(content.OrganizationID).Priority
 	}
 
-	// Publish to NATS subject
-	if err := publishToNATS(nc, request, "transcodehlsdash"); err != nil {
-		log.Printf("Error publishing HLS/DASH transcode request: %v", err)
-		return
-	}
+	log.Printf("Starting media processing for content ID: %s, organization ID: %s, render type: %s (%d requests, %s priority)",
+		contentIDStr, content.OrganizationID.Hex(), pipeline.RenderType, len(plan), priority)
 
-	log.Printf("HLS/DASH transcode request published for content ID: %s, video: %s",
-		request.ContentID, request.VideoURL)
-}
+	recorded := 0
+	for _, dispatch := range plan {
+		dispatch.Request.CorrelationID = correlationID
+		dispatch.Request.RequestVersion = requestVersion
 
-// processVideoCompression sends a request to compress a video
-func processVideoCompression(nc *nats.Conn, request TranscodeRequest) {
-	// Ensure we're using the request object directly
-	if request.VideoURL == "" {
-		log.Printf("Error: Missing video URL in request")
-		return
-	}
+		// Results still outstanding for the same source are no longer wanted
+		if err := SupersedeTasks(content.ID, content.OrganizationID, dispatch.MediaType, dispatch.SourceKey, requestVersion); err != nil {
+			log.Printf("Error superseding earlier tasks: %v", err)
+		}
 
-	// Publish to NATS subject
-	if err := publishToNATS(nc, request, "compressvideo"); err != nil {
-		log.Printf("Error publishing video compression request: %v", err)
-		return
+		// Record what this message should produce before it can be answered
+		if _, err := RecordDispatch(content, &dispatch, priority); err != nil {
+			log.Printf("Error recording dispatch to %s, skipping: %v", dispatch.Subject, err)
+			continue
+		}
+		recorded++
 	}
 
-	log.Printf("Video compression request published for content ID: %s, video: %s",
-		request.ContentID, request.VideoURL)
-}
-
-// generateAlphaVideo sends a request to generate an alpha channel video
-func generateAlphaVideo(nc *nats.Conn, request TranscodeRequest) {
-	// Ensure we're using the request object directly
-	if request.VideoURL == "" {
-		log.Printf("Error: Missing video URL in request")
-		return
+	if recorded == 0 {
+		return 0, fmt.Errorf("none of the %d processing requests could be recorded", len(plan))
 	}
 
-	// Publish to NATS subject
-	if err := publishToNATS(nc, request, "generatealpha"); err != nil {
-		log.Printf("Error publishing generate alpha request: %v", err)
-		return
-	}
+	// Publish as many tasks as the organization's quota allows; the rest wait in the queue
+	ReleaseQueuedTasks(content.OrganizationID)
 
-	log.Printf("Generate alpha request published for content ID: %s, video: %s",
-		request.ContentID, request.VideoURL)
+	log.Printf("Completed queueing media processing tasks for content ID: %s", contentIDStr)
+	return recorded, nil
 }
 
-// stitchVideos sends a request to stitch normal and alpha videos together
-func stitchVideos(nc *nats.Conn, request TranscodeRequest) {
-	// Ensure we're using the request object directly
-	if request.VideoURL == "" || request.AlphaVideoURL == "" {
-		log.Printf("Error: Missing video or alpha video URL in request")
-		return
-	}
-
-	// Publish to NATS subject
-	if err := publishToNATS(nc, request, "stitchvideos"); err != nil {
-		log.Printf("Error publishing stitch videos request: %v", err)
-		return
-	}
+// nextProcessingVersion atomically increments and returns the processing version of a content item
+func nextProcessingVersion(contentID primitive.ObjectID) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	log.Printf("Stitch videos request published for content ID: %s, videos: %s + %s",
-		request.ContentID, request.VideoURL, request.AlphaVideoURL)
-}
-
-// publishToNATS is a helper function to publish requests to NATS
-func publishToNATS(nc *nats.Conn, data interface{}, subject string) error {
-	// Create JSON payload
-	jsonData, err := json.Marshal(data)
+	var content models.MRContent
+	err := GetMediaCollection().FindOneAndUpdate(ctx,
+		bson.M{"_id": contentID},
+		bson.M{"$inc": bson.M{"processing_version": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&content)
 	if err != nil {
-		return fmt.Errorf("error marshaling request: %w", err)
+		return 0, err
 	}
 
-	// Publish to NATS subject
-	return nc.Publish(subject, jsonData)
+	return content.ProcessingVersion, nil
 }
 
 // Get media collection
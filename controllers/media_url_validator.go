@@ -0,0 +1,407 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/praleedsuvarna/shared-libs/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const mediaSourcePoliciesCollection = "oms_media_source_policies"
+
+// MediaURLError is a field-level validation error for a media URL
+type MediaURLError struct {
+	Field   string `json:"field"` // e.g. "videos.original"
+	URL     string `json:"url"`
+	Message string `json:"message"`
+}
+
+// errBlockedAddress is returned when a media host resolves to a non-public address
+var errBlockedAddress = errors.New("host resolves to a private or reserved address")
+
+// Content types accepted per media kind. Generic binary types are always accepted because
+// many object stores serve uploads without a specific type.
+var mediaContentTypes = map[string][]string{
+	"image":     {"image/"},
+	"video":     {"video/"},
+	"object_3d": {"model/", "application/vnd.usdz", "application/zip", "application/x-zip", "application/gltf"},
+}
+
+var genericContentTypes = []string{"", "application/octet-stream", "binary/octet-stream"}
+
+// mediaSource is a media URL of a content item that can be handed to the media processor
+type mediaSource struct {
+	Field     string // e.g. "videos"
+	MediaType string // e.g. "video"
+	Media     models.Media
+}
+
+// ValidateContentMedia checks every media URL of a content item that can reach the media
+// processor. include decides which of them are checked (nil checks all); only new or
+// changed URLs need probing.
+func ValidateContentMedia(orgID primitive.ObjectID, content models.MRContent, include func(field string, media models.Media) bool) []MediaURLError {
+	content.OrganizationID = orgID
+
+	var sources []mediaSource
+	for _, source := range processorMedia(content, ResolvePipeline(content)) {
+		if include == nil || include(source.Field, source.Media) {
+			sources = append(sources, source)
+		}
+	}
+	if len(sources) == 0 {
+		return nil
+	}
+
+	return validateMediaSources(sources, mediaAllowedHosts(orgID))
+}
+
+// processorMedia lists the media of a content item the media processor can be asked to
+// fetch: every original, plus whatever else the pipeline dispatches, such as attached masks
+func processorMedia(content models.MRContent, pipeline models.ProcessingPipeline) []mediaSource {
+	seen := make(map[string]bool)
+	var sources []mediaSource
+	add := func(mediaType string, media models.Media) {
+		field := mediaFields[mediaType]
+		if seen[field+"."+media.Key] {
+			return
+		}
+		seen[field+"."+media.Key] = true
+		sources = append(sources, mediaSource{Field: field, MediaType: mediaType, Media: media})
+	}
+
+	for _, mediaType := range []string{"image", "video", "object_3d"} {
+		for _, media := range mediaForType(content, mediaType) {
+			if strings.HasPrefix(media.Key, "original") {
+				add(mediaType, media)
+			}
+		}
+	}
+
+	for _, dispatch := range planDispatches(content, pipeline) {
+		add(dispatch.MediaType, models.Media{Key: dispatch.SourceKey, Value: dispatch.SourceURL})
+		if dispatch.MaskKey != "" {
+			add("video", models.Media{Key: dispatch.MaskKey, Value: dispatch.Request.AlphaVideoURL})
+		}
+	}
+
+	return sources
+}
+
+// validateMediaSources checks each source and returns the field errors of those that fail
+func validateMediaSources(sources []mediaSource, allowedHosts []string) []MediaURLError {
+	var fieldErrors []MediaURLError
+	for _, source := range sources {
+		if err := validateMediaURL(source.Media.Value, source.MediaType, allowedHosts); err != nil {
+			fieldErrors = append(fieldErrors, MediaURLError{
+				Field:   source.Field + "." + source.Media.Key,
+				URL:     source.Media.Value,
+				Message: err.Error(),
+			})
+		}
+	}
+	return fieldErrors
+}
+
+// validateMediaURL runs the static checks and, unless disabled, probes the URL
+func validateMediaURL(rawURL, mediaType string, allowedHosts []string) error {
+	parsed, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || parsed.Host == "" {
+		return fmt.Errorf("must be an absolute URL")
+	}
+
+	switch parsed.Scheme {
+	case "https":
+	case "http":
+		if config.GetEnv("APP_ENV", "development") != "development" {
+			return fmt.Errorf("must use https")
+		}
+	default:
+		return fmt.Errorf("unsupported scheme %q", parsed.Scheme)
+	}
+
+	if parsed.User != nil {
+		return fmt.Errorf("must not contain credentials")
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+	if len(allowedHosts) > 0 && !hostAllowed(host, allowedHosts) {
+		return fmt.Errorf("host %s is not in the organization's allowed media hosts", host)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mediaProbeTimeout())
+	defer cancel()
+
+	if err := checkHostAddresses(ctx, host); err != nil {
+		return err
+	}
+
+	if config.GetEnv("MEDIA_URL_PROBE", "true") != "true" {
+		return nil
+	}
+
+	return probeMediaURL(ctx, parsed.String(), mediaType)
+}
+
+// mediaAllowedHosts returns the organization's host allowlist, falling back to MEDIA_ALLOWED_HOSTS.
+// An empty list allows any public host.
+func mediaAllowedHosts(orgID primitive.ObjectID) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var policy models.MediaSourcePolicy
+	err := config.GetCollection(mediaSourcePoliciesCollection).FindOne(ctx, bson.M{"organization_id": orgID}).Decode(&policy)
+	if err == nil && len(policy.AllowedHosts) > 0 {
+		return policy.AllowedHosts
+	}
+
+	var hosts []string
+	for _, host := range strings.Split(config.GetEnv("MEDIA_ALLOWED_HOSTS", ""), ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// hostAllowed matches a host against exact entries and "*.domain" wildcards
+func hostAllowed(host string, allowedHosts []string) bool {
+	for _, allowed := range allowedHosts {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if strings.HasPrefix(allowed, "*.") {
+			if strings.HasSuffix(host, allowed[1:]) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHostAddresses resolves a host and rejects it if any address is not public
+func checkHostAddresses(ctx context.Context, host string) error {
+	if allowPrivateMediaHosts() {
+		return nil
+	}
+	return lookupPublicHost(ctx, host)
+}
+
+// lookupPublicHost resolves a host and rejects it if any address is not public
+func lookupPublicHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("host %s could not be resolved", host)
+	}
+
+	for _, addr := range addrs {
+		if isBlockedIP(addr.IP) {
+			return errBlockedAddress
+		}
+	}
+	return nil
+}
+
+// Special-purpose ranges the net.IP predicates don't cover
+var blockedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",       // "This network"
+	"100.64.0.0/10",   // Carrier-grade NAT
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // Documentation (TEST-NET-1)
+	"198.18.0.0/15",   // Benchmarking
+	"198.51.100.0/24", // Documentation (TEST-NET-2)
+	"203.0.113.0/24",  // Documentation (TEST-NET-3)
+	"240.0.0.0/4",     // Reserved, including broadcast
+	"64:ff9b::/96",    // NAT64, can reach any IPv4 address through the translator
+	"64:ff9b:1::/48",  // Local-use NAT64
+	"100::/64",        // Discard-only
+	"2001:db8::/32",   // Documentation
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// isBlockedIP reports whether an address must never be fetched by the processor.
+// IPv4-mapped IPv6 addresses are checked as the IPv4 address they map to.
+func isBlockedIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowPrivateMediaHosts lets development setups point at local object stores
+func allowPrivateMediaHosts() bool {
+	return config.GetEnv("MEDIA_ALLOW_PRIVATE_HOSTS", "false") == "true"
+}
+
+// publicDialContext returns a dial function that refuses to connect to non-public addresses
+// unless allowPrivate says otherwise. The check runs on the address actually dialed, so DNS
+// changes after validation, or redirects, can't reach internal hosts.
+func publicDialContext(allowPrivate func() bool) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return (&net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if allowPrivate() {
+				return nil
+			}
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isBlockedIP(ip) {
+				return errBlockedAddress
+			}
+			return nil
+		},
+	}).DialContext
+}
+
+// mediaProbeClient only connects to public addresses
+var mediaProbeClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 nil,
+		DialContext:           publicDialContext(allowPrivateMediaHosts),
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return fmt.Errorf("too many redirects")
+		}
+		return nil
+	},
+}
+
+// probeMediaURL checks that the URL is reachable, serves the expected kind of media
+// and is within the size limit. Servers that reject HEAD get a one-byte range request.
+func probeMediaURL(ctx context.Context, rawURL, mediaType string) error {
+	resp, err := sendProbe(ctx, http.MethodHead, rawURL)
+	if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed ||
+		resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusNotImplemented) {
+		resp, err = sendProbe(ctx, http.MethodGet, rawURL)
+	}
+	if err != nil {
+		if errors.Is(err, errBlockedAddress) {
+			return errBlockedAddress
+		}
+		log.Printf("Media URL probe failed for %s: %v", rawURL, err)
+		return fmt.Errorf("URL could not be reached")
+	}
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("URL responded with HTTP %d", resp.StatusCode)
+	}
+
+	contentType := strings.ToLower(strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0]))
+	if !contentTypeMatches(contentType, mediaType) {
+		return fmt.Errorf("content type %q is not valid for %s media", contentType, mediaType)
+	}
+
+	if size := probedSize(resp); size > 0 {
+		if limit := maxMediaSize(mediaType); size > limit {
+			return fmt.Errorf("file is %d MB, the limit for %s media is %d MB", size>>20, mediaType, limit>>20)
+		}
+	}
+
+	return nil
+}
+
+// sendProbe issues a HEAD or single-byte range GET and discards the body
+func sendProbe(ctx context.Context, method, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "MRContent-MediaProbe/1.0")
+	if method == http.MethodGet {
+		req.Header.Set("Range", "bytes=0-0")
+	}
+
+	resp, err := mediaProbeClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+// probedSize returns the full size of the resource, or 0 when unknown
+func probedSize(resp *http.Response) int64 {
+	// "bytes 0-0/12345" on a range response
+	if contentRange := resp.Header.Get("Content-Range"); contentRange != "" {
+		if i := strings.LastIndex(contentRange, "/"); i >= 0 {
+			if size, err := strconv.ParseInt(contentRange[i+1:], 10, 64); err == nil {
+				return size
+			}
+		}
+		return 0
+	}
+	if resp.Request != nil && resp.Request.Method == http.MethodHead {
+		return resp.ContentLength
+	}
+	return 0
+}
+
+func contentTypeMatches(contentType, mediaType string) bool {
+	for _, generic := range genericContentTypes {
+		if contentType == generic {
+			return true
+		}
+	}
+	for _, prefix := range mediaContentTypes[mediaType] {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxMediaSize returns the size limit in bytes for a media kind
+func maxMediaSize(mediaType string) int64 {
+	var mb int
+	switch mediaType {
+	case "image":
+		mb = getEnvInt("MEDIA_MAX_IMAGE_MB", 50)
+	case "video":
+		mb = getEnvInt("MEDIA_MAX_VIDEO_MB", 2048)
+	default:
+		mb = getEnvInt("MEDIA_MAX_MODEL_MB", 512)
+	}
+	return int64(mb) << 20
+}
+
+func mediaProbeTimeout() time.Duration {
+	return time.Duration(getEnvInt("MEDIA_PROBE_TIMEOUT_SECONDS", 10)) * time.Second
+}
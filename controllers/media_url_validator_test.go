@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"net"
+	"testing"
+)
+
+func TestIsBlockedIP(t *testing.T) {
+	tests := []struct {
+		ip      string
+		blocked bool
+	}{
+		// Public addresses
+		{"93.184.216.34", false},
+		{"8.8.8.8", false},
+		{"100.63.255.255", false},
+		{"100.128.0.0", false},
+		{"198.17.255.255", false},
+		{"198.20.0.0", false},
+		{"2606:2800:220:1:248:1893:25c8:1946", false},
+		{"::ffff:93.184.216.34", false},
+
+		// Loopback, private and link-local
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"::1", true},
+		{"fd00::1", true},
+		{"fe80::1", true},
+
+		// "This network", including the unspecified address
+		{"0.0.0.0", true},
+		{"0.1.2.3", true},
+		{"::", true},
+
+		// Carrier-grade NAT
+		{"100.64.0.1", true},
+		{"100.127.255.254", true},
+
+		// IETF protocol assignments, benchmarking, documentation and reserved
+		{"192.0.0.8", true},
+		{"198.18.0.1", true},
+		{"198.19.255.255", true},
+		{"192.0.2.1", true},
+		{"198.51.100.1", true},
+		{"203.0.113.1", true},
+		{"240.0.0.1", true},
+		{"255.255.255.255", true},
+		{"2001:db8::1", true},
+		{"100::1", true},
+
+		// Multicast
+		{"224.0.0.1", true},
+		{"ff02::1", true},
+
+		// NAT64 prefixes
+		{"64:ff9b::a9fe:a9fe", true},
+		{"64:ff9b::808:808", true},
+		{"64:ff9b:1::1", true},
+
+		// IPv4-mapped forms of blocked ranges
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:10.0.0.1", true},
+		{"::ffff:169.254.169.254", true},
+		{"::ffff:100.64.0.1", true},
+		{"::ffff:198.18.0.1", true},
+		{"::ffff:192.0.0.1", true},
+		{"::ffff:0.1.2.3", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			ip := net.ParseIP(tt.ip)
+			if ip == nil {
+				t.Fatalf("invalid test address %q", tt.ip)
+			}
+			if got := isBlockedIP(ip); got != tt.blocked {
+				t.Errorf("isBlockedIP(%s) = %v, want %v", tt.ip, got, tt.blocked)
+			}
+		})
+	}
+}
+
+func TestProcessorMedia(t *testing.T) {
+	content := models.MRContent{
+		RenderType: "video",
+		HasAlpha:   true,
+		Images:     []models.Media{{Key: "original", Value: "https://cdn.example.com/a.jpg"}, {Key: "compressed", Value: "https://cdn.example.com/b.jpg"}},
+		Videos: []models.Media{
+			{Key: "original", Value: "https://cdn.example.com/a.mp4"},
+			{Key: "mask", Value: "https://cdn.example.com/mask.mp4"},
+			{Key: "hls", Value: "https://cdn.example.com/master.m3u8"},
+		},
+	}
+
+	maskless := DefaultPipeline()
+	for i := range maskless.Steps {
+		maskless.Steps[i].AttachMask = false
+	}
+
+	tests := []struct {
+		name     string
+		pipeline models.ProcessingPipeline
+		want     []string
+	}{
+		{"mask attached by the pipeline", DefaultPipeline(), []string{"images.original", "videos.original", "videos.mask"}},
+		{"mask not dispatched", maskless, []string{"images.original", "videos.original"}},
+		{"custom role", models.ProcessingPipeline{Steps: []models.PipelineStep{
+			{MediaType: "video", Role: "hls", Subject: "repackage", ExpectedResults: []string{"dash"}},
+		}}, []string{"images.original", "videos.original", "videos.hls"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, source := range processorMedia(content, tt.pipeline) {
+				got = append(got, source.Field+"."+source.Media.Key)
+			}
+			if !equalStrings(got, tt.want) {
+				t.Errorf("processorMedia() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateMediaSourcesRejectsPrivateMask(t *testing.T) {
+	t.Setenv("MEDIA_URL_PROBE", "false")
+	t.Setenv("MEDIA_ALLOW_PRIVATE_HOSTS", "false")
+
+	content := models.MRContent{
+		RenderType: "video",
+		HasAlpha:   true,
+		Videos: []models.Media{
+			{Key: "original", Value: "https://93.184.216.34/a.mp4"},
+			{Key: "mask", Value: "https://169.254.169.254/latest/meta-data/"},
+		},
+	}
+
+	fieldErrors := validateMediaSources(processorMedia(content, DefaultPipeline()), nil)
+	if len(fieldErrors) != 1 {
+		t.Fatalf("validateMediaSources() = %v, want one error for the mask", fieldErrors)
+	}
+	if fieldErrors[0].Field != "videos.mask" || fieldErrors[0].Message != errBlockedAddress.Error() {
+		t.Errorf("validateMediaSources() = %+v, want videos.mask blocked", fieldErrors[0])
+	}
+}
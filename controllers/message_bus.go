@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/praleedsuvarna/shared-libs/config"
+)
+
+// Message is a message carried by the message bus. Headers carry the correlation fields
+// and priority class of processing requests and results.
+type Message struct {
+	Subject string
+	Reply   string
+	Header  map[string]string
+	Data    []byte
+}
+
+// MessageHandler handles a message received on a subscription
+type MessageHandler func(msg *Message)
+
+// Subscription is a subscription to a subject on the message bus
+type Subscription interface {
+	Subject() string
+	IsValid() bool
+	// Drain stops receiving new messages and ends the subscription once the
+	// messages already received are handled
+	Drain() error
+	Unsubscribe() error
+}
+
+// MessageBus carries processing requests to the MediaProcessor and results and events
+// back. MESSAGE_BUS selects the implementation: "nats" (default), "memory" for tests and
+// single-binary development, or "http" for processors that only accept webhooks.
+type MessageBus interface {
+	Name() string
+	Publish(msg *Message) error
+	// Subscribe delivers matching messages to handler; subscriptions sharing a non-empty
+	// queue group receive each message once between them
+	Subscribe(subject, queue string, handler MessageHandler) (Subscription, error)
+	Request(msg *Message, timeout time.Duration) (*Message, error)
+	// Drain ends all subscriptions once their messages are handled, flushes pending
+	// publishes and closes the bus
+	Drain() error
+	// Ready reports an error when messages can neither be sent nor buffered
+	Ready() error
+	Connected() bool
+	Status() map[string]interface{}
+}
+
+var (
+	// Global message bus, nil until InitMessageBus is called
+	messageBus      MessageBus
+	messageBusMutex sync.Mutex
+)
+
+// InitMessageBus sets up the message bus selected by MESSAGE_BUS. For NATS the bus is kept
+// even when the connection cannot be made yet, so publishing retries it later.
+func InitMessageBus() (MessageBus, error) {
+	messageBusMutex.Lock()
+	defer messageBusMutex.Unlock()
+
+	if messageBus != nil {
+		return messageBus, nil
+	}
+
+	var err error
+	switch kind := config.GetEnv("MESSAGE_BUS", "nats"); kind {
+	case "nats":
+		messageBus = natsBus{}
+		_, err = InitNATS()
+	case "memory":
+		messageBus = newMemoryBus()
+		log.Println("Using the in-process message bus, processing requests stay inside this replica")
+	case "http":
+		var bus *httpPushBus
+		if bus, err = newHTTPPushBus(); err != nil {
+			return nil, err
+		}
+		messageBus = bus
+	default:
+		return nil, fmt.Errorf("unknown MESSAGE_BUS %q, use nats, memory or http", kind)
+	}
+
+	return messageBus, err
+}
+
+// GetMessageBus returns the global message bus once it can send or buffer messages
+func GetMessageBus() (MessageBus, error) {
+	messageBusMutex.Lock()
+	bus := messageBus
+	messageBusMutex.Unlock()
+
+	if bus == nil {
+		return nil, fmt.Errorf("message bus not initialized")
+	}
+	if err := bus.Ready(); err != nil {
+		return nil, err
+	}
+	return bus, nil
+}
+
+// CloseMessageBus drains and closes the global message bus
+func CloseMessageBus() {
+	messageBusMutex.Lock()
+	bus := messageBus
+	messageBus = nil
+	messageBusMutex.Unlock()
+
+	if bus != nil {
+		if err := bus.Drain(); err != nil {
+			log.Printf("Error draining %s message bus: %v", bus.Name(), err)
+		}
+	}
+}
+
+// MessageBusStatus describes the message bus for the health endpoint
+func MessageBusStatus() map[string]interface{} {
+	messageBusMutex.Lock()
+	bus := messageBus
+	messageBusMutex.Unlock()
+
+	if bus == nil {
+		return map[string]interface{}{"kind": config.GetEnv("MESSAGE_BUS", "nats"), "state": "not_initialized"}
+	}
+
+	status := bus.Status()
+	status["kind"] = bus.Name()
+	return status
+}
+
+// publishMessage marshals data as JSON and publishes it on the message bus
+func publishMessage(bus MessageBus, data interface{}, subject string, header map[string]string) error {
+	// Create JSON payload
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("error marshaling request: %w", err)
+	}
+
+	return bus.Publish(&Message{Subject: subject, Data: jsonData, Header: header})
+}
+
+// correlationHeaders carries the correlation fields of a request as message headers
+func correlationHeaders(taskID, correlationID string, requestVersion int) map[string]string {
+	return map[string]string{
+		HeaderTaskID:         taskID,
+		HeaderCorrelationID:  correlationID,
+		HeaderRequestVersion: strconv.Itoa(requestVersion),
+	}
+}
+
+// subjectMatches reports whether a subject matches a subscription pattern using the NATS
+// wildcards: "*" matches one token and a trailing ">" one or more
+func subjectMatches(pattern, subject string) bool {
+	patternTokens := strings.Split(pattern, ".")
+	subjectTokens := strings.Split(subject, ".")
+
+	for i, token := range patternTokens {
+		if token == ">" {
+			return len(subjectTokens) > i
+		}
+		if i >= len(subjectTokens) || (token != "*" && token != subjectTokens[i]) {
+			return false
+		}
+	}
+	return len(patternTokens) == len(subjectTokens)
+}
@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/praleedsuvarna/shared-libs/config"
+)
+
+// httpPushBus sends processing requests as HTTP POSTs to MESSAGE_BUS_HTTP_URL, for media
+// processors that only speak webhooks. Results come back through the HTTP callback endpoint.
+// Subjects with a local subscription, such as processing events, stay in this process.
+type httpPushBus struct {
+	*memoryBus
+	url    string
+	token  string
+	client *http.Client
+
+	mutex     sync.Mutex
+	lastError string
+}
+
+func newHTTPPushBus() (*httpPushBus, error) {
+	endpoint := config.GetEnv("MESSAGE_BUS_HTTP_URL", "")
+	if endpoint == "" {
+		return nil, fmt.Errorf("MESSAGE_BUS_HTTP_URL is required for the http message bus")
+	}
+	if _, err := url.ParseRequestURI(endpoint); err != nil {
+		return nil, fmt.Errorf("invalid MESSAGE_BUS_HTTP_URL: %w", err)
+	}
+
+	if CallbackURL() == "" {
+		log.Println("Warning: MEDIA_CALLBACK_URL or PUBLIC_BASE_URL is not set, processors cannot send results back")
+	}
+
+	return &httpPushBus{
+		memoryBus: newMemoryBus(),
+		url:       endpoint,
+		token:     config.GetEnv("MESSAGE_BUS_HTTP_TOKEN", ""),
+		client:    &http.Client{Timeout: time.Duration(getEnvInt("MESSAGE_BUS_HTTP_TIMEOUT_SECONDS", 10)) * time.Second},
+	}, nil
+}
+
+func (b *httpPushBus) Name() string { return "http" }
+
+// Publish pushes messages nobody in this process subscribed to to the processor
+func (b *httpPushBus) Publish(msg *Message) error {
+	if b.hasSubscriber(msg.Subject) {
+		return b.memoryBus.Publish(msg)
+	}
+
+	_, err := b.post(msg, b.client.Timeout)
+	return err
+}
+
+// Request posts the message and returns the response body as the reply
+func (b *httpPushBus) Request(msg *Message, timeout time.Duration) (*Message, error) {
+	body, err := b.post(msg, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{Subject: msg.Subject, Data: body}, nil
+}
+
+// post sends a message with its subject and headers as HTTP headers
+func (b *httpPushBus) post(msg *Message, timeout time.Duration) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPost, b.url, bytes.NewReader(msg.Data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Subject", msg.Subject)
+	for key, value := range msg.Header {
+		req.Header.Set(key, value)
+	}
+	if b.token != "" {
+		req.Header.Set("Authorization", "Bearer "+b.token)
+	}
+
+	client := b.client
+	if timeout != b.client.Timeout {
+		client = &http.Client{Timeout: timeout}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		b.recordError(err)
+		return nil, fmt.Errorf("error pushing %s: %w", msg.Subject, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		b.recordError(err)
+		return nil, fmt.Errorf("error reading response to %s: %w", msg.Subject, err)
+	}
+	if resp.StatusCode >= 300 {
+		err := fmt.Errorf("processor responded to %s with HTTP %d", msg.Subject, resp.StatusCode)
+		b.recordError(err)
+		return nil, err
+	}
+
+	return body, nil
+}
+
+func (b *httpPushBus) recordError(err error) {
+	b.mutex.Lock()
+	b.lastError = err.Error()
+	b.mutex.Unlock()
+}
+
+func (b *httpPushBus) Status() map[string]interface{} {
+	status := b.memoryBus.Status()
+
+	if endpoint, err := url.Parse(b.url); err == nil {
+		status["endpoint"] = endpoint.Host
+	}
+
+	b.mutex.Lock()
+	status["last_error"] = b.lastError
+	b.mutex.Unlock()
+
+	return status
+}
@@ -0,0 +1,244 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Messages a subscription of the in-process bus holds before new ones are dropped,
+// like a slow NATS consumer
+const memorySubscriptionBuffer = 1024
+
+// memoryBus is an in-process message bus for tests and single-binary development.
+// Subjects and queue groups behave like NATS, but messages never leave the process.
+type memoryBus struct {
+	mutex  sync.Mutex
+	subs   []*memorySubscription
+	next   map[string]int // Round-robin position per queue group
+	closed bool
+}
+
+func newMemoryBus() *memoryBus {
+	return &memoryBus{next: map[string]int{}}
+}
+
+func (b *memoryBus) Name() string { return "memory" }
+
+// Publish delivers a message to every matching subscription outside queue groups and to
+// one member of each matching queue group
+func (b *memoryBus) Publish(msg *Message) error {
+	b.mutex.Lock()
+	if b.closed {
+		b.mutex.Unlock()
+		return fmt.Errorf("message bus closed")
+	}
+
+	var receivers []*memorySubscription
+	groups := map[string][]*memorySubscription{}
+	for _, sub := range b.subs {
+		if !subjectMatches(sub.subject, msg.Subject) {
+			continue
+		}
+		if sub.queue == "" {
+			receivers = append(receivers, sub)
+		} else {
+			groups[sub.queue] = append(groups[sub.queue], sub)
+		}
+	}
+	for queue, members := range groups {
+		receivers = append(receivers, members[b.next[queue]%len(members)])
+		b.next[queue]++
+	}
+	b.mutex.Unlock()
+
+	for _, sub := range receivers {
+		sub.enqueue(msg)
+	}
+	return nil
+}
+
+func (b *memoryBus) Subscribe(subject, queue string, handler MessageHandler) (Subscription, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return nil, fmt.Errorf("message bus closed")
+	}
+
+	sub := &memorySubscription{
+		bus:      b,
+		subject:  subject,
+		queue:    queue,
+		handler:  handler,
+		messages: make(chan *Message, memorySubscriptionBuffer),
+		done:     make(chan struct{}),
+	}
+	b.subs = append(b.subs, sub)
+
+	go sub.run()
+	return sub, nil
+}
+
+// Request publishes a message with a unique reply subject and waits for the first reply
+func (b *memoryBus) Request(msg *Message, timeout time.Duration) (*Message, error) {
+	replies := make(chan *Message, 1)
+	inbox := "_INBOX." + primitive.NewObjectID().Hex()
+
+	sub, err := b.Subscribe(inbox, "", func(reply *Message) {
+		select {
+		case replies <- reply:
+		default:
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Unsubscribe()
+
+	request := *msg
+	request.Reply = inbox
+	if err := b.Publish(&request); err != nil {
+		return nil, err
+	}
+
+	select {
+	case reply := <-replies:
+		return reply, nil
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("no reply on %s within %s", msg.Subject, timeout)
+	}
+}
+
+func (b *memoryBus) Drain() error {
+	b.mutex.Lock()
+	b.closed = true
+	subs := append([]*memorySubscription(nil), b.subs...)
+	b.mutex.Unlock()
+
+	for _, sub := range subs {
+		sub.Drain()
+		<-sub.done
+	}
+	return nil
+}
+
+func (b *memoryBus) Ready() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return fmt.Errorf("message bus closed")
+	}
+	return nil
+}
+
+func (b *memoryBus) Connected() bool { return b.Ready() == nil }
+
+func (b *memoryBus) Status() map[string]interface{} {
+	consumers := resultSubscriptionCount()
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	state := "connected"
+	if b.closed {
+		state = "closed"
+	}
+	return map[string]interface{}{
+		"state":            state,
+		"subscriptions":    len(b.subs),
+		"result_consumers": consumers,
+	}
+}
+
+// hasSubscriber reports whether a subject has a local subscription
+func (b *memoryBus) hasSubscriber(subject string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for _, sub := range b.subs {
+		if subjectMatches(sub.subject, subject) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *memoryBus) remove(sub *memorySubscription) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for i, existing := range b.subs {
+		if existing == sub {
+			b.subs = append(b.subs[:i], b.subs[i+1:]...)
+			return
+		}
+	}
+}
+
+// memorySubscription handles its messages one at a time in its own goroutine
+type memorySubscription struct {
+	bus      *memoryBus
+	subject  string
+	queue    string
+	handler  MessageHandler
+	messages chan *Message
+	done     chan struct{}
+
+	mutex    sync.Mutex
+	draining bool
+}
+
+func (s *memorySubscription) run() {
+	defer close(s.done)
+	for msg := range s.messages {
+		s.handler(msg)
+	}
+}
+
+func (s *memorySubscription) enqueue(msg *Message) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.draining {
+		return
+	}
+	select {
+	case s.messages <- msg:
+	default:
+		log.Printf("Dropping message on %s, subscription to %s is too slow", msg.Subject, s.subject)
+	}
+}
+
+func (s *memorySubscription) Subject() string { return s.subject }
+
+func (s *memorySubscription) IsValid() bool {
+	select {
+	case <-s.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// Drain stops delivery to the subscription; the messages it already holds are still handled
+func (s *memorySubscription) Drain() error {
+	s.bus.remove(s)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.draining {
+		s.draining = true
+		close(s.messages)
+	}
+	return nil
+}
+
+func (s *memorySubscription) Unsubscribe() error {
+	return s.Drain()
+}
@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// natsBus is the message bus over the managed NATS connection
+type natsBus struct{}
+
+func (natsBus) Name() string { return "nats" }
+
+func (natsBus) Publish(msg *Message) error {
+	nc, err := GetNATS()
+	if err != nil {
+		return err
+	}
+	return nc.PublishMsg(toNATSMsg(msg))
+}
+
+func (natsBus) Subscribe(subject, queue string, handler MessageHandler) (Subscription, error) {
+	nc, err := GetNATS()
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := nc.QueueSubscribe(subject, queue, func(msg *nats.Msg) {
+		handler(fromNATSMsg(msg))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return natsSubscription{sub}, nil
+}
+
+func (natsBus) Request(msg *Message, timeout time.Duration) (*Message, error) {
+	nc, err := GetNATS()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := nc.RequestMsg(toNATSMsg(msg), timeout)
+	if err != nil {
+		return nil, err
+	}
+	return fromNATSMsg(reply), nil
+}
+
+func (natsBus) Drain() error {
+	CloseNATS()
+	return nil
+}
+
+func (natsBus) Ready() error {
+	_, err := GetNATS()
+	return err
+}
+
+func (natsBus) Connected() bool { return NATSConnected() }
+
+func (natsBus) Status() map[string]interface{} { return NATSStatus() }
+
+// natsSubscription adapts a NATS subscription to Subscription
+type natsSubscription struct {
+	sub *nats.Subscription
+}
+
+func (s natsSubscription) Subject() string    { return s.sub.Subject }
+func (s natsSubscription) IsValid() bool      { return s.sub.IsValid() }
+func (s natsSubscription) Drain() error       { return s.sub.Drain() }
+func (s natsSubscription) Unsubscribe() error { return s.sub.Unsubscribe() }
+
+func toNATSMsg(msg *Message) *nats.Msg {
+	natsMsg := &nats.Msg{Subject: msg.Subject, Reply: msg.Reply, Data: msg.Data}
+	if len(msg.Header) > 0 {
+		natsMsg.Header = nats.Header{}
+		for key, value := range msg.Header {
+			natsMsg.Header.Set(key, value)
+		}
+	}
+	return natsMsg
+}
+
+func fromNATSMsg(natsMsg *nats.Msg) *Message {
+	msg := &Message{Subject: natsMsg.Subject, Reply: natsMsg.Reply, Data: natsMsg.Data}
+	if len(natsMsg.Header) > 0 {
+		msg.Header = map[string]string{}
+		for key := range natsMsg.Header {
+			msg.Header[key] = natsMsg.Header.Get(key)
+		}
+	}
+	return msg
+}
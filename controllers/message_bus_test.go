@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSubjectMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		subject string
+		want    bool
+	}{
+		{"exact", "mrcontent.results", "mrcontent.results", true},
+		{"different token", "mrcontent.results", "mrcontent.requests", false},
+		{"subject longer", "mrcontent.results", "mrcontent.results.video", false},
+		{"subject shorter", "mrcontent.results.video", "mrcontent.results", false},
+		{"star matches one token", "mrcontent.*.events", "mrcontent.production.events", true},
+		{"star needs a token", "mrcontent.events.*", "mrcontent.events", false},
+		{"star matches only one token", "mrcontent.*", "mrcontent.events.abc", false},
+		{"trailing star", "mrcontent.events.*", "mrcontent.events.abc", true},
+		{"gt matches one token", "mrcontent.>", "mrcontent.events", true},
+		{"gt matches several tokens", "mrcontent.>", "mrcontent.events.abc", true},
+		{"gt needs a token", "mrcontent.>", "mrcontent", false},
+		{"gt after star", "*.events.>", "staging.events.abc.def", true},
+		{"gt alone", ">", "anything", true},
+		{"prefix mismatch before gt", "mrcontent.>", "other.events", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := subjectMatches(tt.pattern, tt.subject); got != tt.want {
+				t.Errorf("subjectMatches(%q, %q) = %v, want %v", tt.pattern, tt.subject, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestResultQueueGroupDeliversOnce subscribes several replicas to a result topic in the
+// result queue group and checks each result is handled by exactly one of them
+func TestResultQueueGroupDeliversOnce(t *testing.T) {
+	bus := newMemoryBus()
+	defer bus.Drain()
+
+	const replicas, results = 3, 30
+	var handled sync.WaitGroup
+	handled.Add(results)
+
+	var mutex sync.Mutex
+	deliveries := map[string]int{}
+	for i := 0; i < replicas; i++ {
+		_, err := bus.Subscribe("result.compressimage", ResultQueueGroup(), func(msg *Message) {
+			mutex.Lock()
+			deliveries[string(msg.Data)]++
+			mutex.Unlock()
+			handled.Done()
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < results; i++ {
+		if err := bus.Publish(&Message{Subject: "result.compressimage", Data: []byte{byte(i)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		handled.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("results were not handled in time")
+	}
+
+	// Give duplicate deliveries, if any, time to arrive
+	time.Sleep(50 * time.Millisecond)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(deliveries) != results {
+		t.Errorf("%d distinct results handled, want %d", len(deliveries), results)
+	}
+	for data, count := range deliveries {
+		if count != 1 {
+			t.Errorf("result %v handled %d times, want 1", []byte(data), count)
+		}
+	}
+}
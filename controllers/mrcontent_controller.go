@@ -19,6 +19,7 @@ import (
 	"github.com/praleedsuvarna/shared-libs/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
@@ -44,6 +45,11 @@ func CreateMRContent(c *fiber.Ctx) error {
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid organization ID"})
 	}
 
+	// Reject unusable or unsafe media URLs before anything is stored or dispatched
+	if fieldErrors := ValidateContentMedia(objOrgID, content, nil); len(fieldErrors) > 0 {
+		return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Invalid media URLs", "fields": fieldErrors})
+	}
+
 	// Get collection
 	collection := config.GetCollection("oms_mrexperiences")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -96,22 +102,32 @@ func CreateMRContent(c *fiber.Ctx) error {
 
 	content.Height = math.Round(content.Height*100) / 100
 
+	// Check if there are any media assets to process
+	hasMedia := (len(content.Images) > 0 || len(content.Videos) > 0 || len(content.Objects_3D) > 0)
+
+	// Queue processing of the media in the same operation as the insert, so it survives a crash
+	var intents []models.DispatchIntent
+	if hasMedia {
+		intents = append(intents, NewDispatchIntent(content, nil))
+	}
+
 	// Insert document
-	_, err = collection.InsertOne(ctx, content)
+	err = WriteWithOutbox(ctx, func(ctx context.Context) error {
+		_, err := collection.InsertOne(ctx, content)
+		return err
+	}, intents...)
 	if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
 
 	// Log the action
 	utils.LogAudit(userID, "Created MR content", content.ID.Hex())
+	RunInBackground(func() {
+		EmitWebhookEvent(content.OrganizationID, WebhookContentCreated, transformMRContentResponse(content))
+	})
 
-	// Check if there are any media assets to process
-	hasMedia := (len(content.Images) > 0 || len(content.Videos) > 0 || len(content.Objects_3D) > 0)
-
-	// Process media assets if any exist
 	if hasMedia {
-		go ProcessMediaForContent(content) // Process media in the background
-		log.Printf("Media processing triggered for content ID: %s", content.ID.Hex())
+		log.Printf("Media processing queued for content ID: %s", content.ID.Hex())
 	}
 
 	response := transformMRContentResponse(content)
@@ -159,6 +175,15 @@ func GetMRContent(c *fiber.Ctx) error {
 	// Transform the response to add flattened media
 	response := transformMRContentResponse(content)
 
+	// Per-asset processing state on request (include=processing)
+	if includesProcessing(c) {
+		if overview, err := LoadProcessingOverview(content, false); err == nil {
+			response["processing"] = overview
+		} else {
+			log.Printf("Error loading processing status for content ID %s: %v", content.ID.Hex(), err)
+		}
+	}
+
 	return c.JSON(response)
 }
 
@@ -172,6 +197,12 @@ func GetMRContentByRefID(c *fiber.Ctx) error {
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Reference ID is required"})
 	}
 
+	// Optional platform override for playback negotiation
+	platform := strings.ToLower(c.Query("platform"))
+	if platform != "" && !IsValidPlaybackPlatform(platform) {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid platform, expected ios, android or web"})
+	}
+
 	// Get collection
 	collection := config.GetCollection("oms_mrexperiences")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -195,9 +226,17 @@ func GetMRContentByRefID(c *fiber.Ctx) error {
 	// Debug info about found content
 	fmt.Printf("Found content with ID: %s\n", content.ID.Hex())
 
+	// Record the view asynchronously so analytics never slow down the response
+	RecordView(content, c.Get(fiber.HeaderUserAgent), c.Get(fiber.HeaderReferer))
+
 	// Transform the response to add flattened media
 	response := transformMRContentResponse(content)
 
+	// Resolve the best video rendition for the requesting device
+	if playback := NegotiatePlayback(content, platform, c.Get(fiber.HeaderUserAgent), c.Get(fiber.HeaderAccept)); playback != nil {
+		response["playback"] = playback
+	}
+
 	return c.JSON(response)
 }
 
@@ -258,8 +297,8 @@ func UpdateMRContent(c *fiber.Ctx) error {
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Failed to parse request body"})
 	}
 
-	// Create map to store new media URLs that need processing
-	newMediaForProcessing := make(map[string]string)
+	// New or changed originals that need processing, per media field
+	newMediaForProcessing := changedOriginals(existingContent, updateContent, rawBody)
 
 	// Create update set - start with just updated_at
 	updateSet := bson.M{
@@ -268,87 +307,15 @@ func UpdateMRContent(c *fiber.Ctx) error {
 
 	// Only update media arrays if they were explicitly provided in the request
 	if _, imagesProvided := rawBody["images"]; imagesProvided {
-		updatedImages := existingContent.Images
-		if updateContent.Images != nil {
-			// Check for new original media URLs before merging
-			existingImageURLs := make(map[string]string)
-			for _, img := range existingContent.Images {
-				if strings.HasPrefix(img.Key, "original") {
-					existingImageURLs[img.Key] = img.Value
-				}
-			}
-
-			// Find new or changed original images
-			for _, img := range updateContent.Images {
-				if strings.HasPrefix(img.Key, "original") {
-					existingURL, exists := existingImageURLs[img.Key]
-					if !exists || existingURL != img.Value {
-						// This is a new or modified original image URL
-						newMediaForProcessing[img.Key] = img.Value
-					}
-				}
-			}
-
-			// Merge media
-			updatedImages = mergeMediaByKey(existingContent.Images, updateContent.Images)
-		}
-		updateSet["images"] = updatedImages
+		updateSet["images"] = mergeMediaByKey(existingContent.Images, updateContent.Images)
 	}
 
 	if _, videosProvided := rawBody["videos"]; videosProvided {
-		updatedVideos := existingContent.Videos
-		if updateContent.Videos != nil {
-			// Check for new original media URLs before merging
-			existingVideoURLs := make(map[string]string)
-			for _, video := range existingContent.Videos {
-				if strings.HasPrefix(video.Key, "original") {
-					existingVideoURLs[video.Key] = video.Value
-				}
-			}
-
-			// Find new or changed original videos
-			for _, video := range updateContent.Videos {
-				if strings.HasPrefix(video.Key, "original") {
-					existingURL, exists := existingVideoURLs[video.Key]
-					if !exists || existingURL != video.Value {
-						// This is a new or modified original video URL
-						newMediaForProcessing[video.Key] = video.Value
-					}
-				}
-			}
-
-			// Merge media
-			updatedVideos = mergeMediaByKey(existingContent.Videos, updateContent.Videos)
-		}
-		updateSet["videos"] = updatedVideos
+		updateSet["videos"] = mergeMediaByKey(existingContent.Videos, updateContent.Videos)
 	}
 
 	if _, objects3DProvided := rawBody["objects_3d"]; objects3DProvided {
-		updatedObjects3D := existingContent.Objects_3D
-		if updateContent.Objects_3D != nil {
-			// Check for new original media URLs before merging
-			existingObjectURLs := make(map[string]string)
-			for _, obj := range existingContent.Objects_3D {
-				if strings.HasPrefix(obj.Key, "original") {
-					existingObjectURLs[obj.Key] = obj.Value
-				}
-			}
-
-			// Find new or changed original 3D objects
-			for _, obj := range updateContent.Objects_3D {
-				if strings.HasPrefix(obj.Key, "original") {
-					existingURL, exists := existingObjectURLs[obj.Key]
-					if !exists || existingURL != obj.Value {
-						// This is a new or modified original 3D object URL
-						newMediaForProcessing[obj.Key] = obj.Value
-					}
-				}
-			}
-
-			// Merge media
-			updatedObjects3D = mergeMediaByKey(existingContent.Objects_3D, updateContent.Objects_3D)
-		}
-		updateSet["objects_3d"] = updatedObjects3D
+		updateSet["objects_3d"] = mergeMediaByKey(existingContent.Objects_3D, updateContent.Objects_3D)
 	}
 
 	// Only update other fields if they're provided (not empty)
@@ -388,17 +355,41 @@ func UpdateMRContent(c *fiber.Ctx) error {
 		updateSet["height"] = math.Round(updateContent.Height*100) / 100
 	}
 
+	// Validate only media that can newly reach the media processor, the rest was checked
+	// when it was added. A changed render_type or has_alpha can dispatch media, such as a
+	// mask, that wasn't sent before.
+	checked := make(map[string]string)
+	for _, source := range processorMedia(existingContent, ResolvePipeline(existingContent)) {
+		checked[source.Field+"."+source.Media.Key] = source.Media.Value
+	}
+	fieldErrors := ValidateContentMedia(objOrgID, applyContentUpdate(existingContent, updateSet), func(field string, media models.Media) bool {
+		value, exists := checked[field+"."+media.Key]
+		return !exists || value != media.Value
+	})
+	if len(fieldErrors) > 0 {
+		return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Invalid media URLs", "fields": fieldErrors})
+	}
+
 	// Prepare update document
 	updateData := bson.M{
 		"$set": updateSet,
 	}
 
+	// Queue processing of only the new or changed originals in the same operation as the update
+	var intents []models.DispatchIntent
+	if len(newMediaForProcessing) > 0 {
+		intents = append(intents, NewDispatchIntent(existingContent, newMediaForProcessing))
+	}
+
 	// Update the document
-	_, err = collection.UpdateOne(
-		ctx,
-		bson.M{"_id": objContentID, "organization_id": objOrgID},
-		updateData,
-	)
+	err = WriteWithOutbox(ctx, func(ctx context.Context) error {
+		_, err := collection.UpdateOne(
+			ctx,
+			bson.M{"_id": objContentID, "organization_id": objOrgID},
+			updateData,
+		)
+		return err
+	}, intents...)
 
 	if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update MR content"})
@@ -414,59 +405,98 @@ func UpdateMRContent(c *fiber.Ctx) error {
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve updated content"})
 	}
 
-	// If new or changed original media was added, trigger media processing
+	// Notify subscribers when the content goes live
+	if updatedContent.Status == "published" && existingContent.Status != "published" {
+		RunInBackground(func() {
+			EmitWebhookEvent(updatedContent.OrganizationID, WebhookContentPublished, transformMRContentResponse(updatedContent))
+		})
+	}
+
 	if len(newMediaForProcessing) > 0 {
-		log.Printf("New or changed media detected for content ID %s. Processing %d media items",
-			updatedContent.ID.Hex(), len(newMediaForProcessing))
-
-		// Create a content object with only the new media to process
-		contentToProcess := models.MRContent{
-			ID:             updatedContent.ID,
-			OrganizationID: updatedContent.OrganizationID,
-			UserID:         updatedContent.UserID,
-			HasAlpha:       updatedContent.HasAlpha,
+		changed := 0
+		for _, keys := range newMediaForProcessing {
+			changed += len(keys)
 		}
+		log.Printf("Media processing queued for %d new or changed media items of content ID %s",
+			changed, updatedContent.ID.Hex())
+	} else {
+		log.Printf("No new media detected for content ID %s. Skipping media processing", updatedContent.ID.Hex())
+	}
 
-		// Add only the new/changed images to process
-		if _, imagesProvided := rawBody["images"]; imagesProvided {
-			for _, img := range updatedContent.Images {
-				if _, exists := newMediaForProcessing[img.Key]; exists && strings.HasPrefix(img.Key, "original") {
-					contentToProcess.Images = append(contentToProcess.Images, img)
-					log.Printf("Added new/changed image for processing: %s = %s", img.Key, img.Value)
-				}
-			}
+	// Return transformed response
+	response := transformMRContentResponse(updatedContent)
+	return c.JSON(response)
+}
+
+// changedOriginals returns the keys of the original media an update adds or changes, per
+// media field, e.g. {"images": ["original"], "videos": ["original"]}. Only fields present
+// in the request body are compared.
+func changedOriginals(existing, update models.MRContent, rawBody map[string]interface{}) map[string][]string {
+	fields := []struct {
+		name     string
+		existing []models.Media
+		updated  []models.Media
+	}{
+		{"images", existing.Images, update.Images},
+		{"videos", existing.Videos, update.Videos},
+		{"objects_3d", existing.Objects_3D, update.Objects_3D},
+	}
+
+	changed := make(map[string][]string)
+	for _, field := range fields {
+		if _, provided := rawBody[field.name]; !provided {
+			continue
 		}
 
-		// Add only the new/changed videos to process
-		if _, videosProvided := rawBody["videos"]; videosProvided {
-			for _, video := range updatedContent.Videos {
-				if _, exists := newMediaForProcessing[video.Key]; exists && strings.HasPrefix(video.Key, "original") {
-					contentToProcess.Videos = append(contentToProcess.Videos, video)
-					log.Printf("Added new/changed video for processing: %s = %s", video.Key, video.Value)
-				}
+		existingURLs := make(map[string]string)
+		for _, item := range field.existing {
+			if strings.HasPrefix(item.Key, "original") {
+				existingURLs[item.Key] = item.Value
 			}
 		}
 
-		// Add only the new/changed 3D objects to process
-		if _, objects3DProvided := rawBody["objects_3d"]; objects3DProvided {
-			for _, obj := range updatedContent.Objects_3D {
-				if _, exists := newMediaForProcessing[obj.Key]; exists && strings.HasPrefix(obj.Key, "original") {
-					contentToProcess.Objects_3D = append(contentToProcess.Objects_3D, obj)
-					log.Printf("Added new/changed 3D object for processing: %s = %s", obj.Key, obj.Value)
-				}
+		// The last entry of a key wins, as in mergeMediaByKey
+		updatedURLs := make(map[string]string)
+		var keys []string
+		for _, item := range field.updated {
+			if !strings.HasPrefix(item.Key, "original") {
+				continue
 			}
+			if _, seen := updatedURLs[item.Key]; !seen {
+				keys = append(keys, item.Key)
+			}
+			updatedURLs[item.Key] = item.Value
 		}
 
-		// Process only the new or changed media
-		go ProcessMediaForContent(contentToProcess)
-		log.Printf("Media processing triggered for updated content ID: %s with selective media", updatedContent.ID.Hex())
-	} else {
-		log.Printf("No new media detected for content ID %s. Skipping media processing", updatedContent.ID.Hex())
+		for _, key := range keys {
+			if existingURL, exists := existingURLs[key]; !exists || existingURL != updatedURLs[key] {
+				changed[field.name] = append(changed[field.name], key)
+			}
+		}
 	}
 
-	// Return transformed response
-	response := transformMRContentResponse(updatedContent)
-	return c.JSON(response)
+	return changed
+}
+
+// applyContentUpdate returns the content as it will be once the $set of an update is applied,
+// as far as media processing is concerned
+func applyContentUpdate(content models.MRContent, updateSet bson.M) models.MRContent {
+	if images, ok := updateSet["images"].([]models.Media); ok {
+		content.Images = images
+	}
+	if videos, ok := updateSet["videos"].([]models.Media); ok {
+		content.Videos = videos
+	}
+	if objects3D, ok := updateSet["objects_3d"].([]models.Media); ok {
+		content.Objects_3D = objects3D
+	}
+	if renderType, ok := updateSet["render_type"].(string); ok {
+		content.RenderType = renderType
+	}
+	if hasAlpha, ok := updateSet["has_alpha"].(bool); ok {
+		content.HasAlpha = hasAlpha
+	}
+	return content
 }
 
 // mergeMediaByKey merges new media items with existing ones based on keys
@@ -541,22 +571,44 @@ func DeleteMRContent(c *fiber.Ctx) error {
 		},
 	}
 
-	result, err := collection.UpdateOne(
-		ctx,
-		bson.M{"_id": objContentID, "organization_id": objOrgID, "is_active": true},
-		updateData,
-	)
+	// Processing still queued, dispatched or waiting in the outbox for deleted content would
+	// only take slots from other content, so it is closed in the same transaction
+	var dispatched int64
+	err = runInTransaction(ctx, func(ctx context.Context) error {
+		result, err := collection.UpdateOne(
+			ctx,
+			bson.M{"_id": objContentID, "organization_id": objOrgID, "is_active": true},
+			updateData,
+		)
+		if err != nil {
+			return err
+		}
+		if result.ModifiedCount == 0 {
+			return mongo.ErrNoDocuments
+		}
+
+		if dispatched, err = SupersedeContentTasks(ctx, objContentID); err != nil {
+			return err
+		}
+		return skipDispatchIntents(ctx, objContentID)
+	})
 
+	if err == mongo.ErrNoDocuments {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{"error": "MR content not found"})
+	}
 	if err != nil {
+		log.Printf("Error deleting MR content %s: %v", contentID, err)
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete MR content"})
 	}
 
-	if result.ModifiedCount == 0 {
-		return c.Status(http.StatusNotFound).JSON(fiber.Map{"error": "MR content not found"})
+	if dispatched > 0 {
+		freeDispatchSlots(objOrgID, dispatched)
+		RunInBackground(func() { ReleaseQueuedTasks(objOrgID) })
 	}
 
 	// Log the action
 	utils.LogAudit(userID, "Deleted MR content", contentID)
+	RunInBackground(func() { EmitWebhookEvent(objOrgID, WebhookContentDeleted, map[string]interface{}{"id": contentID}) })
 
 	return c.JSON(fiber.Map{"message": "MR content deleted successfully"})
 }
@@ -635,9 +687,19 @@ func ListMRContents(c *fiber.Ctx) error {
 	}
 	log.Printf("Pagination - limit: %d, skip: %d", limit, skip)
 
-	// Transform each content item to include flattened media
+	// Transform each content item to include flattened media; the summary view leaves
+	// the media out and only keeps thumbnail_url
+	summaryView := c.Query("view") == "summary"
 	var transformedContents []map[string]interface{}
 	for _, content := range contents {
+		if summaryView {
+			thumbnailURL := ContentThumbnailURL(content)
+			content.Images, content.Videos, content.Objects_3D = nil, nil, nil
+			item := transformMRContentResponse(content)
+			item["thumbnail_url"] = thumbnailURL
+			transformedContents = append(transformedContents, item)
+			continue
+		}
 		transformedContents = append(transformedContents, transformMRContentResponse(content))
 	}
 
@@ -680,6 +742,7 @@ func transformMRContentResponse(content models.MRContent) map[string]interface{}
 		"scale":           math.Round(scale*100) / 100,  // ADD THIS
 		"height":          math.Round(height*100) / 100, // ADD THIS
 		"is_active":       content.IsActive,
+		"thumbnail_url":   ContentThumbnailURL(content),
 		"created_at":      content.CreatedAt,
 		"updated_at":      content.UpdatedAt,
 	}
@@ -706,9 +769,29 @@ func transformMRContentResponse(content models.MRContent) map[string]interface{}
 		response[key] = obj.Value
 	}
 
+	// Every processed output with its metadata, e.g. the rungs of an HLS ladder
+	if len(content.Renditions) > 0 {
+		response["renditions"] = content.Renditions
+	}
+
 	return response
 }
 
+// ContentThumbnailURL returns the preview image for a content item: the first "thumbnail"
+// rendition of its videos, 3D objects or images, falling back to a poster frame
+func ContentThumbnailURL(content models.MRContent) string {
+	for _, key := range []string{"thumbnail", "poster"} {
+		for _, media := range [][]models.Media{content.Videos, content.Objects_3D, content.Images} {
+			for _, item := range media {
+				if item.Key == key && item.Value != "" {
+					return item.Value
+				}
+			}
+		}
+	}
+	return ""
+}
+
 // generateUniqueRefID creates a 6-digit alphanumeric and special character reference ID
 // that is browser-compatible
 func generateUniqueRefID() string {
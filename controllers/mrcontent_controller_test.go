@@ -0,0 +1,201 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestChangedOriginals(t *testing.T) {
+	existing := models.MRContent{
+		Images:     []models.Media{{Key: "original", Value: "https://cdn.example.com/a.jpg"}, {Key: "compressed", Value: "https://cdn.example.com/a-small.jpg"}},
+		Videos:     []models.Media{{Key: "original", Value: "https://cdn.example.com/a.mp4"}},
+		Objects_3D: []models.Media{{Key: "original", Value: "https://cdn.example.com/a.glb"}},
+	}
+
+	tests := []struct {
+		name    string
+		update  models.MRContent
+		rawBody map[string]interface{}
+		want    map[string][]string
+	}{
+		{
+			name: "two media types changed in one update",
+			update: models.MRContent{
+				Images: []models.Media{{Key: "original", Value: "https://cdn.example.com/b.jpg"}},
+				Videos: []models.Media{{Key: "original", Value: "https://cdn.example.com/b.mp4"}},
+			},
+			rawBody: map[string]interface{}{"images": nil, "videos": nil},
+			want:    map[string][]string{"images": {"original"}, "videos": {"original"}},
+		},
+		{
+			name: "every media type changed",
+			update: models.MRContent{
+				Images:     []models.Media{{Key: "original", Value: "https://cdn.example.com/b.jpg"}},
+				Videos:     []models.Media{{Key: "original", Value: "https://cdn.example.com/b.mp4"}},
+				Objects_3D: []models.Media{{Key: "original", Value: "https://cdn.example.com/b.glb"}},
+			},
+			rawBody: map[string]interface{}{"images": nil, "videos": nil, "objects_3d": nil},
+			want:    map[string][]string{"images": {"original"}, "videos": {"original"}, "objects_3d": {"original"}},
+		},
+		{
+			name: "unchanged original",
+			update: models.MRContent{
+				Images: []models.Media{{Key: "original", Value: "https://cdn.example.com/a.jpg"}},
+				Videos: []models.Media{{Key: "original", Value: "https://cdn.example.com/b.mp4"}},
+			},
+			rawBody: map[string]interface{}{"images": nil, "videos": nil},
+			want:    map[string][]string{"videos": {"original"}},
+		},
+		{
+			name: "new original key and non-original keys",
+			update: models.MRContent{
+				Images: []models.Media{{Key: "original_back", Value: "https://cdn.example.com/c.jpg"}, {Key: "compressed", Value: "https://cdn.example.com/x.jpg"}},
+			},
+			rawBody: map[string]interface{}{"images": nil},
+			want:    map[string][]string{"images": {"original_back"}},
+		},
+		{
+			name: "field not in the request body",
+			update: models.MRContent{
+				Videos: []models.Media{{Key: "original", Value: "https://cdn.example.com/b.mp4"}},
+			},
+			rawBody: map[string]interface{}{"images": nil},
+			want:    map[string][]string{},
+		},
+		{
+			name: "last entry of a key wins",
+			update: models.MRContent{
+				Videos: []models.Media{{Key: "original", Value: "https://cdn.example.com/b.mp4"}, {Key: "original", Value: "https://cdn.example.com/a.mp4"}},
+			},
+			rawBody: map[string]interface{}{"videos": nil},
+			want:    map[string][]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := changedOriginals(existing, tt.update, tt.rawBody); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("changedOriginals() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDeleteMRContentClosesProcessing deletes content with queued and dispatched tasks and a
+// pending dispatch intent, and checks none of them is left to run. It needs a MongoDB server
+// in MONGO_TEST_URI.
+func TestDeleteMRContentClosesProcessing(t *testing.T) {
+	database := connectTestDatabase(t)
+	useTestMessageBus(t, newMemoryBus())
+	ctx := context.Background()
+
+	orgID := primitive.NewObjectID()
+	contentID := primitive.NewObjectID()
+	now := time.Now()
+
+	if _, err := database.Collection("oms_mrexperiences").InsertOne(ctx, models.MRContent{
+		ID:             contentID,
+		OrganizationID: orgID,
+		Name:           "deleted",
+		IsActive:       true,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, status := range []string{"queued", "dispatched"} {
+		_, err := database.Collection(processingTasksCollection).InsertOne(ctx, models.ProcessingTask{
+			ID:             primitive.NewObjectID(),
+			ContentID:      contentID,
+			OrganizationID: orgID,
+			Subject:        "compressimage",
+			MediaType:      "image",
+			Payload:        "{}",
+			Outcomes:       []models.ExpectedOutcome{{ResultType: "compressed", Status: "pending"}},
+			Status:         status,
+			Priority:       "normal",
+			PriorityRank:   priorityRank("normal"),
+			QueuedAt:       now,
+			UpdatedAt:      now,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := insertDispatchIntents(ctx, []models.DispatchIntent{
+		NewDispatchIntent(models.MRContent{ID: contentID, OrganizationID: orgID}, nil),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := database.Collection(processingQuotasCollection).InsertOne(ctx,
+		bson.M{"organization_id": orgID, "in_flight": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user_id", "65f000000000000000000001")
+		c.Locals("organization_id", orgID.Hex())
+		return c.Next()
+	})
+	app.Delete("/content/:id", DeleteMRContent)
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodDelete, "/content/"+contentID.Hex(), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	open, err := database.Collection(processingTasksCollection).CountDocuments(ctx,
+		bson.M{"content_id": contentID, "status": bson.M{"$in": []string{"queued", "dispatched"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if open != 0 {
+		t.Errorf("%d task(s) still open after the delete", open)
+	}
+
+	pendingOutcomes, err := database.Collection(processingTasksCollection).CountDocuments(ctx,
+		bson.M{"content_id": contentID, "outcomes.status": "pending"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pendingOutcomes != 0 {
+		t.Errorf("%d task(s) still wait for results after the delete", pendingOutcomes)
+	}
+
+	pendingIntents, err := database.Collection(dispatchOutboxCollection).CountDocuments(ctx,
+		bson.M{"content_id": contentID, "status": "pending"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pendingIntents != 0 {
+		t.Errorf("%d dispatch intent(s) still pending after the delete", pendingIntents)
+	}
+
+	if got := quotaInFlight(t, database, orgID); got != 0 {
+		t.Errorf("in flight = %d, want 0", got)
+	}
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodDelete, "/content/"+contentID.Hex(), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("second delete status = %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
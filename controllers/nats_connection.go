@@ -0,0 +1,205 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// natsManager owns the NATS connection. The connection retries forever, so it is created
+// even while the server is unreachable: subscriptions are sent and buffered publishes are
+// flushed as soon as it connects.
+var natsManager struct {
+	mutex          sync.Mutex
+	conn           *nats.Conn
+	lastError      string
+	disconnectedAt *time.Time
+	reconnectedAt  *time.Time
+}
+
+// InitNATS initializes the NATS connection. It only fails when the connection cannot be
+// set up at all, such as for an invalid NATS_URL; a later GetNATS tries again.
+func InitNATS() (*nats.Conn, error) {
+	natsManager.mutex.Lock()
+	defer natsManager.mutex.Unlock()
+
+	if natsManager.conn != nil {
+		return natsManager.conn, nil
+	}
+
+	// Get NATS URL from environment
+	natsURL := os.Getenv("NATS_URL")
+	if natsURL == "" {
+		// Fallback to default if not set
+		natsURL = "nats://localhost:4222"
+		log.Printf("NATS_URL not found in environment, using default: %s", natsURL)
+	}
+
+	nc, err := nats.Connect(natsURL,
+		nats.Name("mrcontent-service"),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(2*time.Second),
+		nats.ReconnectJitter(500*time.Millisecond, 2*time.Second),
+		nats.ReconnectBufSize(getEnvInt("NATS_RECONNECT_BUFFER_MB", 8)<<20),
+		nats.PingInterval(time.Duration(getEnvInt("NATS_PING_INTERVAL_SECONDS", 20))*time.Second),
+		nats.MaxPingsOutstanding(3),
+		nats.ConnectHandler(handleNATSConnected),
+		nats.DisconnectErrHandler(handleNATSDisconnected),
+		nats.ReconnectHandler(handleNATSReconnected),
+		nats.ErrorHandler(handleNATSError),
+	)
+	if err != nil {
+		natsManager.lastError = err.Error()
+		log.Printf("NATS connection error: %v", err)
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+
+	natsManager.conn = nc
+
+	if nc.IsConnected() {
+		log.Printf("Successfully connected to NATS server at %s", natsURL)
+	} else {
+		log.Printf("NATS server at %s unreachable, connecting in the background", natsURL)
+	}
+	return nc, nil
+}
+
+// GetNATS returns the singleton NATS connection, initializing it if needed. The connection
+// may be reconnecting, in which case publishes are buffered until it is back.
+func GetNATS() (*nats.Conn, error) {
+	natsManager.mutex.Lock()
+	nc := natsManager.conn
+	natsManager.mutex.Unlock()
+
+	if nc != nil {
+		return nc, nil
+	}
+	return InitNATS()
+}
+
+// NATSConnected reports whether the NATS connection is currently up
+func NATSConnected() bool {
+	natsManager.mutex.Lock()
+	defer natsManager.mutex.Unlock()
+	return natsManager.conn != nil && natsManager.conn.IsConnected()
+}
+
+// CloseNATS drains the NATS connection, flushing pending publishes, and closes it
+func CloseNATS() {
+	natsManager.mutex.Lock()
+	nc := natsManager.conn
+	natsManager.conn = nil
+	natsManager.mutex.Unlock()
+
+	if nc == nil {
+		return
+	}
+
+	if err := nc.Drain(); err != nil {
+		log.Printf("Error draining NATS connection: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !nc.IsClosed() && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	nc.Close()
+}
+
+// NATSStatus describes the NATS connection for the health endpoint
+func NATSStatus() map[string]interface{} {
+	// Counted first: subscribing takes the result subscriptions lock before this one
+	consumers := resultSubscriptionCount()
+
+	natsManager.mutex.Lock()
+	defer natsManager.mutex.Unlock()
+
+	nc := natsManager.conn
+	if nc == nil {
+		status := map[string]interface{}{"state": "not_initialized"}
+		if natsManager.lastError != "" {
+			status["last_error"] = natsManager.lastError
+		}
+		return status
+	}
+
+	state := "disconnected"
+	switch nc.Status() {
+	case nats.CONNECTED:
+		state = "connected"
+	case nats.RECONNECTING:
+		state = "reconnecting"
+	case nats.CONNECTING:
+		state = "connecting"
+	case nats.DRAINING_SUBS, nats.DRAINING_PUBS:
+		state = "draining"
+	case nats.CLOSED:
+		state = "closed"
+	}
+
+	stats := nc.Stats()
+	buffered, _ := nc.Buffered()
+
+	return map[string]interface{}{
+		"state":            state,
+		"server":           nc.ConnectedUrlRedacted(),
+		"reconnects":       stats.Reconnects,
+		"buffered_bytes":   buffered,
+		"last_error":       natsManager.lastError,
+		"disconnected_at":  natsManager.disconnectedAt,
+		"reconnected_at":   natsManager.reconnectedAt,
+		"result_consumers": consumers,
+	}
+}
+
+// handleNATSConnected runs once the initial connection is established, which is after
+// startup when the server was unreachable then
+func handleNATSConnected(nc *nats.Conn) {
+	log.Printf("Connected to NATS server at %s", nc.ConnectedUrlRedacted())
+	restoreResultSubscriptions()
+}
+
+func handleNATSDisconnected(nc *nats.Conn, err error) {
+	now := time.Now()
+
+	natsManager.mutex.Lock()
+	natsManager.disconnectedAt = &now
+	if err != nil {
+		natsManager.lastError = err.Error()
+	}
+	natsManager.mutex.Unlock()
+
+	if err != nil {
+		log.Printf("Disconnected from NATS, reconnecting: %v", err)
+	} else {
+		log.Println("Disconnected from NATS")
+	}
+}
+
+func handleNATSReconnected(nc *nats.Conn) {
+	now := time.Now()
+
+	natsManager.mutex.Lock()
+	natsManager.reconnectedAt = &now
+	natsManager.mutex.Unlock()
+
+	log.Printf("Reconnected to NATS server at %s (%d reconnects)", nc.ConnectedUrlRedacted(), nc.Stats().Reconnects)
+	restoreResultSubscriptions()
+}
+
+func handleNATSError(nc *nats.Conn, sub *nats.Subscription, err error) {
+	natsManager.mutex.Lock()
+	natsManager.lastError = err.Error()
+	natsManager.mutex.Unlock()
+
+	if sub != nil {
+		log.Printf("NATS error on subscription to %s: %v", sub.Subject, err)
+	} else {
+		log.Printf("NATS error: %v", err)
+	}
+}
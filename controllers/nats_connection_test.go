@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+)
+
+// TestNATSStartsWithoutServer initializes NATS while the server is unreachable, as at a
+// startup before NATS is up, and checks the connection is kept for later instead of failing
+func TestNATSStartsWithoutServer(t *testing.T) {
+	if NATSStatus()["state"] != "not_initialized" {
+		t.Skip("a NATS connection is already initialized")
+	}
+
+	// Nothing listens on the discard port
+	t.Setenv("NATS_URL", "nats://127.0.0.1:9")
+	t.Cleanup(CloseNATS)
+
+	nc, err := InitNATS()
+	if err != nil {
+		t.Fatalf("InitNATS() error = %v, want a connection that retries", err)
+	}
+	if again, err := GetNATS(); err != nil || again != nc {
+		t.Errorf("GetNATS() = %p, %v, want the initialized connection %p", again, err, nc)
+	}
+
+	if NATSConnected() {
+		t.Error("NATSConnected() = true without a server")
+	}
+	if state := NATSStatus()["state"]; state != "connecting" && state != "reconnecting" {
+		t.Errorf("state = %v, want connecting or reconnecting", state)
+	}
+
+	// Publishes are buffered until the connection is up
+	if err := nc.Publish("result.compressimage", []byte("{}")); err != nil {
+		t.Errorf("Publish() while connecting error = %v", err)
+	}
+
+	started := time.Now()
+	CloseNATS()
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Errorf("CloseNATS() took %s on a connection that never came up", elapsed)
+	}
+	if state := NATSStatus()["state"]; state != "not_initialized" {
+		t.Errorf("state after close = %v, want not_initialized", state)
+	}
+}
@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"strings"
+)
+
+// PlaybackRendition describes one candidate video rendition for a viewer
+type PlaybackRendition struct {
+	Key      string `json:"key"`
+	URL      string `json:"url,omitempty"`
+	MimeType string `json:"mime_type"`
+	Ready    bool   `json:"ready"`
+	Status   string `json:"status"` // "ready", "pending", "unavailable"
+}
+
+// Playback is the resolved playback choice returned by the public ref endpoint
+type Playback struct {
+	Platform       string              `json:"platform"`        // "ios", "android", "web"
+	PlatformSource string              `json:"platform_source"` // "query" or "user_agent"
+	Alpha          bool                `json:"alpha"`
+	PosterURL      string              `json:"poster_url,omitempty"` // Frame to show before playback starts
+	Selected       *PlaybackRendition  `json:"selected"`
+	Fallbacks      []PlaybackRendition `json:"fallbacks"`
+}
+
+// Mime types for the video renditions produced by the MediaProcessor
+var renditionMimeTypes = map[string]string{
+	"hls":        "application/vnd.apple.mpegurl",
+	"dash":       "application/dash+xml",
+	"stitched":   "video/mp4",
+	"compressed": "video/mp4",
+	"original":   "video/mp4",
+}
+
+// Default fallback order per platform, best first
+var platformFallbackChains = map[string][]string{
+	"ios":     {"hls", "compressed", "original"},
+	"android": {"dash", "hls", "compressed", "original"},
+	"web":     {"dash", "hls", "compressed", "original"},
+}
+
+// IsValidPlaybackPlatform reports whether the platform query parameter is supported
+func IsValidPlaybackPlatform(platform string) bool {
+	_, ok := platformFallbackChains[platform]
+	return ok
+}
+
+// NegotiatePlayback picks the best available video rendition for the requesting device.
+// An explicit platform wins over the User-Agent, and the Accept header can promote a
+// streaming format the client says it understands. Returns nil for content without videos.
+func NegotiatePlayback(content models.MRContent, platform, userAgent, accept string) *Playback {
+	if len(content.Videos) == 0 {
+		return nil
+	}
+
+	playback := &Playback{
+		Platform:       platform,
+		PlatformSource: "query",
+		Alpha:          content.HasAlpha,
+		PosterURL:      firstMediaWithPrefix(content.Videos, "poster"),
+	}
+
+	if playback.Platform == "" {
+		playback.PlatformSource = "user_agent"
+		switch detectPlatform(userAgent) {
+		case "ios":
+			playback.Platform = "ios"
+		case "android":
+			playback.Platform = "android"
+		default:
+			playback.Platform = "web"
+		}
+	}
+
+	chain := append([]string{}, platformFallbackChains[playback.Platform]...)
+
+	// Promote a streaming format the client explicitly accepts
+	accept = strings.ToLower(accept)
+	if strings.Contains(accept, renditionMimeTypes["hls"]) {
+		chain = promoteRendition(chain, "hls")
+	} else if strings.Contains(accept, renditionMimeTypes["dash"]) {
+		chain = promoteRendition(chain, "dash")
+	}
+
+	// Alpha experiences must play the stitched color+alpha video to render transparency
+	if content.HasAlpha {
+		chain = append([]string{"stitched"}, chain...)
+	}
+
+	// Index available renditions by key, keeping the first original* as "original"
+	available := make(map[string]string)
+	for _, video := range content.Videos {
+		if video.Value == "" {
+			continue
+		}
+		key := video.Key
+		if strings.HasPrefix(key, "original") {
+			key = "original"
+		}
+		if _, exists := available[key]; !exists {
+			available[key] = video.Value
+		}
+	}
+
+	for _, key := range chain {
+		rendition := PlaybackRendition{
+			Key:      key,
+			MimeType: renditionMimeTypes[key],
+		}
+
+		if url, exists := available[key]; exists {
+			rendition.URL = url
+			rendition.Ready = true
+			rendition.Status = "ready"
+		} else if content.Status == "processing" {
+			rendition.Status = "pending"
+		} else {
+			rendition.Status = "unavailable"
+		}
+
+		playback.Fallbacks = append(playback.Fallbacks, rendition)
+
+		if playback.Selected == nil && rendition.Ready {
+			selected := rendition
+			playback.Selected = &selected
+		}
+	}
+
+	return playback
+}
+
+// promoteRendition moves key to the front of the chain
+func promoteRendition(chain []string, key string) []string {
+	result := []string{key}
+	for _, existing := range chain {
+		if existing != key {
+			result = append(result, existing)
+		}
+	}
+	return result
+}
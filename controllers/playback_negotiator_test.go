@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"testing"
+)
+
+const (
+	iphoneUA   = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1"
+	ipadUA     = "Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.6 Mobile/15E148 Safari/604.1"
+	androidUA  = "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Mobile Safari/537.36"
+	windowsUA  = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Safari/537.36"
+	macUA      = "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_4) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15"
+	linuxUA    = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Safari/537.36"
+	chromeOSUA = "Mozilla/5.0 (X11; CrOS x86_64 15633.69.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Safari/537.36"
+)
+
+func TestDetectPlatform(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		want      string
+	}{
+		{"iphone", iphoneUA, "ios"},
+		{"ipad", ipadUA, "ios"},
+		{"android before linux", androidUA, "android"},
+		{"windows", windowsUA, "windows"},
+		{"macos", macUA, "macos"},
+		{"linux", linuxUA, "linux"},
+		{"chromeos", chromeOSUA, "linux"},
+		{"empty", "", "other"},
+		{"unknown", "curl/8.5.0", "other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectPlatform(tt.userAgent); got != tt.want {
+				t.Errorf("detectPlatform(%q) = %q, want %q", tt.userAgent, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNegotiatePlayback(t *testing.T) {
+	allVideos := []models.Media{
+		{Key: "original", Value: "https://cdn.example.com/original.mp4"},
+		{Key: "compressed", Value: "https://cdn.example.com/compressed.mp4"},
+		{Key: "hls", Value: "https://cdn.example.com/master.m3u8"},
+		{Key: "dash", Value: "https://cdn.example.com/manifest.mpd"},
+		{Key: "poster", Value: "https://cdn.example.com/poster.jpg"},
+	}
+
+	tests := []struct {
+		name          string
+		content       models.MRContent
+		platform      string
+		userAgent     string
+		accept        string
+		wantPlatform  string
+		wantSource    string
+		wantSelected  string // Key of the selected rendition, "" for none
+		wantFallbacks []string
+		wantStatusOf  map[string]string
+		wantPosterURL string
+	}{
+		{
+			name:          "ios user agent prefers hls",
+			content:       models.MRContent{Videos: allVideos},
+			userAgent:     iphoneUA,
+			wantPlatform:  "ios",
+			wantSource:    "user_agent",
+			wantSelected:  "hls",
+			wantFallbacks: []string{"hls", "compressed", "original"},
+			wantPosterURL: "https://cdn.example.com/poster.jpg",
+		},
+		{
+			name:          "android user agent prefers dash",
+			content:       models.MRContent{Videos: allVideos},
+			userAgent:     androidUA,
+			wantPlatform:  "android",
+			wantSource:    "user_agent",
+			wantSelected:  "dash",
+			wantFallbacks: []string{"dash", "hls", "compressed", "original"},
+			wantPosterURL: "https://cdn.example.com/poster.jpg",
+		},
+		{
+			name:          "desktop user agent falls back to web",
+			content:       models.MRContent{Videos: allVideos},
+			userAgent:     windowsUA,
+			wantPlatform:  "web",
+			wantSource:    "user_agent",
+			wantSelected:  "dash",
+			wantFallbacks: []string{"dash", "hls", "compressed", "original"},
+			wantPosterURL: "https://cdn.example.com/poster.jpg",
+		},
+		{
+			name:          "platform query wins over user agent",
+			content:       models.MRContent{Videos: allVideos},
+			platform:      "ios",
+			userAgent:     androidUA,
+			wantPlatform:  "ios",
+			wantSource:    "query",
+			wantSelected:  "hls",
+			wantFallbacks: []string{"hls", "compressed", "original"},
+			wantPosterURL: "https://cdn.example.com/poster.jpg",
+		},
+		{
+			name:          "accept header promotes hls",
+			content:       models.MRContent{Videos: allVideos},
+			platform:      "web",
+			accept:        "Application/VND.Apple.MPEGURL, */*",
+			wantPlatform:  "web",
+			wantSource:    "query",
+			wantSelected:  "hls",
+			wantFallbacks: []string{"hls", "dash", "compressed", "original"},
+			wantPosterURL: "https://cdn.example.com/poster.jpg",
+		},
+		{
+			name:          "accept header promotes dash",
+			content:       models.MRContent{Videos: allVideos},
+			platform:      "ios",
+			accept:        "application/dash+xml",
+			wantPlatform:  "ios",
+			wantSource:    "query",
+			wantSelected:  "dash",
+			wantFallbacks: []string{"dash", "hls", "compressed", "original"},
+			wantPosterURL: "https://cdn.example.com/poster.jpg",
+		},
+		{
+			name: "alpha content puts stitched first",
+			content: models.MRContent{HasAlpha: true, Videos: []models.Media{
+				{Key: "original", Value: "https://cdn.example.com/original.mp4"},
+				{Key: "stitched", Value: "https://cdn.example.com/stitched.mp4"},
+				{Key: "hls", Value: "https://cdn.example.com/master.m3u8"},
+			}},
+			platform:      "ios",
+			wantPlatform:  "ios",
+			wantSource:    "query",
+			wantSelected:  "stitched",
+			wantFallbacks: []string{"stitched", "hls", "compressed", "original"},
+		},
+		{
+			name: "falls back to the first original while processing",
+			content: models.MRContent{Status: "processing", Videos: []models.Media{
+				{Key: "original_1", Value: "https://cdn.example.com/first.mp4"},
+				{Key: "original_2", Value: "https://cdn.example.com/second.mp4"},
+				{Key: "hls", Value: ""},
+			}},
+			platform:      "ios",
+			wantPlatform:  "ios",
+			wantSource:    "query",
+			wantSelected:  "original",
+			wantFallbacks: []string{"hls", "compressed", "original"},
+			wantStatusOf:  map[string]string{"hls": "pending", "compressed": "pending", "original": "ready"},
+		},
+		{
+			name: "nothing playable once processed",
+			content: models.MRContent{Status: "processed", Videos: []models.Media{
+				{Key: "poster", Value: "https://cdn.example.com/poster.jpg"},
+			}},
+			platform:      "android",
+			wantPlatform:  "android",
+			wantSource:    "query",
+			wantFallbacks: []string{"dash", "hls", "compressed", "original"},
+			wantStatusOf:  map[string]string{"dash": "unavailable", "original": "unavailable"},
+			wantPosterURL: "https://cdn.example.com/poster.jpg",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			playback := NegotiatePlayback(tt.content, tt.platform, tt.userAgent, tt.accept)
+			if playback == nil {
+				t.Fatal("NegotiatePlayback returned nil for content with videos")
+			}
+
+			if playback.Platform != tt.wantPlatform || playback.PlatformSource != tt.wantSource {
+				t.Errorf("platform = %q from %q, want %q from %q",
+					playback.Platform, playback.PlatformSource, tt.wantPlatform, tt.wantSource)
+			}
+			if playback.PosterURL != tt.wantPosterURL {
+				t.Errorf("poster = %q, want %q", playback.PosterURL, tt.wantPosterURL)
+			}
+
+			selected := ""
+			if playback.Selected != nil {
+				selected = playback.Selected.Key
+				if !playback.Selected.Ready || playback.Selected.URL == "" {
+					t.Errorf("selected rendition %q is not ready: %+v", selected, *playback.Selected)
+				}
+			}
+			if selected != tt.wantSelected {
+				t.Errorf("selected = %q, want %q", selected, tt.wantSelected)
+			}
+
+			var keys []string
+			statuses := map[string]string{}
+			for _, fallback := range playback.Fallbacks {
+				keys = append(keys, fallback.Key)
+				statuses[fallback.Key] = fallback.Status
+				if fallback.MimeType != renditionMimeTypes[fallback.Key] {
+					t.Errorf("mime type of %q = %q, want %q", fallback.Key, fallback.MimeType, renditionMimeTypes[fallback.Key])
+				}
+			}
+			if !equalStrings(keys, tt.wantFallbacks) {
+				t.Errorf("fallbacks = %v, want %v", keys, tt.wantFallbacks)
+			}
+			for key, want := range tt.wantStatusOf {
+				if statuses[key] != want {
+					t.Errorf("status of %q = %q, want %q", key, statuses[key], want)
+				}
+			}
+		})
+	}
+}
+
+func TestNegotiatePlaybackWithoutVideos(t *testing.T) {
+	content := models.MRContent{Images: []models.Media{{Key: "original", Value: "https://cdn.example.com/image.jpg"}}}
+	if playback := NegotiatePlayback(content, "", iphoneUA, ""); playback != nil {
+		t.Errorf("NegotiatePlayback = %+v, want nil for content without videos", *playback)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
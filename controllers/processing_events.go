@@ -0,0 +1,374 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/praleedsuvarna/shared-libs/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const processingEventsCollection = "oms_processing_events"
+
+// How long events are kept for Last-Event-ID resume
+const processingEventRetention = 24 * time.Hour
+
+// EventHub delivers processing events to the streams connected to this replica.
+// Events are fanned out between replicas over the message bus when one is available.
+type EventHub struct {
+	mutex       sync.RWMutex
+	subscribers map[string]map[chan models.ProcessingEvent]struct{}
+	fanout      MessageBus
+	closed      chan struct{} // Closed on shutdown to end every stream
+	closeOnce   sync.Once
+}
+
+// Global instance of the hub
+var eventHub = &EventHub{
+	subscribers: make(map[string]map[chan models.ProcessingEvent]struct{}),
+	closed:      make(chan struct{}),
+}
+
+// CloseEventStreams ends the live event streams of this replica and refuses new ones, so
+// shutdown doesn't wait on connections that never finish. Clients reconnect to another
+// replica and resume through Last-Event-ID.
+func CloseEventStreams() {
+	eventHub.closeOnce.Do(func() {
+		close(eventHub.closed)
+		log.Println("Processing event streams closed")
+	})
+}
+
+// eventsSubject returns the message bus subject events of a content item are fanned out on
+func eventsSubject(contentID string) string {
+	prefix := config.GetEnv("EVENTS_SUBJECT_PREFIX", "mrcontent."+config.GetEnv("APP_ENV", "development")+".events")
+	return prefix + "." + contentID
+}
+
+// InitEventFanout subscribes to the events published by every replica and makes sure
+// old events expire. Without a message bus, events are only delivered on the emitting replica.
+func InitEventFanout(bus MessageBus) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := config.GetCollection(processingEventsCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(processingEventRetention.Seconds())),
+	})
+	if err != nil {
+		log.Printf("Error creating processing events TTL index: %v", err)
+	}
+
+	if bus == nil {
+		log.Println("Warning: message bus not provided, processing events will not be fanned out")
+		return nil
+	}
+
+	sub, err := bus.Subscribe(eventsSubject("*"), "", func(msg *Message) {
+		var event models.ProcessingEvent
+		if err := json.Unmarshal(msg.Data, &event); err != nil {
+			log.Printf("Error unmarshaling processing event: %v", err)
+			return
+		}
+		eventHub.deliver(event)
+	})
+	if err != nil {
+		return fmt.Errorf("error subscribing to processing events: %w", err)
+	}
+	trackSubscription(sub)
+
+	eventHub.mutex.Lock()
+	eventHub.fanout = bus
+	eventHub.mutex.Unlock()
+
+	log.Printf("Subscribed to processing events on %s", eventsSubject("*"))
+	return nil
+}
+
+// EmitProcessingEvent stores an event for resume and pushes it to every connected stream
+func EmitProcessingEvent(contentID string, eventType string, data map[string]interface{}) {
+	objContentID, err := primitive.ObjectIDFromHex(contentID)
+	if err != nil {
+		return
+	}
+
+	event := models.ProcessingEvent{
+		ID:        primitive.NewObjectID(),
+		ContentID: objContentID,
+		Type:      eventType,
+		Data:      data,
+		CreatedAt: time.Now(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := config.GetCollection(processingEventsCollection).InsertOne(ctx, event); err != nil {
+		log.Printf("Error storing %s event for content ID %s: %v", eventType, contentID, err)
+	}
+
+	eventHub.mutex.RLock()
+	bus := eventHub.fanout
+	eventHub.mutex.RUnlock()
+
+	// Our own fan-out subscription delivers the event locally too
+	if bus != nil {
+		payload, err := json.Marshal(event)
+		if err == nil {
+			if err = bus.Publish(&Message{Subject: eventsSubject(contentID), Data: payload}); err == nil {
+				return
+			}
+		}
+		log.Printf("Error fanning out %s event, delivering locally only: %v", eventType, err)
+	}
+
+	eventHub.deliver(event)
+}
+
+// subscribe registers a stream for the events of a content item
+func (h *EventHub) subscribe(contentID string) (chan models.ProcessingEvent, func()) {
+	events := make(chan models.ProcessingEvent, 64)
+
+	h.mutex.Lock()
+	if h.subscribers[contentID] == nil {
+		h.subscribers[contentID] = make(map[chan models.ProcessingEvent]struct{})
+	}
+	h.subscribers[contentID][events] = struct{}{}
+	h.mutex.Unlock()
+
+	unsubscribe := func() {
+		h.mutex.Lock()
+		delete(h.subscribers[contentID], events)
+		if len(h.subscribers[contentID]) == 0 {
+			delete(h.subscribers, contentID)
+		}
+		h.mutex.Unlock()
+	}
+
+	return events, unsubscribe
+}
+
+// deliver hands an event to the local streams of its content; slow streams miss events
+// and catch up on reconnect through Last-Event-ID
+func (h *EventHub) deliver(event models.ProcessingEvent) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	for events := range h.subscribers[event.ContentID.Hex()] {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// eventsSince loads the stored events of a content item newer than lastEventID
+func eventsSince(contentID primitive.ObjectID, lastEventID string) ([]models.ProcessingEvent, error) {
+	afterID, err := primitive.ObjectIDFromHex(lastEventID)
+	if err != nil {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := config.GetCollection(processingEventsCollection).Find(ctx,
+		bson.M{"content_id": contentID, "_id": bson.M{"$gt": afterID}},
+		options.Find().SetSort(bson.M{"_id": 1}).SetLimit(1000),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var events []models.ProcessingEvent
+	err = cursor.All(ctx, &events)
+	return events, err
+}
+
+// StreamMRContentEvents streams processing events of a content item as Server-Sent Events,
+// or over a WebSocket when the request asks for an upgrade
+func StreamMRContentEvents(c *fiber.Ctx) error {
+	// Streams opened during shutdown would only be cut off again
+	select {
+	case <-eventHub.closed:
+		c.Set(fiber.HeaderRetryAfter, "3")
+		return c.Status(http.StatusServiceUnavailable).JSON(fiber.Map{"error": "Server is shutting down"})
+	default:
+	}
+
+	// Get content ID from params
+	contentID := c.Params("id")
+	if contentID == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Content ID is required"})
+	}
+
+	// Get user's organization ID from token
+	orgID := c.Locals("organization_id").(string)
+	objOrgID, err := primitive.ObjectIDFromHex(orgID)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid organization ID"})
+	}
+
+	objContentID, err := primitive.ObjectIDFromHex(contentID)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid content ID format"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Make sure the content belongs to the caller's organization
+	count, err := config.GetCollection("oms_mrexperiences").CountDocuments(ctx, bson.M{
+		"_id":             objContentID,
+		"organization_id": objOrgID,
+		"is_active":       true,
+	})
+	if err != nil || count == 0 {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{"error": "MR content not found"})
+	}
+
+	lastEventID := c.Get("Last-Event-ID", c.Query("last_event_id"))
+
+	// Subscribe before replaying so nothing emitted in between is lost
+	events, unsubscribe := eventHub.subscribe(contentID)
+
+	backlog, err := eventsSince(objContentID, lastEventID)
+	if err != nil {
+		unsubscribe()
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to load missed events"})
+	}
+
+	if websocket.IsWebSocketUpgrade(c) {
+		return websocket.New(func(conn *websocket.Conn) {
+			defer unsubscribe()
+			streamEventsWebSocket(conn, backlog, events, eventHub.closed)
+		})(c)
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer unsubscribe()
+		streamEventsSSE(w, backlog, events, eventHub.closed)
+	})
+
+	return nil
+}
+
+// streamEventsSSE writes the backlog and then live events until the client goes away or closed is closed
+func streamEventsSSE(w *bufio.Writer, backlog []models.ProcessingEvent, events chan models.ProcessingEvent, closed <-chan struct{}) {
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+
+	sent := make(map[primitive.ObjectID]bool, len(backlog))
+	write := func(event models.ProcessingEvent) error {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID.Hex(), event.Type, payload)
+		sent[event.ID] = true
+		return w.Flush()
+	}
+
+	// Tell the client how long to wait before reconnecting
+	fmt.Fprint(w, "retry: 3000\n\n")
+	if err := w.Flush(); err != nil {
+		return
+	}
+
+	for _, event := range backlog {
+		if err := write(event); err != nil {
+			return
+		}
+	}
+
+	for {
+		select {
+		case <-closed:
+			return
+
+		case event := <-events:
+			// Skip live events already sent as part of the backlog
+			if sent[event.ID] {
+				continue
+			}
+			if err := write(event); err != nil {
+				return
+			}
+
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": ping\n\n")
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// streamEventsWebSocket sends the backlog and then live events as JSON messages until the
+// client goes away or shutdown closes the stream
+func streamEventsWebSocket(conn *websocket.Conn, backlog []models.ProcessingEvent, events chan models.ProcessingEvent, shutdown <-chan struct{}) {
+	// Reading is only needed to notice the client closing the connection
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+
+	sent := make(map[primitive.ObjectID]bool, len(backlog))
+	for _, event := range backlog {
+		if err := conn.WriteJSON(event); err != nil {
+			return
+		}
+		sent[event.ID] = true
+	}
+
+	for {
+		select {
+		case <-closed:
+			return
+
+		case <-shutdown:
+			message := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+			conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+			return
+
+		case event := <-events:
+			if sent[event.ID] {
+				continue
+			}
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+
+		case <-keepAlive.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(5*time.Second)); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestStreamEventsSSEEndsOnShutdown(t *testing.T) {
+	backlog := []models.ProcessingEvent{
+		{ID: primitive.NewObjectID(), Type: "dispatched"},
+		{ID: primitive.NewObjectID(), Type: "rendition"},
+	}
+	events := make(chan models.ProcessingEvent, 1)
+	events <- backlog[1] // already sent with the backlog
+	closed := make(chan struct{})
+
+	var out bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		streamEventsSSE(bufio.NewWriter(&out), backlog, events, closed)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	close(closed)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("stream kept running after shutdown")
+	}
+
+	stream := out.String()
+	for _, event := range backlog {
+		if n := strings.Count(stream, "id: "+event.ID.Hex()+"\n"); n != 1 {
+			t.Errorf("event %s sent %d times, want once", event.ID.Hex(), n)
+		}
+	}
+}
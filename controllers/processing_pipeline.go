@@ -0,0 +1,376 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/praleedsuvarna/shared-libs/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const pipelinesCollection = "oms_processing_pipelines"
+
+var (
+	// Pipelines loaded from PROCESSING_PIPELINES / PROCESSING_PIPELINES_FILE
+	configuredPipelines     []models.ProcessingPipeline
+	configuredPipelinesOnce sync.Once
+)
+
+// plannedDispatch is a single request the pipeline wants published
+type plannedDispatch struct {
+	Subject         string
+	MediaType       string
+	SourceKey       string
+	SourceURL       string
+	MaskKey         string // Key of the mask video attached as alphavideo_url, if any
+	Request         TranscodeRequest
+	ExpectedResults []string
+}
+
+// DefaultPipeline returns the built-in pipeline used when nothing else is configured
+func DefaultPipeline() models.ProcessingPipeline {
+	return models.ProcessingPipeline{
+		RenderType: "*",
+		Steps: []models.PipelineStep{
+			{
+				MediaType:       "image",
+				Role:            "original",
+				Subject:         "compressimage",
+				ExpectedResults: []string{"compressed"},
+			},
+			{
+				// createexperience handles stitching, compression and HLS/DASH in one request
+				MediaType:       "video",
+				Role:            "original",
+				Subject:         "createexperience",
+				ExpectedResults: []string{"compressed", "hls"},
+				MaskResults:     []string{"stitched"},
+				AttachMask:      true,
+				FirstOnly:       true,
+			},
+			{
+				MediaType:       "object_3d",
+				Role:            "original",
+				Subject:         "optimizemodel",
+				ExpectedResults: GetModelTargetFormats(),
+			},
+			// Previews for list views: a small thumbnail and a larger poster frame
+			{
+				MediaType:       "image",
+				Role:            "original",
+				Subject:         "generatethumbnail",
+				ExpectedResults: []string{"thumbnail", "poster"},
+				FirstOnly:       true,
+			},
+			{
+				MediaType:       "video",
+				Role:            "original",
+				Subject:         "generatethumbnail",
+				ExpectedResults: []string{"thumbnail", "poster"},
+				FirstOnly:       true,
+			},
+			{
+				MediaType:       "object_3d",
+				Role:            "original",
+				Subject:         "generatethumbnail",
+				ExpectedResults: []string{"thumbnail", "poster"},
+				FirstOnly:       true,
+			},
+		},
+	}
+}
+
+// loadConfiguredPipelines reads pipeline definitions from the environment, either inline
+// JSON in PROCESSING_PIPELINES or a JSON file referenced by PROCESSING_PIPELINES_FILE
+func loadConfiguredPipelines() []models.ProcessingPipeline {
+	configuredPipelinesOnce.Do(func() {
+		data := []byte(os.Getenv("PROCESSING_PIPELINES"))
+		if len(data) == 0 {
+			path := os.Getenv("PROCESSING_PIPELINES_FILE")
+			if path == "" {
+				return
+			}
+
+			fileData, err := os.ReadFile(path)
+			if err != nil {
+				log.Printf("Error reading processing pipelines file %s: %v", path, err)
+				return
+			}
+			data = fileData
+		}
+
+		var pipelines []models.ProcessingPipeline
+		if err := json.Unmarshal(data, &pipelines); err != nil {
+			log.Printf("Error parsing processing pipelines, using built-in default: %v", err)
+			return
+		}
+
+		configuredPipelines = pipelines
+		log.Printf("Loaded %d processing pipelines from configuration", len(pipelines))
+	})
+
+	return configuredPipelines
+}
+
+// ResolvePipeline picks the pipeline for a content item. Organization pipelines win over
+// configured ones, an exact render_type wins over "*", and the built-in default is last.
+func ResolvePipeline(content models.MRContent) models.ProcessingPipeline {
+	if !content.OrganizationID.IsZero() {
+		if pipeline, found := findOrganizationPipeline(content.OrganizationID, content.RenderType); found {
+			return pipeline
+		}
+	}
+
+	pipelines := loadConfiguredPipelines()
+	for _, renderType := range []string{content.RenderType, "*"} {
+		for _, pipeline := range pipelines {
+			if pipeline.RenderType == renderType {
+				return pipeline
+			}
+		}
+	}
+
+	return DefaultPipeline()
+}
+
+// findOrganizationPipeline looks up an organization-specific pipeline for the render_type
+func findOrganizationPipeline(orgID primitive.ObjectID, renderType string) (models.ProcessingPipeline, bool) {
+	collection := config.GetCollection(pipelinesCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, candidate := range []string{renderType, "*"} {
+		var pipeline models.ProcessingPipeline
+		err := collection.FindOne(ctx, bson.M{
+			"organization_id": orgID,
+			"render_type":     candidate,
+		}).Decode(&pipeline)
+		if err == nil {
+			return pipeline, true
+		}
+	}
+
+	return models.ProcessingPipeline{}, false
+}
+
+// planDispatches expands a pipeline into the requests to publish for a content item
+func planDispatches(content models.MRContent, pipeline models.ProcessingPipeline) []plannedDispatch {
+	contentIDStr := content.ID.Hex()
+	orgIDStr := content.OrganizationID.Hex()
+
+	var plan []plannedDispatch
+	for _, step := range pipeline.Steps {
+		if step.RequireAlpha && !content.HasAlpha {
+			continue
+		}
+
+		for _, asset := range mediaForType(content, step.MediaType) {
+			if !strings.HasPrefix(asset.Key, step.Role) || asset.Value == "" {
+				continue
+			}
+
+			request := TranscodeRequest{
+				ContentID:      contentIDStr,
+				OrganizationID: orgIDStr,
+				CallbackURL:    CallbackURL(),
+				CallbackTopic:  CallbackTopic(),
+			}
+			expected := append([]string{}, step.ExpectedResults...)
+			maskKey := ""
+
+			switch step.MediaType {
+			case "image":
+				request.ImageURL = asset.Value
+			case "video":
+				request.VideoURL = asset.Value
+				if step.AttachMask {
+					if mask, found := firstMediaEntryWithPrefix(content.Videos, "mask"); found {
+						request.AlphaVideoURL = mask.Value
+						maskKey = mask.Key
+						expected = append(expected, step.MaskResults...)
+					}
+				}
+			case "object_3d":
+				request.ModelURL = asset.Value
+				if !expectsPreview(step.ExpectedResults) {
+					request.TargetFormats = step.ExpectedResults
+					request.DracoCompress = true
+				}
+			}
+
+			if expectsPreview(step.ExpectedResults) {
+				request.ThumbnailAt, request.ThumbnailSizes, request.PosterSize = thumbnailSettingsForStep(step)
+			}
+
+			plan = append(plan, plannedDispatch{
+				Subject:         step.Subject,
+				MediaType:       step.MediaType,
+				SourceKey:       asset.Key,
+				SourceURL:       asset.Value,
+				MaskKey:         maskKey,
+				Request:         request,
+				ExpectedResults: expected,
+			})
+
+			if step.FirstOnly {
+				break
+			}
+		}
+	}
+
+	return plan
+}
+
+// expectsPreview reports whether a step produces thumbnails or poster frames
+func expectsPreview(results []string) bool {
+	for _, result := range results {
+		if isPreviewRendition(result) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPreviewRendition reports whether a processing type is a thumbnail or poster frame
+func isPreviewRendition(processingType string) bool {
+	return processingType == "thumbnail" || processingType == "poster"
+}
+
+// thumbnailSettingsForStep applies a step's overrides to the configured preview settings
+func thumbnailSettingsForStep(step models.PipelineStep) (float64, []string, string) {
+	at, sizes, posterSize := GetThumbnailSettings()
+	if step.ThumbnailAt > 0 {
+		at = step.ThumbnailAt
+	}
+	if len(step.ThumbnailSizes) > 0 {
+		sizes = step.ThumbnailSizes
+	}
+	if step.PosterSize != "" {
+		posterSize = step.PosterSize
+	}
+	return at, sizes, posterSize
+}
+
+// ResultTopics returns the result.* subjects to subscribe to for every known pipeline
+func ResultTopics() []string {
+	seen := make(map[string]bool)
+	var topics []string
+	add := func(subject string) {
+		topic := "result." + subject
+		if subject != "" && !seen[topic] {
+			seen[topic] = true
+			topics = append(topics, topic)
+		}
+	}
+
+	// Subjects the MediaProcessor has always replied on
+	for _, subject := range []string{"compressimage", "compressvideo", "transcodehlsdash", "generatealpha", "stitchvideos", "optimizemodel", "generatethumbnail", "default"} {
+		add(subject)
+	}
+
+	pipelines := append([]models.ProcessingPipeline{DefaultPipeline()}, loadConfiguredPipelines()...)
+	for _, pipeline := range pipelines {
+		for _, step := range pipeline.Steps {
+			add(step.Subject)
+		}
+	}
+
+	// Organization pipelines may introduce subjects of their own
+	if config.DB != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		subjects, err := config.GetCollection(pipelinesCollection).Distinct(ctx, "steps.subject", bson.M{})
+		if err != nil {
+			log.Printf("Error loading organization pipeline subjects: %v", err)
+		}
+		for _, subject := range subjects {
+			if s, ok := subject.(string); ok {
+				add(s)
+			}
+		}
+	}
+
+	return topics
+}
+
+// CallbackTopic returns the subject the MediaProcessor should publish results to, so that
+// several environments can share one NATS cluster. Empty means the shared result.* subjects.
+func CallbackTopic() string {
+	return config.GetEnv("MEDIA_CALLBACK_TOPIC", "")
+}
+
+// CallbackURL returns the public URL of this service's HTTP callback endpoint, taken from
+// MEDIA_CALLBACK_URL or derived from PUBLIC_BASE_URL
+func CallbackURL() string {
+	if callbackURL := config.GetEnv("MEDIA_CALLBACK_URL", ""); callbackURL != "" {
+		return callbackURL
+	}
+	if baseURL := config.GetEnv("PUBLIC_BASE_URL", ""); baseURL != "" {
+		return strings.TrimRight(baseURL, "/") + "/api/media/callback"
+	}
+	return ""
+}
+
+// SubscriptionTopics returns the subjects this instance consumes results from. With a
+// callback topic configured the shared result.* subjects are skipped, unless
+// SUBSCRIBE_SHARED_RESULT_TOPICS keeps them for processors that ignore callback_topic.
+func SubscriptionTopics() []string {
+	topic := CallbackTopic()
+	if topic == "" {
+		return ResultTopics()
+	}
+
+	topics := []string{topic}
+	if config.GetEnv("SUBSCRIBE_SHARED_RESULT_TOPICS", "false") == "true" {
+		topics = append(topics, ResultTopics()...)
+	}
+	return topics
+}
+
+// ResultQueueGroup returns the NATS queue group result subscriptions join, so each result
+// is handled by one replica of the deployment (RESULT_QUEUE_GROUP). "none" subscribes every
+// replica to every result, which is only safe with a single instance.
+func ResultQueueGroup() string {
+	group := config.GetEnv("RESULT_QUEUE_GROUP", "mrcontent-results")
+	if group == "none" {
+		return ""
+	}
+	return group
+}
+
+// mediaForType returns the media array of a content item for a pipeline media type
+func mediaForType(content models.MRContent, mediaType string) []models.Media {
+	switch mediaType {
+	case "image":
+		return content.Images
+	case "video":
+		return content.Videos
+	case "object_3d":
+		return content.Objects_3D
+	}
+	return nil
+}
+
+// firstMediaWithPrefix returns the value of the first non-empty media whose key has the prefix
+func firstMediaWithPrefix(media []models.Media, prefix string) string {
+	item, _ := firstMediaEntryWithPrefix(media, prefix)
+	return item.Value
+}
+
+// firstMediaEntryWithPrefix returns the first non-empty media whose key has the prefix
+func firstMediaEntryWithPrefix(media []models.Media, prefix string) (models.Media, bool) {
+	for _, item := range media {
+		if strings.HasPrefix(item.Key, prefix) && item.Value != "" {
+			return item, true
+		}
+	}
+	return models.Media{}, false
+}
@@ -0,0 +1,411 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"reflect"
+	"testing"
+)
+
+// useConfiguredPipelines replaces the pipelines loaded from the environment for a test
+func useConfiguredPipelines(t *testing.T, pipelines []models.ProcessingPipeline) {
+	t.Helper()
+
+	configuredPipelinesOnce.Do(func() {})
+	previous := configuredPipelines
+	configuredPipelines = pipelines
+	t.Cleanup(func() { configuredPipelines = previous })
+}
+
+// dispatchSummary is the part of a planned dispatch the pipeline decides
+type dispatchSummary struct {
+	Subject   string
+	SourceKey string
+	MaskKey   string
+	Expected  []string
+}
+
+func summarizeDispatches(plan []plannedDispatch) []dispatchSummary {
+	summaries := []dispatchSummary{}
+	for _, dispatch := range plan {
+		summaries = append(summaries, dispatchSummary{
+			Subject:   dispatch.Subject,
+			SourceKey: dispatch.SourceKey,
+			MaskKey:   dispatch.MaskKey,
+			Expected:  dispatch.ExpectedResults,
+		})
+	}
+	return summaries
+}
+
+func TestResolvePipeline(t *testing.T) {
+	videoPipeline := models.ProcessingPipeline{RenderType: "video", Steps: []models.PipelineStep{{Subject: "video"}}}
+	anyPipeline := models.ProcessingPipeline{RenderType: "*", Steps: []models.PipelineStep{{Subject: "any"}}}
+
+	tests := []struct {
+		name       string
+		configured []models.ProcessingPipeline
+		renderType string
+		want       string
+	}{
+		{name: "exact render type", configured: []models.ProcessingPipeline{anyPipeline, videoPipeline}, renderType: "video", want: "video"},
+		{name: "wildcard for other render types", configured: []models.ProcessingPipeline{videoPipeline, anyPipeline}, renderType: "image", want: "any"},
+		{name: "default without a match", configured: []models.ProcessingPipeline{videoPipeline}, renderType: "image", want: "compressimage"},
+		{name: "default without configuration", renderType: "video", want: "compressimage"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfiguredPipelines(t, tt.configured)
+
+			// Content without an organization never looks up organization pipelines
+			pipeline := ResolvePipeline(models.MRContent{RenderType: tt.renderType})
+			if len(pipeline.Steps) == 0 || pipeline.Steps[0].Subject != tt.want {
+				t.Errorf("ResolvePipeline() first step = %+v, want subject %q", pipeline.Steps, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanDispatches(t *testing.T) {
+	content := models.MRContent{
+		Images: []models.Media{
+			{Key: "original", Value: "https://cdn.example.com/a.jpg"},
+			{Key: "compressed", Value: "https://cdn.example.com/a-small.jpg"},
+			{Key: "original_back", Value: "https://cdn.example.com/b.jpg"},
+			{Key: "original_empty", Value: ""},
+		},
+		Videos: []models.Media{
+			{Key: "original", Value: "https://cdn.example.com/a.mp4"},
+			{Key: "original_2", Value: "https://cdn.example.com/b.mp4"},
+			{Key: "mask_empty", Value: ""},
+			{Key: "mask", Value: "https://cdn.example.com/mask.mp4"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		steps    []models.PipelineStep
+		hasAlpha bool
+		want     []dispatchSummary
+	}{
+		{
+			name:  "every asset with the role",
+			steps: []models.PipelineStep{{MediaType: "image", Role: "original", Subject: "compressimage", ExpectedResults: []string{"compressed"}}},
+			want: []dispatchSummary{
+				{Subject: "compressimage", SourceKey: "original", Expected: []string{"compressed"}},
+				{Subject: "compressimage", SourceKey: "original_back", Expected: []string{"compressed"}},
+			},
+		},
+		{
+			name:  "first asset only",
+			steps: []models.PipelineStep{{MediaType: "image", Role: "original", Subject: "compressimage", ExpectedResults: []string{"compressed"}, FirstOnly: true}},
+			want: []dispatchSummary{
+				{Subject: "compressimage", SourceKey: "original", Expected: []string{"compressed"}},
+			},
+		},
+		{
+			name: "mask attached with its results",
+			steps: []models.PipelineStep{{
+				MediaType: "video", Role: "original", Subject: "createexperience",
+				ExpectedResults: []string{"compressed", "hls"}, MaskResults: []string{"stitched"}, AttachMask: true, FirstOnly: true,
+			}},
+			want: []dispatchSummary{
+				{Subject: "createexperience", SourceKey: "original", MaskKey: "mask", Expected: []string{"compressed", "hls", "stitched"}},
+			},
+		},
+		{
+			name:  "mask not attached",
+			steps: []models.PipelineStep{{MediaType: "video", Role: "original_2", Subject: "transcode", ExpectedResults: []string{"hls"}, MaskResults: []string{"stitched"}}},
+			want: []dispatchSummary{
+				{Subject: "transcode", SourceKey: "original_2", Expected: []string{"hls"}},
+			},
+		},
+		{
+			name:  "alpha step skipped without alpha",
+			steps: []models.PipelineStep{{MediaType: "video", Role: "mask", Subject: "alpha", ExpectedResults: []string{"alpha"}, RequireAlpha: true}},
+			want:  []dispatchSummary{},
+		},
+		{
+			name:     "alpha step with alpha",
+			steps:    []models.PipelineStep{{MediaType: "video", Role: "mask", Subject: "alpha", ExpectedResults: []string{"alpha"}, RequireAlpha: true}},
+			hasAlpha: true,
+			want: []dispatchSummary{
+				{Subject: "alpha", SourceKey: "mask", Expected: []string{"alpha"}},
+			},
+		},
+		{
+			name:  "media type without assets",
+			steps: []models.PipelineStep{{MediaType: "object_3d", Role: "original", Subject: "optimizemodel", ExpectedResults: []string{"glb"}}},
+			want:  []dispatchSummary{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := content
+			content.HasAlpha = tt.hasAlpha
+
+			got := summarizeDispatches(planDispatches(content, models.ProcessingPipeline{Steps: tt.steps}))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("planDispatches() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanDispatchesRequests(t *testing.T) {
+	content := models.MRContent{
+		Videos: []models.Media{
+			{Key: "original", Value: "https://cdn.example.com/a.mp4"},
+			{Key: "mask", Value: "https://cdn.example.com/mask.mp4"},
+		},
+	}
+	step := models.PipelineStep{MediaType: "video", Role: "original", Subject: "createexperience", ExpectedResults: []string{"hls"}, AttachMask: true}
+
+	plan := planDispatches(content, models.ProcessingPipeline{Steps: []models.PipelineStep{step}})
+	if len(plan) != 1 {
+		t.Fatalf("planDispatches() planned %d dispatches, want 1", len(plan))
+	}
+
+	request := plan[0].Request
+	if request.VideoURL != "https://cdn.example.com/a.mp4" || request.AlphaVideoURL != "https://cdn.example.com/mask.mp4" {
+		t.Errorf("request video = %q, alpha = %q", request.VideoURL, request.AlphaVideoURL)
+	}
+	if request.ContentID != content.ID.Hex() || request.CallbackTopic != CallbackTopic() {
+		t.Errorf("request content = %q, callback topic = %q", request.ContentID, request.CallbackTopic)
+	}
+	if plan[0].SourceURL != request.VideoURL {
+		t.Errorf("source URL = %q, want %q", plan[0].SourceURL, request.VideoURL)
+	}
+}
+
+func TestPlanDispatchesModels(t *testing.T) {
+	content := models.MRContent{
+		Objects_3D: []models.Media{
+			{Key: "original", Value: "https://cdn.example.com/a.fbx"},
+			{Key: "glb", Value: "https://cdn.example.com/a.glb"},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		formats     string
+		wantFormats []string
+	}{
+		{name: "default formats", wantFormats: []string{"glb", "usdz"}},
+		{name: "configured formats", formats: "glb, gltf ,,usdz", wantFormats: []string{"glb", "gltf", "usdz"}},
+		{name: "single format", formats: "usdz", wantFormats: []string{"usdz"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.formats != "" {
+				t.Setenv("MODEL_TARGET_FORMATS", tt.formats)
+			}
+
+			var step models.PipelineStep
+			for _, candidate := range DefaultPipeline().Steps {
+				if candidate.Subject == "optimizemodel" {
+					step = candidate
+				}
+			}
+
+			plan := planDispatches(content, models.ProcessingPipeline{Steps: []models.PipelineStep{step}})
+			if len(plan) != 1 {
+				t.Fatalf("planDispatches() planned %d dispatches, want 1", len(plan))
+			}
+
+			request := plan[0].Request
+			if request.ModelURL != "https://cdn.example.com/a.fbx" {
+				t.Errorf("model URL = %q", request.ModelURL)
+			}
+			if !request.DracoCompress {
+				t.Error("model request is not Draco compressed")
+			}
+			if !equalStrings(request.TargetFormats, tt.wantFormats) {
+				t.Errorf("target formats = %v, want %v", request.TargetFormats, tt.wantFormats)
+			}
+			if !equalStrings(plan[0].ExpectedResults, tt.wantFormats) {
+				t.Errorf("expected results = %v, want %v", plan[0].ExpectedResults, tt.wantFormats)
+			}
+		})
+	}
+}
+
+func TestPlanDispatchesPreviews(t *testing.T) {
+	t.Setenv("THUMBNAIL_AT_SECONDS", "2.5")
+	t.Setenv("THUMBNAIL_SIZES", "160x90, 320x180")
+	t.Setenv("POSTER_SIZE", "1920x1080")
+
+	content := models.MRContent{
+		Videos: []models.Media{
+			{Key: "original", Value: "https://cdn.example.com/a.mp4"},
+			{Key: "original_2", Value: "https://cdn.example.com/b.mp4"},
+		},
+		Objects_3D: []models.Media{{Key: "original", Value: "https://cdn.example.com/a.glb"}},
+	}
+
+	tests := []struct {
+		name       string
+		step       models.PipelineStep
+		wantSource string
+		wantAt     float64
+		wantSizes  []string
+		wantPoster string
+	}{
+		{
+			name:       "configured settings",
+			step:       models.PipelineStep{MediaType: "video", Role: "original", Subject: "generatethumbnail", ExpectedResults: []string{"thumbnail", "poster"}, FirstOnly: true},
+			wantSource: "https://cdn.example.com/a.mp4",
+			wantAt:     2.5,
+			wantSizes:  []string{"160x90", "320x180"},
+			wantPoster: "1920x1080",
+		},
+		{
+			name: "step overrides",
+			step: models.PipelineStep{
+				MediaType: "video", Role: "original", Subject: "generatethumbnail", ExpectedResults: []string{"thumbnail"}, FirstOnly: true,
+				ThumbnailAt: 10, ThumbnailSizes: []string{"64x64"}, PosterSize: "640x360",
+			},
+			wantSource: "https://cdn.example.com/a.mp4",
+			wantAt:     10,
+			wantSizes:  []string{"64x64"},
+			wantPoster: "640x360",
+		},
+		{
+			name:       "3D model preview",
+			step:       models.PipelineStep{MediaType: "object_3d", Role: "original", Subject: "generatethumbnail", ExpectedResults: []string{"poster"}},
+			wantSource: "https://cdn.example.com/a.glb",
+			wantAt:     2.5,
+			wantSizes:  []string{"160x90", "320x180"},
+			wantPoster: "1920x1080",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := planDispatches(content, models.ProcessingPipeline{Steps: []models.PipelineStep{tt.step}})
+			if len(plan) != 1 {
+				t.Fatalf("planDispatches() planned %d dispatches, want 1", len(plan))
+			}
+
+			request := plan[0].Request
+			if plan[0].SourceURL != tt.wantSource {
+				t.Errorf("source URL = %q, want %q", plan[0].SourceURL, tt.wantSource)
+			}
+			if request.ThumbnailAt != tt.wantAt || !equalStrings(request.ThumbnailSizes, tt.wantSizes) || request.PosterSize != tt.wantPoster {
+				t.Errorf("preview settings = %v %v %q, want %v %v %q",
+					request.ThumbnailAt, request.ThumbnailSizes, request.PosterSize, tt.wantAt, tt.wantSizes, tt.wantPoster)
+			}
+			// A model preview is not an optimization request
+			if len(request.TargetFormats) > 0 || request.DracoCompress {
+				t.Errorf("preview request asks for model formats %v", request.TargetFormats)
+			}
+		})
+	}
+}
+
+func TestPlanDispatchesWithoutPreviews(t *testing.T) {
+	content := models.MRContent{Images: []models.Media{{Key: "original", Value: "https://cdn.example.com/a.jpg"}}}
+	step := models.PipelineStep{MediaType: "image", Role: "original", Subject: "compressimage", ExpectedResults: []string{"compressed"}}
+
+	plan := planDispatches(content, models.ProcessingPipeline{Steps: []models.PipelineStep{step}})
+	if len(plan) != 1 {
+		t.Fatalf("planDispatches() planned %d dispatches, want 1", len(plan))
+	}
+	if request := plan[0].Request; request.ThumbnailAt != 0 || len(request.ThumbnailSizes) > 0 || request.PosterSize != "" {
+		t.Errorf("compression request carries preview settings %+v", request)
+	}
+}
+
+func TestCallbackURL(t *testing.T) {
+	tests := []struct {
+		name        string
+		callbackURL string
+		baseURL     string
+		want        string
+	}{
+		{name: "not configured", want: ""},
+		{name: "explicit URL", callbackURL: "https://hooks.example.com/results", baseURL: "https://api.example.com", want: "https://hooks.example.com/results"},
+		{name: "derived from base URL", baseURL: "https://api.example.com/", want: "https://api.example.com/api/media/callback"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("MEDIA_CALLBACK_URL", tt.callbackURL)
+			t.Setenv("PUBLIC_BASE_URL", tt.baseURL)
+
+			if got := CallbackURL(); got != tt.want {
+				t.Errorf("CallbackURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscriptionTopics(t *testing.T) {
+	useConfiguredPipelines(t, nil)
+	shared := ResultTopics()
+
+	tests := []struct {
+		name   string
+		topic  string
+		shared string
+		want   []string
+	}{
+		{name: "shared result topics", want: shared},
+		{name: "callback topic only", topic: "results.staging", want: []string{"results.staging"}},
+		{name: "callback topic and shared topics", topic: "results.staging", shared: "true", want: append([]string{"results.staging"}, shared...)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("MEDIA_CALLBACK_TOPIC", tt.topic)
+			t.Setenv("SUBSCRIBE_SHARED_RESULT_TOPICS", tt.shared)
+
+			if got := SubscriptionTopics(); !equalStrings(got, tt.want) {
+				t.Errorf("SubscriptionTopics() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	for _, topic := range []string{"result.compressimage", "result.createexperience", "result.optimizemodel", "result.generatethumbnail"} {
+		if !equalStrings([]string{topic}, filterTopics(shared, topic)) {
+			t.Errorf("ResultTopics() = %v, missing %s", shared, topic)
+		}
+	}
+}
+
+// filterTopics returns the occurrences of topic in topics
+func filterTopics(topics []string, topic string) []string {
+	var found []string
+	for _, candidate := range topics {
+		if candidate == topic {
+			found = append(found, candidate)
+		}
+	}
+	return found
+}
+
+func TestResultQueueGroup(t *testing.T) {
+	tests := []struct {
+		name  string
+		group string
+		want  string
+	}{
+		{name: "default group", want: "mrcontent-results"},
+		{name: "configured group", group: "mrcontent-staging", want: "mrcontent-staging"},
+		{name: "disabled", group: "none", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.group != "" {
+				t.Setenv("RESULT_QUEUE_GROUP", tt.group)
+			}
+
+			if got := ResultQueueGroup(); got != tt.want {
+				t.Errorf("ResultQueueGroup() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
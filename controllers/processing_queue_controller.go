@@ -0,0 +1,212 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/praleedsuvarna/shared-libs/config"
+	"github.com/praleedsuvarna/shared-libs/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// processingQuotaRequest is the body accepted when setting an organization's quota
+type processingQuotaRequest struct {
+	MaxInFlight *int   `json:"max_in_flight"`
+	Priority    string `json:"priority"`
+}
+
+// queueDepth summarises the open processing tasks of one organization
+type queueDepth struct {
+	OrganizationID   primitive.ObjectID `json:"organization_id"`
+	Priority         string             `json:"priority"`
+	MaxInFlight      int                `json:"max_in_flight"`
+	InFlight         int                `json:"in_flight"`
+	Queued           int                `json:"queued"`
+	QueuedByPriority map[string]int     `json:"queued_by_priority"`
+}
+
+// GetProcessingQueue returns the queue depth and quota of the caller's organization
+func GetProcessingQueue(c *fiber.Ctx) error {
+	objOrgID, err := primitive.ObjectIDFromHex(c.Locals("organization_id").(string))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid organization ID"})
+	}
+
+	depths, err := loadQueueDepths(bson.M{"organization_id": objOrgID})
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	depth, found := depths[objOrgID]
+	if !found {
+		depth = newQueueDepth(objOrgID)
+	}
+
+	return c.JSON(depth)
+}
+
+// ListProcessingQueues returns the queue depth of every organization with open processing tasks
+func ListProcessingQueues(c *fiber.Ctx) error {
+	depths, err := loadQueueDepths(bson.M{})
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	data := make([]*queueDepth, 0, len(depths))
+	totalQueued, totalInFlight := 0, 0
+	for _, depth := range depths {
+		data = append(data, depth)
+		totalQueued += depth.Queued
+		totalInFlight += depth.InFlight
+	}
+
+	return c.JSON(fiber.Map{
+		"data":      data,
+		"queued":    totalQueued,
+		"in_flight": totalInFlight,
+	})
+}
+
+// UpdateProcessingQuota sets an organization's in-flight limit and priority class.
+// Tasks already queued move to the new priority class.
+func UpdateProcessingQuota(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+
+	objOrgID, err := primitive.ObjectIDFromHex(c.Params("org_id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid organization ID"})
+	}
+
+	var request processingQuotaRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	now := time.Now()
+	updateSet := bson.M{"updated_at": now}
+
+	if request.MaxInFlight != nil {
+		if *request.MaxInFlight < 0 {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "max_in_flight cannot be negative"})
+		}
+		updateSet["max_in_flight"] = *request.MaxInFlight
+	}
+	if request.Priority != "" {
+		if priorityRank(request.Priority) < 0 {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "priority must be one of high, normal, low"})
+		}
+		updateSet["priority"] = request.Priority
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var quota models.ProcessingQuota
+	err = config.GetCollection(processingQuotasCollection).FindOneAndUpdate(ctx,
+		bson.M{"organization_id": objOrgID},
+		bson.M{
+			"$set":         updateSet,
+			"$setOnInsert": bson.M{"organization_id": objOrgID, "created_at": now},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&quota)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update processing quota"})
+	}
+
+	if request.Priority != "" {
+		_, err = config.GetCollection(processingTasksCollection).UpdateMany(ctx,
+			bson.M{"organization_id": objOrgID, "status": "queued"},
+			bson.M{"$set": bson.M{
+				"priority":      request.Priority,
+				"priority_rank": priorityRank(request.Priority),
+				"updated_at":    now,
+			}},
+		)
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update queued tasks"})
+		}
+	}
+
+	utils.LogAudit(userID, "Updated processing quota", objOrgID.Hex())
+
+	// A higher limit may let queued tasks go right away
+	RunInBackground(func() { ReleaseQueuedTasks(objOrgID) })
+
+	return c.JSON(quota)
+}
+
+// loadQueueDepths counts queued and in-flight tasks per organization and priority class
+func loadQueueDepths(match bson.M) (map[primitive.ObjectID]*queueDepth, error) {
+	match["status"] = bson.M{"$in": []string{"queued", "dispatched"}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := config.GetCollection(processingTasksCollection).Aggregate(ctx, []bson.M{
+		{"$match": match},
+		{"$group": bson.M{
+			"_id": bson.M{
+				"organization_id": "$organization_id",
+				"status":          "$status",
+				"priority":        "$priority",
+			},
+			"count": bson.M{"$sum": 1},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var groups []struct {
+		ID struct {
+			OrganizationID primitive.ObjectID `bson:"organization_id"`
+			Status         string             `bson:"status"`
+			Priority       string             `bson:"priority"`
+		} `bson:"_id"`
+		Count int `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	depths := map[primitive.ObjectID]*queueDepth{}
+	for _, group := range groups {
+		depth, found := depths[group.ID.OrganizationID]
+		if !found {
+			depth = newQueueDepth(group.ID.OrganizationID)
+			depths[group.ID.OrganizationID] = depth
+		}
+
+		if group.ID.Status == "dispatched" {
+			depth.InFlight += group.Count
+			continue
+		}
+		depth.Queued += group.Count
+		depth.QueuedByPriority[group.ID.Priority] += group.Count
+	}
+
+	return depths, nil
+}
+
+// newQueueDepth starts an empty queue summary carrying the organization's quota
+func newQueueDepth(orgID primitive.ObjectID) *queueDepth {
+	quota := organizationQuota(orgID)
+
+	depth := &queueDepth{
+		OrganizationID:   orgID,
+		Priority:         quota.Priority,
+		MaxInFlight:      quota.MaxInFlight,
+		QueuedByPriority: map[string]int{},
+	}
+	for _, priority := range processingPriorities {
+		depth.QueuedByPriority[priority] = 0
+	}
+	return depth
+}
@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/praleedsuvarna/shared-libs/config"
+	"github.com/praleedsuvarna/shared-libs/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ProcessingOverview is the processing state of a content item, per source asset
+type ProcessingOverview struct {
+	Status            string            `json:"status"`
+	Remaining         int               `json:"remaining"` // Results still expected
+	ProcessingVersion int               `json:"processing_version"`
+	Assets            []ProcessingAsset `json:"assets"`
+}
+
+// ProcessingAsset is one original and the tasks dispatched for it
+type ProcessingAsset struct {
+	MediaType string                  `json:"media_type"`
+	SourceKey string                  `json:"source_key"`
+	SourceURL string                  `json:"source_url"`
+	Status    string                  `json:"status"`            // "queued", "processing", "completed", "failed" or the status of its last task
+	Pending   []string                `json:"pending,omitempty"` // Renditions still expected, e.g. "hls"
+	Tasks     []models.ProcessingTask `json:"tasks"`
+	Timeline  []ProcessingStep        `json:"timeline"`
+}
+
+// ProcessingStep is a timestamped step in the processing of an asset
+type ProcessingStep struct {
+	At         time.Time `json:"at"`
+	Event      string    `json:"event"` // "queued", "dispatched", "succeeded", "failed", "completed", ...
+	TaskID     string    `json:"task_id"`
+	ResultType string    `json:"result_type,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// GetMRContentProcessing returns the processing state of each source asset of a content item.
+// Only the tasks of the current processing run are listed unless history=true.
+func GetMRContentProcessing(c *fiber.Ctx) error {
+	content, status, err := findOrganizationContent(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	overview, err := LoadProcessingOverview(content, c.Query("history") == "true")
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to load processing status"})
+	}
+
+	return c.JSON(overview)
+}
+
+// includesProcessing reports whether a request asked for the processing block with
+// include=processing
+func includesProcessing(c *fiber.Ctx) bool {
+	return utils.Contains(strings.Split(c.Query("include"), ","), "processing")
+}
+
+// LoadProcessingOverview groups the processing tasks of a content item by source asset.
+// With history, tasks of earlier processing runs are included too.
+func LoadProcessingOverview(content models.MRContent, history bool) (ProcessingOverview, error) {
+	overview := ProcessingOverview{
+		Status:            content.Status,
+		ProcessingVersion: content.ProcessingVersion,
+		Assets:            []ProcessingAsset{},
+	}
+
+	filter := bson.M{"content_id": content.ID}
+	if !history && content.ProcessingVersion > 0 {
+		filter["request_version"] = content.ProcessingVersion
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := config.GetCollection(processingTasksCollection).Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "queued_at", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return overview, fmt.Errorf("error loading processing tasks: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var tasks []models.ProcessingTask
+	if err := cursor.All(ctx, &tasks); err != nil {
+		return overview, fmt.Errorf("error decoding processing tasks: %w", err)
+	}
+
+	assets := map[string]int{}
+	for _, task := range tasks {
+		key := task.MediaType + "/" + task.SourceKey
+		index, ok := assets[key]
+		if !ok {
+			index = len(overview.Assets)
+			assets[key] = index
+			overview.Assets = append(overview.Assets, ProcessingAsset{
+				MediaType: task.MediaType,
+				SourceKey: task.SourceKey,
+				Tasks:     []models.ProcessingTask{},
+			})
+		}
+
+		asset := &overview.Assets[index]
+		asset.SourceURL = task.SourceURL
+		asset.Tasks = append(asset.Tasks, task)
+		asset.Timeline = append(asset.Timeline, taskTimeline(task)...)
+
+		if task.Status == "queued" || task.Status == "dispatched" {
+			pending := countPendingOutcomes(task)
+			overview.Remaining += pending
+			for _, outcome := range task.Outcomes {
+				if outcome.Status == "pending" && !utils.Contains(asset.Pending, outcome.ResultType) {
+					asset.Pending = append(asset.Pending, outcome.ResultType)
+				}
+			}
+		}
+	}
+
+	for i := range overview.Assets {
+		asset := &overview.Assets[i]
+		asset.Status = assetProcessingStatus(asset.Tasks)
+		sort.SliceStable(asset.Timeline, func(a, b int) bool {
+			return asset.Timeline[a].At.Before(asset.Timeline[b].At)
+		})
+	}
+
+	if overview.Remaining > 0 {
+		overview.Status = "processing"
+	}
+
+	return overview, nil
+}
+
+// assetProcessingStatus summarises the tasks of an asset: waiting and running tasks win,
+// then failures, and an asset is completed once all of its tasks are
+func assetProcessingStatus(tasks []models.ProcessingTask) string {
+	counts := map[string]int{}
+	for _, task := range tasks {
+		counts[task.Status]++
+	}
+
+	switch {
+	case counts["dispatched"] > 0:
+		return "processing"
+	case counts["queued"] > 0:
+		return "queued"
+	case counts["failed"] > 0 || counts["stalled"] > 0:
+		return "failed"
+	case counts["completed"] == len(tasks):
+		return "completed"
+	}
+	return tasks[len(tasks)-1].Status
+}
+
+// taskTimeline lists the timestamped steps of a task: queued, dispatched, each outcome
+// received, and how it ended
+func taskTimeline(task models.ProcessingTask) []ProcessingStep {
+	taskID := task.ID.Hex()
+	var steps []ProcessingStep
+
+	if !task.QueuedAt.IsZero() {
+		steps = append(steps, ProcessingStep{At: task.QueuedAt, Event: "queued", TaskID: taskID})
+	}
+	if !task.DispatchedAt.IsZero() {
+		steps = append(steps, ProcessingStep{At: task.DispatchedAt, Event: "dispatched", TaskID: taskID})
+	}
+
+	for _, outcome := range task.Outcomes {
+		if outcome.ReceivedAt != nil {
+			steps = append(steps, ProcessingStep{
+				At:         *outcome.ReceivedAt,
+				Event:      outcome.Status,
+				TaskID:     taskID,
+				ResultType: outcome.ResultType,
+				Error:      outcome.Error,
+			})
+		}
+	}
+
+	switch task.Status {
+	case "queued", "dispatched":
+	default:
+		at := task.UpdatedAt
+		if task.CompletedAt != nil {
+			at = *task.CompletedAt
+		}
+		steps = append(steps, ProcessingStep{At: at, Event: task.Status, TaskID: taskID})
+	}
+
+	return steps
+}
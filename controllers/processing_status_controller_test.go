@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestAssetProcessingStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []string
+		want     string
+	}{
+		{name: "single completed task", statuses: []string{"completed"}, want: "completed"},
+		{name: "all completed", statuses: []string{"completed", "completed"}, want: "completed"},
+		{name: "dispatched wins over queued and failed", statuses: []string{"failed", "queued", "dispatched"}, want: "processing"},
+		{name: "queued wins over failed", statuses: []string{"completed", "failed", "queued"}, want: "queued"},
+		{name: "failed wins over completed", statuses: []string{"completed", "failed"}, want: "failed"},
+		{name: "stalled counts as failed", statuses: []string{"stalled", "completed"}, want: "failed"},
+		{name: "status of the last task otherwise", statuses: []string{"completed", "superseded"}, want: "superseded"},
+		{name: "cancelled", statuses: []string{"cancelled"}, want: "cancelled"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tasks []models.ProcessingTask
+			for _, status := range tt.statuses {
+				tasks = append(tasks, models.ProcessingTask{Status: status})
+			}
+
+			if got := assetProcessingStatus(tasks); got != tt.want {
+				t.Errorf("assetProcessingStatus(%v) = %q, want %q", tt.statuses, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTaskTimeline(t *testing.T) {
+	taskID := primitive.NewObjectID()
+	queued := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	dispatched := queued.Add(time.Minute)
+	received := dispatched.Add(time.Minute)
+	failedAt := received.Add(time.Minute)
+	completed := failedAt.Add(time.Second)
+	updated := completed.Add(time.Second)
+
+	tests := []struct {
+		name string
+		task models.ProcessingTask
+		want []ProcessingStep
+	}{
+		{
+			name: "queued task",
+			task: models.ProcessingTask{
+				Status:   "queued",
+				QueuedAt: queued,
+				Outcomes: []models.ExpectedOutcome{{ResultType: "compressed", Status: "pending"}},
+			},
+			want: []ProcessingStep{{At: queued, Event: "queued"}},
+		},
+		{
+			name: "dispatched task with one result in",
+			task: models.ProcessingTask{
+				Status:       "dispatched",
+				QueuedAt:     queued,
+				DispatchedAt: dispatched,
+				Outcomes: []models.ExpectedOutcome{
+					{ResultType: "compressed", Status: "succeeded", ReceivedAt: &received},
+					{ResultType: "hls", Status: "pending"},
+				},
+			},
+			want: []ProcessingStep{
+				{At: queued, Event: "queued"},
+				{At: dispatched, Event: "dispatched"},
+				{At: received, Event: "succeeded", ResultType: "compressed"},
+			},
+		},
+		{
+			name: "failed task",
+			task: models.ProcessingTask{
+				Status:       "failed",
+				QueuedAt:     queued,
+				DispatchedAt: dispatched,
+				CompletedAt:  &completed,
+				UpdatedAt:    updated,
+				Outcomes: []models.ExpectedOutcome{
+					{ResultType: "compressed", Status: "succeeded", ReceivedAt: &received},
+					{ResultType: "hls", Status: "failed", Error: "unsupported codec", ReceivedAt: &failedAt},
+				},
+			},
+			want: []ProcessingStep{
+				{At: queued, Event: "queued"},
+				{At: dispatched, Event: "dispatched"},
+				{At: received, Event: "succeeded", ResultType: "compressed"},
+				{At: failedAt, Event: "failed", ResultType: "hls", Error: "unsupported codec"},
+				{At: completed, Event: "failed"},
+			},
+		},
+		{
+			name: "closed without a completion time",
+			task: models.ProcessingTask{
+				Status:    "stalled",
+				QueuedAt:  queued,
+				UpdatedAt: updated,
+			},
+			want: []ProcessingStep{
+				{At: queued, Event: "queued"},
+				{At: updated, Event: "stalled"},
+			},
+		},
+		{
+			name: "task without timestamps",
+			task: models.ProcessingTask{Status: "dispatched"},
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.task.ID = taskID
+			for i := range tt.want {
+				tt.want[i].TaskID = taskID.Hex()
+			}
+
+			if got := taskTimeline(tt.task); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("taskTimeline() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/praleedsuvarna/shared-libs/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Default time the MediaProcessor gets to answer a message before it is retried
+const defaultProcessingTimeout = 15 * time.Minute
+
+// ProcessingWatchdog re-publishes dispatched tasks that never received their results
+// and gives up on them after a maximum number of attempts
+type ProcessingWatchdog struct {
+	interval    time.Duration
+	maxAttempts int
+	cancel      context.CancelFunc
+	done        chan struct{}
+}
+
+// Global instance of the watchdog, nil until InitProcessingWatchdog is called
+var processingWatchdog *ProcessingWatchdog
+
+// InitProcessingWatchdog starts the background watchdog. Every replica may run one:
+// tasks are claimed atomically, so each stalled task is handled by a single replica.
+func InitProcessingWatchdog() *ProcessingWatchdog {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	watchdog := &ProcessingWatchdog{
+		interval:    time.Duration(getEnvInt("WATCHDOG_INTERVAL_SECONDS", 60)) * time.Second,
+		maxAttempts: getEnvInt("PROCESSING_MAX_ATTEMPTS", 3),
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+
+	go watchdog.run(ctx)
+
+	processingWatchdog = watchdog
+	log.Printf("Processing watchdog started (interval: %s, max attempts: %d)", watchdog.interval, watchdog.maxAttempts)
+	return watchdog
+}
+
+// StopProcessingWatchdog stops the global watchdog and waits for the current sweep to end
+func StopProcessingWatchdog() {
+	if processingWatchdog != nil {
+		processingWatchdog.cancel()
+		<-processingWatchdog.done
+		processingWatchdog = nil
+		log.Println("Processing watchdog stopped")
+	}
+}
+
+// run sweeps for stalled tasks on every tick until the context is cancelled
+func (w *ProcessingWatchdog) run(ctx context.Context) {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.sweep(ctx)
+		}
+	}
+}
+
+// sweep claims overdue tasks one at a time until none are left
+func (w *ProcessingWatchdog) sweep(ctx context.Context) {
+	for ctx.Err() == nil {
+		task, err := claimStalledTask(ctx)
+		if err == mongo.ErrNoDocuments {
+			return
+		}
+		if err != nil {
+			log.Printf("Error claiming stalled processing task: %v", err)
+			return
+		}
+
+		if task.Attempts >= w.maxAttempts {
+			w.giveUp(task)
+			continue
+		}
+
+		w.retry(task)
+	}
+}
+
+// claimStalledTask atomically pushes the deadline of one overdue task forward. The
+// conditional update is what keeps replicas from handling the same task twice.
+func claimStalledTask(ctx context.Context) (models.ProcessingTask, error) {
+	now := time.Now()
+
+	var task models.ProcessingTask
+	err := config.GetCollection(processingTasksCollection).FindOneAndUpdate(ctx,
+		bson.M{
+			"status":      "dispatched",
+			"deadline_at": bson.M{"$lte": now},
+		},
+		bson.M{"$set": bson.M{
+			// Short lease while this replica decides what to do with the task
+			"deadline_at": now.Add(time.Minute),
+			"updated_at":  now,
+		}},
+		options.FindOneAndUpdate().
+			SetSort(bson.M{"deadline_at": 1}).
+			SetReturnDocument(options.Before),
+	).Decode(&task)
+
+	return task, err
+}
+
+// retry re-publishes a task and extends its deadline with exponential backoff
+func (w *ProcessingWatchdog) retry(task models.ProcessingTask) {
+	attempt := task.Attempts + 1
+	backoff := processingTimeout(task.Subject) * time.Duration(1<<uint(task.Attempts))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	_, err := config.GetCollection(processingTasksCollection).UpdateOne(ctx,
+		bson.M{"_id": task.ID, "status": "dispatched"},
+		bson.M{"$set": bson.M{
+			"attempts":    attempt,
+			"deadline_at": now.Add(backoff),
+			"updated_at":  now,
+		}},
+	)
+	if err != nil {
+		log.Printf("Error updating processing task %s before retry: %v", task.ID.Hex(), err)
+		return
+	}
+
+	bus, err := GetMessageBus()
+	if err != nil {
+		log.Printf("Cannot retry processing task %s, message bus unavailable: %v", task.ID.Hex(), err)
+		return
+	}
+
+	subject, err := publishTask(bus, task)
+	if err != nil {
+		log.Printf("Error re-publishing processing task %s to %s: %v", task.ID.Hex(), subject, err)
+		return
+	}
+
+	log.Printf("Re-published stalled task %s to %s for content ID %s (attempt %d, next check in %s)",
+		task.ID.Hex(), subject, task.ContentID.Hex(), attempt, backoff)
+}
+
+// giveUp fails the task's pending outcomes and marks the content as stalled
+func (w *ProcessingWatchdog) giveUp(task models.ProcessingTask) {
+	reason := fmt.Sprintf("%s timed out after %d attempts", task.Subject, task.Attempts)
+
+	if err := FailDispatch(task.ID, reason); err != nil {
+		log.Printf("Error failing stalled task %s: %v", task.ID.Hex(), err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Record the task as stalled rather than merely failed, unless it closed in the meantime
+	_, err := config.GetCollection(processingTasksCollection).UpdateOne(ctx,
+		bson.M{"_id": task.ID, "status": "failed"},
+		bson.M{"$set": bson.M{"status": "stalled"}},
+	)
+	if err != nil {
+		log.Printf("Error marking task %s as stalled: %v", task.ID.Hex(), err)
+	}
+
+	// The stalled task no longer counts against the organization's quota
+	ReleaseQueuedTasks(task.OrganizationID)
+
+	result, err := config.GetCollection("oms_mrexperiences").UpdateOne(ctx,
+		bson.M{"_id": task.ContentID, "status": "processing"},
+		bson.M{"$set": bson.M{
+			"status":        "stalled",
+			"status_reason": reason,
+			"updated_at":    time.Now(),
+		}},
+	)
+	if err != nil {
+		log.Printf("Error marking content %s as stalled: %v", task.ContentID.Hex(), err)
+		return
+	}
+
+	EmitProcessingEvent(task.ContentID.Hex(), "failure", map[string]interface{}{
+		"task_id":    task.ID.Hex(),
+		"subject":    task.Subject,
+		"media_type": task.MediaType,
+		"error":      reason,
+	})
+
+	if result.ModifiedCount > 0 {
+		log.Printf("Content %s status changed to 'stalled': %s", task.ContentID.Hex(), reason)
+		EmitProcessingEvent(task.ContentID.Hex(), "status", map[string]interface{}{
+			"status": "stalled",
+			"reason": reason,
+		})
+		EmitWebhookEvent(task.OrganizationID, WebhookContentFailed, map[string]interface{}{
+			"id":         task.ContentID.Hex(),
+			"status":     "stalled",
+			"reason":     reason,
+			"subject":    task.Subject,
+			"media_type": task.MediaType,
+		})
+	}
+}
+
+// processingTimeout returns the timeout for a subject. PROCESSING_TIMEOUTS holds per-subject
+// overrides ("createexperience=30m,compressimage=5m"), PROCESSING_TIMEOUT_DEFAULT the fallback.
+func processingTimeout(subject string) time.Duration {
+	for _, entry := range strings.Split(config.GetEnv("PROCESSING_TIMEOUTS", ""), ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found || name != subject {
+			continue
+		}
+		if timeout, err := time.ParseDuration(value); err == nil && timeout > 0 {
+			return timeout
+		}
+	}
+
+	if timeout, err := time.ParseDuration(config.GetEnv("PROCESSING_TIMEOUT_DEFAULT", "")); err == nil && timeout > 0 {
+		return timeout
+	}
+
+	return defaultProcessingTimeout
+}
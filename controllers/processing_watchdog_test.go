@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProcessingTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeouts string
+		fallback string
+		subject  string
+		want     time.Duration
+	}{
+		{name: "built-in default", subject: "compressimage", want: defaultProcessingTimeout},
+		{name: "configured default", fallback: "20m", subject: "compressimage", want: 20 * time.Minute},
+		{name: "subject override", timeouts: "createexperience=30m, compressimage=5m", fallback: "20m", subject: "compressimage", want: 5 * time.Minute},
+		{name: "other subject uses the default", timeouts: "createexperience=30m", fallback: "20m", subject: "optimizemodel", want: 20 * time.Minute},
+		{name: "invalid override", timeouts: "compressimage=soon", fallback: "20m", subject: "compressimage", want: 20 * time.Minute},
+		{name: "non-positive override", timeouts: "compressimage=0s", subject: "compressimage", want: defaultProcessingTimeout},
+		{name: "invalid default", fallback: "-5m", subject: "compressimage", want: defaultProcessingTimeout},
+		{name: "entry without value", timeouts: "compressimage", fallback: "20m", subject: "compressimage", want: 20 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PROCESSING_TIMEOUTS", tt.timeouts)
+			t.Setenv("PROCESSING_TIMEOUT_DEFAULT", tt.fallback)
+
+			if got := processingTimeout(tt.subject); got != tt.want {
+				t.Errorf("processingTimeout(%q) = %s, want %s", tt.subject, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/praleedsuvarna/shared-libs/config"
+	"github.com/praleedsuvarna/shared-libs/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// reprocessRequest is the body accepted when starting a reprocessing job
+type reprocessRequest struct {
+	models.ReprocessFilter
+	DryRun        bool   `json:"dry_run"`
+	Limit         int64  `json:"limit"` // Content listed by a dry run
+	RatePerMinute int    `json:"rate_per_minute"`
+	Priority      string `json:"priority"`
+}
+
+// CreateReprocessJob starts re-dispatching processing for the content matching a filter.
+// With dry_run it only lists what would be sent.
+func CreateReprocessJob(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+
+	var request reprocessRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	job, err := NewReprocessJob(request.ReprocessFilter, request.RatePerMinute, request.Priority, userID)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if request.DryRun {
+		if request.Limit <= 0 || request.Limit > 1000 {
+			request.Limit = 100
+		}
+
+		matched, previews, err := PreviewReprocess(job.Filter, request.Limit)
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		return c.JSON(fiber.Map{
+			"dry_run":         true,
+			"matched":         matched,
+			"rate_per_minute": job.RatePerMinute,
+			"priority":        job.Priority,
+			"data":            previews,
+		})
+	}
+
+	if err := InsertReprocessJob(&job); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	utils.LogAudit(userID, "Started reprocess job", job.ID.Hex())
+
+	if err := StartReprocessJob(job.ID); err != nil {
+		log.Printf("Reprocess job %s not started here, leaving it to a runner: %v", job.ID.Hex(), err)
+	}
+
+	return c.Status(http.StatusCreated).JSON(job)
+}
+
+// ListReprocessJobs returns the most recent reprocessing jobs
+func ListReprocessJobs(c *fiber.Ctx) error {
+	limit, _ := strconv.ParseInt(c.Query("limit", "20"), 10, 64)
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	filter := bson.M{}
+	if status := c.Query("status"); status != "" {
+		filter["status"] = status
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := config.GetCollection(reprocessJobsCollection).Find(ctx, filter,
+		options.Find().
+			SetSort(bson.M{"created_at": -1}).
+			SetLimit(limit).
+			SetProjection(bson.M{"failures": 0}),
+	)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer cursor.Close(ctx)
+
+	jobs := []models.ReprocessJob{}
+	if err := cursor.All(ctx, &jobs); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"data": jobs})
+}
+
+// GetReprocessJob returns the progress and failure report of a reprocessing job
+func GetReprocessJob(c *fiber.Ctx) error {
+	job, status, err := findReprocessJob(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(ReprocessReport(job))
+}
+
+// PauseReprocessJob pauses a running job at its next checkpoint
+func PauseReprocessJob(c *fiber.Ctx) error {
+	return changeReprocessJobStatus(c, "running", "paused")
+}
+
+// ResumeReprocessJob continues a paused job from its checkpoint
+func ResumeReprocessJob(c *fiber.Ctx) error {
+	return changeReprocessJobStatus(c, "paused", "running")
+}
+
+// CancelReprocessJob stops a running or paused job for good
+func CancelReprocessJob(c *fiber.Ctx) error {
+	return changeReprocessJobStatus(c, "", "cancelled")
+}
+
+// changeReprocessJobStatus moves a job from one status to another. An empty from accepts
+// any job that is still running or paused.
+func changeReprocessJobStatus(c *fiber.Ctx, from, to string) error {
+	userID := c.Locals("user_id").(string)
+
+	job, status, err := findReprocessJob(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	fromStatuses := []string{from}
+	if from == "" {
+		fromStatuses = []string{"running", "paused"}
+	}
+
+	updated, err := SetReprocessJobStatus(job.ID, fromStatuses, to)
+	if err == mongo.ErrNoDocuments {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{"error": "Reprocess job is " + job.Status})
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	utils.LogAudit(userID, "Changed reprocess job status to "+to, job.ID.Hex())
+
+	if to == "running" {
+		if err := StartReprocessJob(job.ID); err != nil {
+			log.Printf("Reprocess job %s not resumed here, leaving it to a runner: %v", job.ID.Hex(), err)
+		}
+	}
+
+	return c.JSON(ReprocessReport(updated))
+}
+
+// findReprocessJob loads the job named by the :id route parameter
+func findReprocessJob(c *fiber.Ctx) (models.ReprocessJob, int, error) {
+	jobID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return models.ReprocessJob{}, http.StatusBadRequest, fmt.Errorf("Invalid reprocess job ID format")
+	}
+
+	job, err := LoadReprocessJob(jobID)
+	if err != nil {
+		return job, http.StatusNotFound, fmt.Errorf("Reprocess job not found")
+	}
+
+	return job, http.StatusOK, nil
+}
+
+// NewReprocessJob validates the parameters of a reprocessing job and fills in the defaults.
+// Reprocessing runs at low priority unless asked otherwise so it never delays new uploads.
+func NewReprocessJob(filter models.ReprocessFilter, ratePerMinute int, priority, createdBy string) (models.ReprocessJob, error) {
+	if err := ValidateReprocessFilter(filter); err != nil {
+		return models.ReprocessJob{}, err
+	}
+
+	if ratePerMinute <= 0 {
+		ratePerMinute = getEnvInt("REPROCESS_RATE_PER_MINUTE", 30)
+	}
+	if ratePerMinute > 6000 {
+		return models.ReprocessJob{}, fmt.Errorf("rate_per_minute cannot exceed 6000")
+	}
+	if priority == "" {
+		priority = "low"
+	}
+	if priorityRank(priority) < 0 {
+		return models.ReprocessJob{}, fmt.Errorf("priority must be one of high, normal, low")
+	}
+
+	now := time.Now()
+	return models.ReprocessJob{
+		ID:            primitive.NewObjectID(),
+		Filter:        filter,
+		Priority:      priority,
+		RatePerMinute: ratePerMinute,
+		Status:        "running",
+		CreatedBy:     createdBy,
+		StartedAt:     &now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}, nil
+}
+
+// InsertReprocessJob counts the content the job matches and stores it
+func InsertReprocessJob(job *models.ReprocessJob) error {
+	matched, err := CountReprocessMatches(job.Filter)
+	if err != nil {
+		return err
+	}
+	job.Matched = matched
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = config.GetCollection(reprocessJobsCollection).InsertOne(ctx, job)
+	return err
+}
+
+// ReprocessReport wraps a job with its progress
+func ReprocessReport(job models.ReprocessJob) fiber.Map {
+	percent := 100.0
+	if job.Matched > 0 && job.Processed < int(job.Matched) {
+		percent = float64(job.Processed) * 100 / float64(job.Matched)
+	}
+
+	return fiber.Map{
+		"job":      job,
+		"progress": percent,
+	}
+}
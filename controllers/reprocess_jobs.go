@@ -0,0 +1,515 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/praleedsuvarna/shared-libs/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const reprocessJobsCollection = "oms_reprocess_jobs"
+
+// Only the most recent failures are kept on a job
+const maxReprocessFailures = 200
+
+// Content is loaded in batches of this size while a job runs
+const reprocessBatchSize = 100
+
+// How long a runner owns a job without checkpointing. Rates are at least one item per
+// minute, so a live runner always renews it in time.
+const reprocessLease = 2 * time.Minute
+
+// How long a job waits for a disconnected message bus before a runner picks it up again
+const reprocessBusRetry = time.Minute
+
+// ErrReprocessJobNotRunnable is returned when a job is not running or another process holds it
+var ErrReprocessJobNotRunnable = errors.New("reprocess job is not running or is already being worked on")
+
+// ErrReprocessBusUnavailable is returned when a job stopped to wait for the message bus. The
+// job stays running and a runner resumes it once the bus is back.
+var ErrReprocessBusUnavailable = errors.New("message bus disconnected, the job is resumed once it reconnects")
+
+// ReprocessPreview is what a dry run reports for one content item
+type ReprocessPreview struct {
+	ContentID      primitive.ObjectID        `json:"content_id"`
+	OrganizationID primitive.ObjectID        `json:"organization_id"`
+	Name           string                    `json:"name"`
+	RenderType     string                    `json:"render_type"`
+	Status         string                    `json:"status"`
+	Requests       []ReprocessPreviewRequest `json:"requests"`
+}
+
+// ReprocessPreviewRequest is one message a dry run would publish
+type ReprocessPreviewRequest struct {
+	Subject         string   `json:"subject"`
+	MediaType       string   `json:"media_type"`
+	SourceKey       string   `json:"source_key"`
+	SourceURL       string   `json:"source_url"`
+	ExpectedResults []string `json:"expected_results"`
+}
+
+// ReprocessRunner works on reprocessing jobs in the background and resumes jobs whose
+// runner went away, e.g. because its replica was restarted
+type ReprocessRunner struct {
+	interval time.Duration
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+	jobs     sync.WaitGroup
+}
+
+// Global instance of the runner, nil until InitReprocessRunner is called
+var reprocessRunner *ReprocessRunner
+
+// InitReprocessRunner starts the background runner for reprocessing jobs
+func InitReprocessRunner() *ReprocessRunner {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	runner := &ReprocessRunner{
+		interval: time.Duration(getEnvInt("REPROCESS_POLL_SECONDS", 30)) * time.Second,
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+
+	go runner.run()
+
+	reprocessRunner = runner
+	log.Printf("Reprocess runner started (poll interval: %s)", runner.interval)
+	return runner
+}
+
+// StopReprocessRunner stops the runner and waits for running jobs to checkpoint. The jobs
+// stay "running" and are resumed by the next runner once their lease expires.
+func StopReprocessRunner() {
+	if reprocessRunner != nil {
+		reprocessRunner.cancel()
+		<-reprocessRunner.done
+		reprocessRunner.jobs.Wait()
+		reprocessRunner = nil
+		log.Println("Reprocess runner stopped")
+	}
+}
+
+// StartReprocessJob runs a job on this replica's runner
+func StartReprocessJob(jobID primitive.ObjectID) error {
+	if reprocessRunner == nil {
+		return errors.New("reprocess runner is not running")
+	}
+
+	job, err := ClaimReprocessJob(jobID)
+	if err != nil {
+		return err
+	}
+
+	reprocessRunner.start(job)
+	return nil
+}
+
+// run picks up orphaned jobs on every tick until the context is cancelled
+func (r *ReprocessRunner) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+			for r.ctx.Err() == nil {
+				job, err := claimReprocessJob(bson.M{})
+				if err == mongo.ErrNoDocuments {
+					break
+				}
+				if err != nil {
+					log.Printf("Error claiming reprocess job: %v", err)
+					break
+				}
+				log.Printf("Resuming reprocess job %s after content ID %s", job.ID.Hex(), job.LastContentID.Hex())
+				r.start(job)
+			}
+		}
+	}
+}
+
+func (r *ReprocessRunner) start(job models.ReprocessJob) {
+	r.jobs.Add(1)
+	go func() {
+		defer r.jobs.Done()
+		if err := RunReprocessJob(r.ctx, job); err != nil && r.ctx.Err() == nil {
+			log.Printf("Reprocess job %s stopped: %v", job.ID.Hex(), err)
+		}
+	}()
+}
+
+// ClaimReprocessJob takes the lease of a running job that nobody is working on
+func ClaimReprocessJob(jobID primitive.ObjectID) (models.ReprocessJob, error) {
+	job, err := claimReprocessJob(bson.M{"_id": jobID})
+	if err == mongo.ErrNoDocuments {
+		return job, ErrReprocessJobNotRunnable
+	}
+	return job, err
+}
+
+// claimReprocessJob atomically takes the lease of a running job whose lease is free or expired
+func claimReprocessJob(filter bson.M) (models.ReprocessJob, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	filter["status"] = "running"
+	filter["$or"] = []bson.M{
+		{"locked_until": bson.M{"$exists": false}},
+		{"locked_until": bson.M{"$lte": now}},
+	}
+
+	var job models.ReprocessJob
+	err := config.GetCollection(reprocessJobsCollection).FindOneAndUpdate(ctx, filter,
+		bson.M{
+			"$set": bson.M{
+				"lease_id":     primitive.NewObjectID(),
+				"locked_until": now.Add(reprocessLease),
+				"updated_at":   now,
+			},
+			"$unset": bson.M{"error": ""},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&job)
+
+	return job, err
+}
+
+// RunReprocessJob re-dispatches the job's content from its checkpoint at the job's rate until
+// the filter is exhausted, the job is paused or cancelled, or ctx is done. The caller must
+// hold the job's lease.
+func RunReprocessJob(ctx context.Context, job models.ReprocessJob) error {
+	limiter := time.NewTicker(time.Minute / time.Duration(job.RatePerMinute))
+	defer limiter.Stop()
+
+	log.Printf("Running reprocess job %s (%d matched, %d/min, %s priority)",
+		job.ID.Hex(), job.Matched, job.RatePerMinute, job.Priority)
+
+	for {
+		contents, err := loadReprocessBatch(job.Filter, job.LastContentID)
+		if err != nil {
+			return err
+		}
+
+		if len(contents) == 0 {
+			return finishReprocessJob(job, "completed", "")
+		}
+
+		for _, content := range contents {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-limiter.C:
+			}
+
+			// A message bus outage would fill the reconnect buffer or fail every remaining item,
+			// so step back and let a runner try again later
+			if bus, err := GetMessageBus(); err != nil || !bus.Connected() {
+				if err := deferReprocessJob(job, "message bus disconnected", reprocessBusRetry); err != nil {
+					return err
+				}
+				return ErrReprocessBusUnavailable
+			}
+
+			tasks, err := DispatchProcessing(content, job.Priority)
+
+			continued, checkpointErr := checkpointReprocessJob(job, content.ID, tasks, err)
+			if checkpointErr != nil {
+				return checkpointErr
+			}
+			if !continued {
+				log.Printf("Reprocess job %s was paused or cancelled", job.ID.Hex())
+				return nil
+			}
+
+			job.LastContentID = content.ID
+		}
+	}
+}
+
+// checkpointReprocessJob records the outcome for one content item and renews the job's lease.
+// It returns false when the job is no longer running or another runner took it over.
+func checkpointReprocessJob(job models.ReprocessJob, contentID primitive.ObjectID, tasks int, dispatchErr error) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	counters := bson.M{"processed": 1, "tasks": tasks}
+	update := bson.M{
+		"$set": bson.M{
+			"last_content_id": contentID,
+			"locked_until":    now.Add(reprocessLease),
+			"updated_at":      now,
+		},
+		"$inc": counters,
+	}
+
+	switch {
+	case dispatchErr != nil:
+		counters["failed"] = 1
+		update["$push"] = bson.M{"failures": bson.M{
+			"$each":  []models.ReprocessFailure{{ContentID: contentID, Error: dispatchErr.Error(), At: now}},
+			"$slice": -maxReprocessFailures,
+		}}
+	case tasks == 0:
+		counters["skipped"] = 1
+	default:
+		counters["dispatched"] = 1
+	}
+
+	result, err := config.GetCollection(reprocessJobsCollection).UpdateOne(ctx,
+		bson.M{"_id": job.ID, "status": "running", "lease_id": job.LeaseID}, update)
+	if err != nil {
+		return false, fmt.Errorf("error checkpointing reprocess job: %w", err)
+	}
+
+	return result.MatchedCount > 0, nil
+}
+
+// finishReprocessJob moves a running job to its final (or paused) status and releases its lease
+func finishReprocessJob(job models.ReprocessJob, status, reason string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	set := bson.M{"status": status, "updated_at": now}
+	if reason != "" {
+		set["error"] = reason
+	}
+	if status == "completed" {
+		set["completed_at"] = now
+	}
+
+	_, err := config.GetCollection(reprocessJobsCollection).UpdateOne(ctx,
+		bson.M{"_id": job.ID, "status": "running", "lease_id": job.LeaseID},
+		bson.M{"$set": set, "$unset": bson.M{"lease_id": "", "locked_until": ""}},
+	)
+	if err != nil {
+		return fmt.Errorf("error updating reprocess job: %w", err)
+	}
+
+	log.Printf("Reprocess job %s %s %s", job.ID.Hex(), status, reason)
+	return nil
+}
+
+// deferReprocessJob releases the lease of a job that stays running, so runners only claim it
+// again after the delay
+func deferReprocessJob(job models.ReprocessJob, reason string, delay time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	_, err := config.GetCollection(reprocessJobsCollection).UpdateOne(ctx,
+		bson.M{"_id": job.ID, "status": "running", "lease_id": job.LeaseID},
+		bson.M{
+			"$set":   bson.M{"locked_until": now.Add(delay), "error": reason, "updated_at": now},
+			"$unset": bson.M{"lease_id": ""},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("error updating reprocess job: %w", err)
+	}
+
+	log.Printf("Reprocess job %s waits %s: %s", job.ID.Hex(), delay, reason)
+	return nil
+}
+
+// ReleaseReprocessJob pauses a job this process holds, e.g. when the CLI running it is interrupted
+func ReleaseReprocessJob(job models.ReprocessJob) error {
+	return finishReprocessJob(job, "paused", "")
+}
+
+// LoadReprocessJob loads a reprocessing job by ID
+func LoadReprocessJob(jobID primitive.ObjectID) (models.ReprocessJob, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var job models.ReprocessJob
+	err := config.GetCollection(reprocessJobsCollection).FindOne(ctx, bson.M{"_id": jobID}).Decode(&job)
+	return job, err
+}
+
+// SetReprocessJobStatus moves a job in one of the from statuses to another status and drops
+// its lease, which stops the runner of a paused or cancelled job at its next checkpoint.
+// It returns mongo.ErrNoDocuments when the job is not in one of the from statuses.
+func SetReprocessJobStatus(jobID primitive.ObjectID, from []string, to string) (models.ReprocessJob, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	set := bson.M{"status": to, "updated_at": now}
+	if to == "cancelled" {
+		set["completed_at"] = now
+	}
+
+	var job models.ReprocessJob
+	err := config.GetCollection(reprocessJobsCollection).FindOneAndUpdate(ctx,
+		bson.M{"_id": jobID, "status": bson.M{"$in": from}},
+		bson.M{"$set": set, "$unset": bson.M{"lease_id": "", "locked_until": "", "error": ""}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&job)
+
+	return job, err
+}
+
+// loadReprocessBatch loads the next content items matching a filter after the checkpoint
+func loadReprocessBatch(filter models.ReprocessFilter, after primitive.ObjectID) ([]models.MRContent, error) {
+	query := reprocessQuery(filter)
+	if !after.IsZero() {
+		query["_id"] = bson.M{"$gt": after}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := GetMediaCollection().Find(ctx, query,
+		options.Find().SetSort(bson.M{"_id": 1}).SetLimit(reprocessBatchSize))
+	if err != nil {
+		return nil, fmt.Errorf("error loading content to reprocess: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var contents []models.MRContent
+	if err := cursor.All(ctx, &contents); err != nil {
+		return nil, fmt.Errorf("error decoding content to reprocess: %w", err)
+	}
+
+	return contents, nil
+}
+
+// PreviewReprocess counts the content matching a filter and lists what would be published
+// for up to limit of them, without recording or publishing anything
+func PreviewReprocess(filter models.ReprocessFilter, limit int64) (int64, []ReprocessPreview, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := reprocessQuery(filter)
+	matched, err := GetMediaCollection().CountDocuments(ctx, query)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error counting content to reprocess: %w", err)
+	}
+
+	cursor, err := GetMediaCollection().Find(ctx, query,
+		options.Find().SetSort(bson.M{"_id": 1}).SetLimit(limit))
+	if err != nil {
+		return 0, nil, fmt.Errorf("error loading content to reprocess: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var contents []models.MRContent
+	if err := cursor.All(ctx, &contents); err != nil {
+		return 0, nil, fmt.Errorf("error decoding content to reprocess: %w", err)
+	}
+
+	previews := make([]ReprocessPreview, 0, len(contents))
+	for _, content := range contents {
+		pipeline := ResolvePipeline(content)
+
+		preview := ReprocessPreview{
+			ContentID:      content.ID,
+			OrganizationID: content.OrganizationID,
+			Name:           content.Name,
+			RenderType:     content.RenderType,
+			Status:         content.Status,
+			Requests:       []ReprocessPreviewRequest{},
+		}
+		for _, dispatch := range planDispatches(content, pipeline) {
+			preview.Requests = append(preview.Requests, ReprocessPreviewRequest{
+				Subject:         dispatch.Subject,
+				MediaType:       dispatch.MediaType,
+				SourceKey:       dispatch.SourceKey,
+				SourceURL:       dispatch.SourceURL,
+				ExpectedResults: dispatch.ExpectedResults,
+			})
+		}
+		previews = append(previews, preview)
+	}
+
+	return matched, previews, nil
+}
+
+// CountReprocessMatches counts the content a filter selects
+func CountReprocessMatches(filter models.ReprocessFilter) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return GetMediaCollection().CountDocuments(ctx, reprocessQuery(filter))
+}
+
+// ValidateReprocessFilter checks the parts of a filter that cannot be expressed by its types
+func ValidateReprocessFilter(filter models.ReprocessFilter) error {
+	if filter.CreatedAfter != nil && filter.CreatedBefore != nil && !filter.CreatedAfter.Before(*filter.CreatedBefore) {
+		return errors.New("created_after must be before created_before")
+	}
+
+	if filter.MissingRendition != "" {
+		field, key, found := strings.Cut(filter.MissingRendition, ".")
+		if !found || key == "" || !isMediaField(field) {
+			return errors.New("missing_rendition must look like videos.hls, images.compressed or objects_3d.glb")
+		}
+	}
+
+	return nil
+}
+
+// reprocessQuery turns a filter into a query on active content
+func reprocessQuery(filter models.ReprocessFilter) bson.M {
+	query := bson.M{"is_active": true}
+
+	if filter.OrganizationID != nil {
+		query["organization_id"] = *filter.OrganizationID
+	}
+	if len(filter.RenderTypes) > 0 {
+		query["render_type"] = bson.M{"$in": filter.RenderTypes}
+	}
+	if len(filter.Statuses) > 0 {
+		query["status"] = bson.M{"$in": filter.Statuses}
+	}
+
+	created := bson.M{}
+	if filter.CreatedAfter != nil {
+		created["$gte"] = *filter.CreatedAfter
+	}
+	if filter.CreatedBefore != nil {
+		created["$lt"] = *filter.CreatedBefore
+	}
+	if len(created) > 0 {
+		query["created_at"] = created
+	}
+
+	// Content that has an original of that media type but not the rendition
+	if field, key, found := strings.Cut(filter.MissingRendition, "."); found {
+		query[field] = bson.M{
+			"$elemMatch": bson.M{"k": bson.M{"$regex": "^original"}},
+			"$not":       bson.M{"$elemMatch": bson.M{"k": key}},
+		}
+	}
+
+	return query
+}
+
+// isMediaField reports whether name is one of the media arrays of a content item
+func isMediaField(name string) bool {
+	for _, field := range mediaFields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
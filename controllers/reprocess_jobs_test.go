@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestValidateReprocessFilter(t *testing.T) {
+	earlier := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	later := earlier.Add(24 * time.Hour)
+
+	tests := []struct {
+		name    string
+		filter  models.ReprocessFilter
+		wantErr bool
+	}{
+		{name: "empty filter", filter: models.ReprocessFilter{}},
+		{name: "ordered created range", filter: models.ReprocessFilter{CreatedAfter: &earlier, CreatedBefore: &later}},
+		{name: "only created after", filter: models.ReprocessFilter{CreatedAfter: &later}},
+		{name: "reversed created range", filter: models.ReprocessFilter{CreatedAfter: &later, CreatedBefore: &earlier}, wantErr: true},
+		{name: "empty created range", filter: models.ReprocessFilter{CreatedAfter: &earlier, CreatedBefore: &earlier}, wantErr: true},
+		{name: "video rendition", filter: models.ReprocessFilter{MissingRendition: "videos.hls"}},
+		{name: "image rendition", filter: models.ReprocessFilter{MissingRendition: "images.compressed"}},
+		{name: "3d rendition", filter: models.ReprocessFilter{MissingRendition: "objects_3d.glb"}},
+		{name: "rendition without field", filter: models.ReprocessFilter{MissingRendition: "hls"}, wantErr: true},
+		{name: "rendition without key", filter: models.ReprocessFilter{MissingRendition: "videos."}, wantErr: true},
+		{name: "unknown media field", filter: models.ReprocessFilter{MissingRendition: "audio.mp3"}, wantErr: true},
+		{name: "media type instead of field", filter: models.ReprocessFilter{MissingRendition: "video.hls"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateReprocessFilter(tt.filter)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateReprocessFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReprocessQuery(t *testing.T) {
+	orgID := primitive.NewObjectID()
+	earlier := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	later := earlier.Add(24 * time.Hour)
+
+	tests := []struct {
+		name   string
+		filter models.ReprocessFilter
+		want   bson.M
+	}{
+		{
+			name:   "empty filter selects active content",
+			filter: models.ReprocessFilter{},
+			want:   bson.M{"is_active": true},
+		},
+		{
+			name: "organization, render types and statuses",
+			filter: models.ReprocessFilter{
+				OrganizationID: &orgID,
+				RenderTypes:    []string{"video", "image"},
+				Statuses:       []string{"published"},
+			},
+			want: bson.M{
+				"is_active":       true,
+				"organization_id": orgID,
+				"render_type":     bson.M{"$in": []string{"video", "image"}},
+				"status":          bson.M{"$in": []string{"published"}},
+			},
+		},
+		{
+			name:   "created after only",
+			filter: models.ReprocessFilter{CreatedAfter: &earlier},
+			want:   bson.M{"is_active": true, "created_at": bson.M{"$gte": earlier}},
+		},
+		{
+			name:   "created range",
+			filter: models.ReprocessFilter{CreatedAfter: &earlier, CreatedBefore: &later},
+			want:   bson.M{"is_active": true, "created_at": bson.M{"$gte": earlier, "$lt": later}},
+		},
+		{
+			name:   "missing rendition",
+			filter: models.ReprocessFilter{MissingRendition: "videos.hls"},
+			want: bson.M{
+				"is_active": true,
+				"videos": bson.M{
+					"$elemMatch": bson.M{"k": bson.M{"$regex": "^original"}},
+					"$not":       bson.M{"$elemMatch": bson.M{"k": "hls"}},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := reprocessQuery(tt.filter); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("reprocessQuery() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Routes whose bodies are streamed to storage instead of being read into memory
+var streamedUploadRoutes = []struct {
+	method  string
+	pattern *regexp.Regexp
+}{
+	{http.MethodPut, regexp.MustCompile(`^/storage/local/.+`)},
+	{http.MethodPatch, regexp.MustCompile(`^/mr-content/[^/]+/tus/[^/]+/?$`)},
+}
+
+// RequestBodyLimit is the largest body read into memory, in bytes (REQUEST_BODY_LIMIT_MB)
+func RequestBodyLimit() int {
+	return getEnvInt("REQUEST_BODY_LIMIT_MB", 4) << 20
+}
+
+// LimitRequestBody enforces the body limit on every route except streamed uploads. The app
+// streams request bodies so multi-GB uploads never sit in memory, and with streaming on,
+// fasthttp hands oversized bodies over as a stream instead of rejecting them.
+func LimitRequestBody(limit int) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if isStreamedUpload(c) {
+			// A handler that rejects an upload leaves its body unread
+			err := c.Next()
+			if err != nil || c.Response().StatusCode() >= http.StatusBadRequest {
+				c.Context().SetConnectionClose()
+			}
+			return err
+		}
+
+		request := c.Request()
+		if request.Header.ContentLength() > limit {
+			return rejectOversizedBody(c, "Request body too large")
+		}
+
+		// Chunked bodies have no length up front; read at most one byte past the limit
+		if request.IsBodyStream() {
+			body, err := io.ReadAll(io.LimitReader(request.BodyStream(), int64(limit)+1))
+			if err != nil {
+				return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Failed to read request body"})
+			}
+			if len(body) > limit {
+				return rejectOversizedBody(c, "Request body too large")
+			}
+			request.SetBody(body)
+		}
+
+		return c.Next()
+	}
+}
+
+// rejectOversizedBody answers 413 and closes the connection, since the unread rest of a streamed
+// body would otherwise be parsed as the next request on it
+func rejectOversizedBody(c *fiber.Ctx, message string) error {
+	c.Context().SetConnectionClose()
+	return c.Status(http.StatusRequestEntityTooLarge).JSON(fiber.Map{"error": message})
+}
+
+// isStreamedUpload reports whether a request goes to a route that streams its body
+func isStreamedUpload(c *fiber.Ctx) bool {
+	path := strings.ToLower(c.Path())
+	for _, route := range streamedUploadRoutes {
+		if c.Method() == route.method && route.pattern.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
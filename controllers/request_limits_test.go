@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestLimitRequestBody(t *testing.T) {
+	app := fiber.New(fiber.Config{StreamRequestBody: true, BodyLimit: 16})
+	app.Use(LimitRequestBody(16))
+	app.All("/*", func(c *fiber.Ctx) error {
+		return c.SendString(string(c.Body()))
+	})
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		chunked    bool
+		wantStatus int
+	}{
+		{"within limit", http.MethodPost, "/mr-content", "small", false, http.StatusOK},
+		{"over limit", http.MethodPost, "/mr-content", strings.Repeat("x", 64), false, http.StatusRequestEntityTooLarge},
+		{"chunked over limit", http.MethodPost, "/mr-content", strings.Repeat("x", 64), true, http.StatusRequestEntityTooLarge},
+		{"local upload is streamed", http.MethodPut, "/storage/local/org/a.mp4", strings.Repeat("x", 64), false, http.StatusOK},
+		{"tus chunk is streamed", http.MethodPatch, "/mr-content/abc/tus/def", strings.Repeat("x", 64), false, http.StatusOK},
+		{"other methods on upload routes are limited", http.MethodPost, "/storage/local/org/a.mp4", strings.Repeat("x", 64), false, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.chunked {
+				req.ContentLength = -1
+				req.TransferEncoding = []string{"chunked"}
+			}
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
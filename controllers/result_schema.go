@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/praleedsuvarna/shared-libs/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Result schema versions. v1 results carry a single processed_url plus hls_url/dash_url;
+// v2 results carry every output with its metadata in "outputs".
+const (
+	ResultSchemaV1 = 1
+	ResultSchemaV2 = 2
+)
+
+// ErrUnsupportedResultSchema is returned for results of a schema version we don't know
+var ErrUnsupportedResultSchema = errors.New("unsupported result schema_version")
+
+// normalizeResult brings v1 and v2 results to the same shape: a v2 result gets the v1 fields
+// the media arrays are updated from, a v1 result gets its outputs listed. Either way every
+// output is stamped with the media, original and task it belongs to.
+func normalizeResult(result *MediaProcessResult) error {
+	switch {
+	case result.SchemaVersion == ResultSchemaV2 || (result.SchemaVersion == 0 && len(result.Outputs) > 0):
+		result.SchemaVersion = ResultSchemaV2
+		if err := fillLegacyFields(result); err != nil {
+			return err
+		}
+	case result.SchemaVersion <= ResultSchemaV1:
+		result.SchemaVersion = ResultSchemaV1
+		result.Outputs = legacyOutputs(*result)
+	default:
+		return fmt.Errorf("%w: %d", ErrUnsupportedResultSchema, result.SchemaVersion)
+	}
+
+	now := time.Now()
+	for i := range result.Outputs {
+		result.Outputs[i].MediaType = result.MediaType
+		result.Outputs[i].OriginalURL = result.OriginalURL
+		result.Outputs[i].TaskID = result.TaskID
+		result.Outputs[i].CreatedAt = now
+	}
+	return nil
+}
+
+// fillLegacyFields derives processed_url, hls_url, dash_url and thumbnails from the outputs
+// of a v2 result. Ladder rungs are only kept in the rendition list.
+func fillLegacyFields(result *MediaProcessResult) error {
+	for i, output := range result.Outputs {
+		if output.URL == "" {
+			return fmt.Errorf("output %d of %s result has no url", i, result.ProcessingType)
+		}
+		if output.Type == "" {
+			result.Outputs[i].Type = result.ProcessingType
+			output.Type = result.ProcessingType
+		}
+		if output.Role == "variant" {
+			continue
+		}
+
+		switch {
+		case output.Type == "hls" && result.HlsURL == "":
+			result.HlsURL = output.URL
+		case output.Type == "dash" && result.DashURL == "":
+			result.DashURL = output.URL
+		case output.Type == "thumbnail" && output.Label != "" && result.ProcessingType == "thumbnail":
+			if result.Thumbnails == nil {
+				result.Thumbnails = map[string]string{}
+			}
+			result.Thumbnails[output.Label] = output.URL
+		}
+
+		// The first output of the requested type is the one the media arrays point to
+		if output.Type == result.ProcessingType && result.ProcessedURL == "" {
+			result.ProcessedURL = output.URL
+		}
+	}
+	return nil
+}
+
+// legacyOutputs lists the outputs of a v1 result, without metadata
+func legacyOutputs(result MediaProcessResult) []models.Rendition {
+	var outputs []models.Rendition
+
+	// HLS results may repeat the manifest as processed_url
+	if result.ProcessedURL != "" && !(result.ProcessingType == "hls" && result.HlsURL != "") {
+		outputType := result.ProcessingType
+		if outputType == "" {
+			outputType = "processed"
+			if result.MediaType == "image" {
+				outputType = "compressed"
+			}
+		}
+		outputs = append(outputs, models.Rendition{Type: outputType, URL: result.ProcessedURL})
+	}
+	if result.HlsURL != "" {
+		outputs = append(outputs, models.Rendition{Type: "hls", Role: "master", URL: result.HlsURL})
+	}
+	if result.DashURL != "" {
+		outputs = append(outputs, models.Rendition{Type: "dash", Role: "master", URL: result.DashURL})
+	}
+	for size, url := range result.Thumbnails {
+		if url != "" {
+			outputs = append(outputs, models.Rendition{Type: "thumbnail", Label: size, URL: url})
+		}
+	}
+
+	return outputs
+}
+
+// storeRenditions replaces the renditions a result supersedes, those of the same media,
+// original and types, with its outputs in one update. Renditions of originals the content
+// no longer has are dropped at the same time.
+func storeRenditions(ctx context.Context, collection *mongo.Collection, content models.MRContent, result MediaProcessResult) error {
+	if len(result.Outputs) == 0 {
+		return nil
+	}
+
+	var types []string
+	for _, output := range result.Outputs {
+		if !utils.Contains(types, output.Type) {
+			types = append(types, output.Type)
+		}
+	}
+
+	originals := []string{"", result.OriginalURL}
+	for _, media := range [][]models.Media{content.Images, content.Videos, content.Objects_3D} {
+		for _, item := range media {
+			if strings.HasPrefix(item.Key, "original") {
+				originals = append(originals, item.Value)
+			}
+		}
+	}
+
+	keep := bson.M{"$filter": bson.M{
+		"input": bson.M{"$ifNull": bson.A{"$renditions", bson.A{}}},
+		"cond": bson.M{"$and": bson.A{
+			bson.M{"$in": bson.A{"$$this.original_url", originals}},
+			bson.M{"$not": bson.A{bson.M{"$and": bson.A{
+				bson.M{"$eq": bson.A{"$$this.media_type", result.MediaType}},
+				bson.M{"$eq": bson.A{"$$this.original_url", result.OriginalURL}},
+				bson.M{"$in": bson.A{"$$this.type", types}},
+			}}}},
+		}},
+	}}
+
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": content.ID}, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"renditions": bson.M{"$concatArrays": bson.A{keep, bson.M{"$literal": result.Outputs}}},
+			"updated_at": time.Now(),
+		}}},
+	})
+	return err
+}
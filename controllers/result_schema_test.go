@@ -0,0 +1,168 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// outputSummaries lists outputs as "type/role/label url", sorted since v1 thumbnails come
+// from a map
+func outputSummaries(outputs []models.Rendition) []string {
+	summaries := []string{}
+	for _, output := range outputs {
+		summaries = append(summaries, output.Type+"/"+output.Role+"/"+output.Label+" "+output.URL)
+	}
+	sort.Strings(summaries)
+	return summaries
+}
+
+func TestNormalizeResult(t *testing.T) {
+	tests := []struct {
+		name        string
+		result      MediaProcessResult
+		wantErr     bool
+		wantVersion int
+		wantOutputs []string
+		wantLegacy  MediaProcessResult // Only the v1 fields are compared
+	}{
+		{
+			name:        "v1 compressed image",
+			result:      MediaProcessResult{MediaType: "image", ProcessingType: "compressed", ProcessedURL: "https://cdn.example.com/a.webp"},
+			wantVersion: ResultSchemaV1,
+			wantOutputs: []string{"compressed// https://cdn.example.com/a.webp"},
+			wantLegacy:  MediaProcessResult{ProcessedURL: "https://cdn.example.com/a.webp"},
+		},
+		{
+			name:        "v1 without processing type",
+			result:      MediaProcessResult{SchemaVersion: 1, MediaType: "video", ProcessedURL: "https://cdn.example.com/a.mp4"},
+			wantVersion: ResultSchemaV1,
+			wantOutputs: []string{"processed// https://cdn.example.com/a.mp4"},
+			wantLegacy:  MediaProcessResult{ProcessedURL: "https://cdn.example.com/a.mp4"},
+		},
+		{
+			name: "v1 hls repeating the manifest",
+			result: MediaProcessResult{
+				MediaType: "video", ProcessingType: "hls",
+				ProcessedURL: "https://cdn.example.com/a.m3u8", HlsURL: "https://cdn.example.com/a.m3u8", DashURL: "https://cdn.example.com/a.mpd",
+			},
+			wantVersion: ResultSchemaV1,
+			wantOutputs: []string{"dash/master/ https://cdn.example.com/a.mpd", "hls/master/ https://cdn.example.com/a.m3u8"},
+			wantLegacy: MediaProcessResult{
+				ProcessedURL: "https://cdn.example.com/a.m3u8", HlsURL: "https://cdn.example.com/a.m3u8", DashURL: "https://cdn.example.com/a.mpd",
+			},
+		},
+		{
+			name: "v1 thumbnails",
+			result: MediaProcessResult{
+				MediaType: "video", ProcessingType: "thumbnail",
+				Thumbnails: map[string]string{"320x180": "https://cdn.example.com/t320.jpg", "640x360": "https://cdn.example.com/t640.jpg", "64x64": ""},
+			},
+			wantVersion: ResultSchemaV1,
+			wantOutputs: []string{"thumbnail//320x180 https://cdn.example.com/t320.jpg", "thumbnail//640x360 https://cdn.example.com/t640.jpg"},
+			wantLegacy: MediaProcessResult{
+				Thumbnails: map[string]string{"320x180": "https://cdn.example.com/t320.jpg", "640x360": "https://cdn.example.com/t640.jpg", "64x64": ""},
+			},
+		},
+		{
+			name: "v2 hls with ladder",
+			result: MediaProcessResult{
+				SchemaVersion: 2, MediaType: "video", ProcessingType: "hls",
+				Outputs: []models.Rendition{
+					{Type: "hls", Role: "variant", Label: "720p", URL: "https://cdn.example.com/720.m3u8"},
+					{Type: "hls", Role: "master", URL: "https://cdn.example.com/a.m3u8"},
+					{Type: "dash", Role: "master", URL: "https://cdn.example.com/a.mpd"},
+				},
+			},
+			wantVersion: ResultSchemaV2,
+			wantOutputs: []string{
+				"dash/master/ https://cdn.example.com/a.mpd",
+				"hls/master/ https://cdn.example.com/a.m3u8",
+				"hls/variant/720p https://cdn.example.com/720.m3u8",
+			},
+			wantLegacy: MediaProcessResult{
+				ProcessedURL: "https://cdn.example.com/a.m3u8", HlsURL: "https://cdn.example.com/a.m3u8", DashURL: "https://cdn.example.com/a.mpd",
+			},
+		},
+		{
+			name: "v2 detected from outputs",
+			result: MediaProcessResult{
+				MediaType: "object_3d", ProcessingType: "glb",
+				Outputs: []models.Rendition{{URL: "https://cdn.example.com/a.glb", SizeBytes: 1024}},
+			},
+			wantVersion: ResultSchemaV2,
+			wantOutputs: []string{"glb// https://cdn.example.com/a.glb"},
+			wantLegacy:  MediaProcessResult{ProcessedURL: "https://cdn.example.com/a.glb"},
+		},
+		{
+			name: "v2 thumbnails",
+			result: MediaProcessResult{
+				SchemaVersion: 2, MediaType: "image", ProcessingType: "thumbnail",
+				Outputs: []models.Rendition{
+					{Type: "thumbnail", Label: "320x180", URL: "https://cdn.example.com/t320.jpg"},
+					{Type: "thumbnail", Label: "640x360", URL: "https://cdn.example.com/t640.jpg"},
+				},
+			},
+			wantVersion: ResultSchemaV2,
+			wantOutputs: []string{"thumbnail//320x180 https://cdn.example.com/t320.jpg", "thumbnail//640x360 https://cdn.example.com/t640.jpg"},
+			wantLegacy: MediaProcessResult{
+				ProcessedURL: "https://cdn.example.com/t320.jpg",
+				Thumbnails:   map[string]string{"320x180": "https://cdn.example.com/t320.jpg", "640x360": "https://cdn.example.com/t640.jpg"},
+			},
+		},
+		{
+			name: "v2 output without url",
+			result: MediaProcessResult{
+				SchemaVersion: 2, MediaType: "video", ProcessingType: "compressed",
+				Outputs: []models.Rendition{{Type: "compressed"}},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "unknown schema version",
+			result:  MediaProcessResult{SchemaVersion: 3, ProcessedURL: "https://cdn.example.com/a.webp"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := tt.result
+			result.OriginalURL = "https://cdn.example.com/original"
+			result.TaskID = "65f000000000000000000003"
+
+			err := normalizeResult(&result)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("normalizeResult() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if tt.result.SchemaVersion > ResultSchemaV2 && !errors.Is(err, ErrUnsupportedResultSchema) {
+					t.Errorf("normalizeResult() error = %v, want %v", err, ErrUnsupportedResultSchema)
+				}
+				return
+			}
+
+			if result.SchemaVersion != tt.wantVersion {
+				t.Errorf("schema version = %d, want %d", result.SchemaVersion, tt.wantVersion)
+			}
+			if got := outputSummaries(result.Outputs); !reflect.DeepEqual(got, tt.wantOutputs) {
+				t.Errorf("outputs = %v, want %v", got, tt.wantOutputs)
+			}
+			if result.ProcessedURL != tt.wantLegacy.ProcessedURL || result.HlsURL != tt.wantLegacy.HlsURL ||
+				result.DashURL != tt.wantLegacy.DashURL || !reflect.DeepEqual(result.Thumbnails, tt.wantLegacy.Thumbnails) {
+				t.Errorf("legacy fields = %q %q %q %v, want %q %q %q %v",
+					result.ProcessedURL, result.HlsURL, result.DashURL, result.Thumbnails,
+					tt.wantLegacy.ProcessedURL, tt.wantLegacy.HlsURL, tt.wantLegacy.DashURL, tt.wantLegacy.Thumbnails)
+			}
+
+			for _, output := range result.Outputs {
+				if output.MediaType != result.MediaType || output.OriginalURL != result.OriginalURL ||
+					output.TaskID != result.TaskID || output.CreatedAt.IsZero() {
+					t.Errorf("output %s/%s is not stamped with its media, original and task: %+v", output.Type, output.Label, output)
+				}
+			}
+		})
+	}
+}
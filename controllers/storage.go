@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/praleedsuvarna/shared-libs/config"
+)
+
+// ErrObjectNotFound is returned by Stat when nothing was uploaded under the key
+var ErrObjectNotFound = errors.New("object not found")
+
+// PresignedUpload tells a client how to upload an object directly to storage
+type PresignedUpload struct {
+	Method    string            `json:"method"`
+	URL       string            `json:"url"`
+	Headers   map[string]string `json:"headers"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Size        int64
+	ContentType string
+}
+
+// StorageBackend stores original media uploaded directly by clients
+type StorageBackend interface {
+	// Name identifies the backend in upload records
+	Name() string
+	// PresignPut returns a time-limited URL the client can PUT the object to
+	PresignPut(ctx context.Context, key, contentType string, expires time.Duration) (PresignedUpload, error)
+	// Put stores an object assembled by this service, such as a finished resumable upload
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	// Stat returns the stored object's size and type, or ErrObjectNotFound
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// PublicURL returns the URL the processor and players fetch the object from
+	PublicURL(key string) string
+}
+
+// Global storage backend, nil until InitStorage is called
+var storageBackend StorageBackend
+
+// InitStorage sets up the backend selected by STORAGE_BACKEND ("local" or "s3")
+func InitStorage() (StorageBackend, error) {
+	var backend StorageBackend
+	var err error
+
+	switch config.GetEnv("STORAGE_BACKEND", "local") {
+	case "local":
+		backend, err = NewLocalStorage()
+	case "s3":
+		backend, err = NewS3Storage()
+	default:
+		err = fmt.Errorf("unknown STORAGE_BACKEND %q", config.GetEnv("STORAGE_BACKEND", ""))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	storageBackend = backend
+	log.Printf("Storage backend initialized: %s", backend.Name())
+	return backend, nil
+}
+
+// GetStorage returns the configured storage backend
+func GetStorage() StorageBackend {
+	return storageBackend
+}
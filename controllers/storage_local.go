@@ -0,0 +1,258 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/praleedsuvarna/shared-libs/config"
+)
+
+// LocalStorage keeps objects on the local filesystem and accepts uploads through
+// signed PUT requests to this service. Meant for development and single-node setups.
+type LocalStorage struct {
+	dir     string
+	baseURL string
+	secret  []byte
+}
+
+// NewLocalStorage configures local storage from STORAGE_LOCAL_DIR and STORAGE_LOCAL_BASE_URL
+func NewLocalStorage() (*LocalStorage, error) {
+	dir := config.GetEnv("STORAGE_LOCAL_DIR", "./uploads")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating storage directory %s: %w", dir, err)
+	}
+
+	baseURL := config.GetEnv("STORAGE_LOCAL_BASE_URL", config.GetEnv("PUBLIC_BASE_URL", "http://localhost:"+config.GetEnv("PORT", "8080")))
+
+	secret := config.GetEnv("STORAGE_LOCAL_SECRET", config.GetEnv("JWT_SECRET", ""))
+	if secret == "" {
+		return nil, fmt.Errorf("STORAGE_LOCAL_SECRET or JWT_SECRET is required to sign local uploads")
+	}
+
+	return &LocalStorage{
+		dir:     dir,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		secret:  []byte(secret),
+	}, nil
+}
+
+func (s *LocalStorage) Name() string {
+	return "local"
+}
+
+func (s *LocalStorage) PresignPut(ctx context.Context, key, contentType string, expires time.Duration) (PresignedUpload, error) {
+	expiresAt := time.Now().Add(expires)
+	expiresUnix := strconv.FormatInt(expiresAt.Unix(), 10)
+
+	query := url.Values{}
+	query.Set("expires", expiresUnix)
+	query.Set("signature", s.signature(key, contentType, expiresUnix))
+
+	return PresignedUpload{
+		Method:    http.MethodPut,
+		URL:       s.baseURL + "/storage/local/" + key + "?" + query.Encode(),
+		Headers:   map[string]string{"Content-Type": contentType},
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+func (s *LocalStorage) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	filePath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(filePath, body)
+}
+
+func (s *LocalStorage) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	filePath, err := s.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	info, err := os.Stat(filePath)
+	if os.IsNotExist(err) {
+		return ObjectInfo{}, ErrObjectNotFound
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	return ObjectInfo{Size: info.Size(), ContentType: contentType}, nil
+}
+
+// PublicURL returns a signed download URL. Object keys are predictable, so files are only
+// served with the signature of their key.
+func (s *LocalStorage) PublicURL(key string) string {
+	return s.baseURL + "/storage/files/" + key + "?signature=" + s.downloadSignature(key)
+}
+
+// signature authenticates an upload URL for one key, content type and expiry
+func (s *LocalStorage) signature(key, contentType, expires string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(http.MethodPut + "\n" + key + "\n" + contentType + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// downloadSignature authenticates a download URL for one key
+func (s *LocalStorage) downloadSignature(key string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(http.MethodGet + "\n" + key))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// path maps an object key to a file inside the storage directory
+func (s *LocalStorage) path(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" || cleaned[1:] != key {
+		return "", fmt.Errorf("invalid object key")
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(cleaned)), nil
+}
+
+// HandleLocalStorageUpload receives a PUT to a presigned local storage URL
+func HandleLocalStorageUpload(c *fiber.Ctx) error {
+	local, ok := storageBackend.(*LocalStorage)
+	if !ok {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{"error": "Local storage is not enabled"})
+	}
+
+	key := c.Params("*")
+	expires := c.Query("expires")
+	contentType := c.Get(fiber.HeaderContentType)
+
+	expiresUnix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expiresUnix {
+		return c.Status(http.StatusForbidden).JSON(fiber.Map{"error": "Upload URL has expired"})
+	}
+
+	expected := local.signature(key, contentType, expires)
+	if !hmac.Equal([]byte(expected), []byte(c.Query("signature"))) {
+		return c.Status(http.StatusForbidden).JSON(fiber.Map{"error": "Invalid upload signature"})
+	}
+
+	filePath, err := local.path(key)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	limit := maxUploadSize()
+	if int64(c.Request().Header.ContentLength()) > limit {
+		return rejectOversizedBody(c, fmt.Sprintf("Uploads are limited to %d MB", limit>>20))
+	}
+
+	var body io.Reader = c.Context().RequestBodyStream()
+	if body == nil {
+		body = bytes.NewReader(c.Body())
+	}
+
+	// Chunked uploads have no length up front, so the limit is also enforced while copying
+	if err := writeFileAtomically(filePath, &cappedReader{reader: body, remaining: limit}); err != nil {
+		if errors.Is(err, errUploadTooLarge) {
+			return rejectOversizedBody(c, fmt.Sprintf("Uploads are limited to %d MB", limit>>20))
+		}
+		log.Printf("Error storing local upload %s: %v", key, err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to store upload"})
+	}
+
+	return c.SendStatus(http.StatusOK)
+}
+
+// HandleLocalStorageDownload serves a file of the local storage backend to requests
+// carrying the signature of its key
+func HandleLocalStorageDownload(c *fiber.Ctx) error {
+	local, ok := storageBackend.(*LocalStorage)
+	if !ok {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{"error": "Local storage is not enabled"})
+	}
+
+	key := c.Params("*")
+	expected := local.downloadSignature(key)
+	if !hmac.Equal([]byte(expected), []byte(c.Query("signature"))) {
+		return c.Status(http.StatusForbidden).JSON(fiber.Map{"error": "Invalid download signature"})
+	}
+
+	filePath, err := local.path(key)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.SendFile(filePath)
+}
+
+// errUploadTooLarge is returned while reading an upload past its size limit
+var errUploadTooLarge = errors.New("upload exceeds the size limit")
+
+// cappedReader fails once more than remaining bytes are read, so a partial file is never kept
+type cappedReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (r *cappedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return n, errUploadTooLarge
+	}
+	return n, err
+}
+
+// maxUploadSize is the largest original any media type may upload, in bytes
+func maxUploadSize() int64 {
+	limit := maxMediaSize("image")
+	for _, mediaType := range []string{"video", "object_3d"} {
+		if size := maxMediaSize(mediaType); size > limit {
+			limit = size
+		}
+	}
+	return limit
+}
+
+// writeFileAtomically writes to a temporary file first so a broken write never looks complete
+func writeFileAtomically(filePath string, body io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filePath)
+}
@@ -0,0 +1,423 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/praleedsuvarna/shared-libs/config"
+)
+
+// SHA-256 of an empty request body, used when signing HEAD requests
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Multipart upload bounds S3 enforces: parts other than the last are at least 5 MB,
+// and an upload has at most 10,000 parts
+const (
+	s3MinPartSize = 5 << 20
+	s3MaxParts    = 10000
+)
+
+// Part list of a CompleteMultipartUpload request
+type s3CompleteMultipartUpload struct {
+	XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+	Parts   []s3CompletedPart `xml:"Part"`
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// S3Storage stores objects in an S3-compatible bucket (AWS S3, MinIO, ...).
+// Requests are signed with AWS Signature Version 4.
+type S3Storage struct {
+	endpoint      *url.URL
+	region        string
+	bucket        string
+	accessKey     string
+	secretKey     string
+	pathStyle     bool
+	publicBaseURL string
+	partSize      int64
+	client        *http.Client // Metadata requests; object transfers use s3TransferClient
+}
+
+// s3TransferClient uploads object data. It has no overall timeout, since a part of a large
+// upload can take minutes on a slow link; the caller's ctx bounds the transfer and the
+// transport gives up on storage that doesn't connect or answer.
+var s3TransferClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: time.Minute,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   16,
+	},
+}
+
+// NewS3Storage configures S3 storage from the S3_* environment variables
+func NewS3Storage() (*S3Storage, error) {
+	region := config.GetEnv("S3_REGION", "us-east-1")
+	bucket := config.GetEnv("S3_BUCKET", "")
+	accessKey := config.GetEnv("S3_ACCESS_KEY_ID", "")
+	secretKey := config.GetEnv("S3_SECRET_ACCESS_KEY", "")
+
+	if bucket == "" || accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY are required for s3 storage")
+	}
+
+	endpoint, err := url.Parse(config.GetEnv("S3_ENDPOINT", "https://s3."+region+".amazonaws.com"))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3_ENDPOINT")
+	}
+
+	return &S3Storage{
+		endpoint:      endpoint,
+		region:        region,
+		bucket:        bucket,
+		accessKey:     accessKey,
+		secretKey:     secretKey,
+		pathStyle:     config.GetEnv("S3_FORCE_PATH_STYLE", "false") == "true",
+		publicBaseURL: strings.TrimRight(config.GetEnv("S3_PUBLIC_BASE_URL", ""), "/"),
+		partSize:      int64(getEnvInt("S3_PART_SIZE_MB", 64)) << 20,
+		client:        &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (s *S3Storage) Name() string {
+	return "s3"
+}
+
+func (s *S3Storage) PresignPut(ctx context.Context, key, contentType string, expires time.Duration) (PresignedUpload, error) {
+	now := time.Now().UTC()
+	host, canonicalURI := s.location(key)
+	amzDate := now.Format("20060102T150405Z")
+
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.accessKey+"/"+s.scope(now))
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "content-type;host")
+
+	headers := map[string]string{"content-type": contentType, "host": host}
+	signature := s.signature(http.MethodPut, canonicalURI, canonicalQuery(query), headers, "UNSIGNED-PAYLOAD", now)
+
+	return PresignedUpload{
+		Method:    http.MethodPut,
+		URL:       s.endpoint.Scheme + "://" + host + canonicalURI + "?" + canonicalQuery(query) + "&X-Amz-Signature=" + signature,
+		Headers:   map[string]string{"Content-Type": contentType},
+		ExpiresAt: now.Add(expires),
+	}, nil
+}
+
+// Put uploads an object with a single signed PUT, or in parts when it's larger than one part.
+// S3 accepts single PUTs up to 5 GB.
+func (s *S3Storage) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	partSize := s.partSizeFor(size)
+	if size <= partSize {
+		resp, err := s.send(ctx, http.MethodPut, key, nil, contentType, body, size)
+		if err != nil {
+			return err
+		}
+		return checkS3Response(resp)
+	}
+	return s.putMultipart(ctx, key, body, size, partSize, contentType)
+}
+
+// putMultipart uploads an object part by part, aborting the upload if a part fails so S3
+// doesn't keep the parts around
+func (s *S3Storage) putMultipart(ctx context.Context, key string, body io.Reader, size, partSize int64, contentType string) error {
+	resp, err := s.send(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, contentType, nil, 0)
+	if err != nil {
+		return err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("storage responded with HTTP %d", resp.StatusCode)
+	}
+	if err != nil || initiated.UploadID == "" {
+		return fmt.Errorf("storage returned no multipart upload ID")
+	}
+
+	completion := s3CompleteMultipartUpload{}
+	for offset, number := int64(0), 1; offset < size; number++ {
+		length := min(partSize, size-offset)
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {initiated.UploadID}}
+
+		resp, err := s.send(ctx, http.MethodPut, key, query, "", io.LimitReader(body, length), length)
+		if err == nil {
+			err = checkS3Response(resp)
+		}
+		if err != nil {
+			s.abortMultipart(key, initiated.UploadID)
+			return fmt.Errorf("part %d: %w", number, err)
+		}
+
+		completion.Parts = append(completion.Parts, s3CompletedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+		offset += length
+	}
+
+	payload, err := xml.Marshal(completion)
+	if err != nil {
+		s.abortMultipart(key, initiated.UploadID)
+		return err
+	}
+	resp, err = s.send(ctx, http.MethodPost, key, url.Values{"uploadId": {initiated.UploadID}}, "application/xml",
+		bytes.NewReader(payload), int64(len(payload)))
+	if err != nil {
+		s.abortMultipart(key, initiated.UploadID)
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Completion can fail after S3 has answered 200, with the error in the body
+	result, err := io.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode >= 300 || bytes.Contains(result, []byte("<Error>")) {
+		s.abortMultipart(key, initiated.UploadID)
+		return fmt.Errorf("storage failed to complete the multipart upload (HTTP %d)", resp.StatusCode)
+	}
+	return nil
+}
+
+// abortMultipart discards the uploaded parts; it runs after ctx may have been cancelled
+func (s *S3Storage) abortMultipart(key, uploadID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	resp, err := s.send(ctx, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, "", nil, 0)
+	if err == nil {
+		err = checkS3Response(resp)
+	}
+	if err != nil {
+		log.Printf("Error aborting multipart upload of %s: %v", key, err)
+	}
+}
+
+// partSizeFor returns the part size for an object, growing it so no upload needs more parts
+// than S3 allows
+func (s *S3Storage) partSizeFor(size int64) int64 {
+	partSize := max(s.partSize, s3MinPartSize)
+	if parts := (size + partSize - 1) / partSize; parts > s3MaxParts {
+		partSize = (size + s3MaxParts - 1) / s3MaxParts
+	}
+	return partSize
+}
+
+// send makes a header-signed request for an object with an unsigned payload
+func (s *S3Storage) send(ctx context.Context, method, key string, query url.Values, contentType string, body io.Reader, size int64) (*http.Response, error) {
+	now := time.Now().UTC()
+	host, canonicalURI := s.location(key)
+	amzDate := now.Format("20060102T150405Z")
+
+	headers := map[string]string{
+		"host":                 host,
+		"x-amz-content-sha256": "UNSIGNED-PAYLOAD",
+		"x-amz-date":           amzDate,
+	}
+	if contentType != "" {
+		headers["content-type"] = contentType
+	}
+	signature := s.signature(method, canonicalURI, canonicalQuery(query), headers, "UNSIGNED-PAYLOAD", now)
+
+	target := s.endpoint.Scheme + "://" + host + canonicalURI
+	if len(query) > 0 {
+		target += "?" + canonicalQuery(query)
+	}
+
+	// A zero length with a body would be sent chunked, which S3 rejects
+	if size == 0 {
+		body = http.NoBody
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, s.scope(now), signedHeaderNames(headers), signature))
+
+	return s3TransferClient.Do(req)
+}
+
+// checkS3Response closes the response and turns an error status into an error
+func checkS3Response(resp *http.Response) error {
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("storage responded with HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *S3Storage) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	now := time.Now().UTC()
+	host, canonicalURI := s.location(key)
+	amzDate := now.Format("20060102T150405Z")
+
+	headers := map[string]string{
+		"host":                 host,
+		"x-amz-content-sha256": emptyPayloadHash,
+		"x-amz-date":           amzDate,
+	}
+	signature := s.signature(http.MethodHead, canonicalURI, "", headers, emptyPayloadHash, now)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.endpoint.Scheme+"://"+host+canonicalURI, nil)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, s.scope(now), signedHeaderNames(headers), signature))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return ObjectInfo{}, ErrObjectNotFound
+	case resp.StatusCode >= 300:
+		return ObjectInfo{}, fmt.Errorf("storage responded with HTTP %d", resp.StatusCode)
+	}
+
+	return ObjectInfo{Size: resp.ContentLength, ContentType: resp.Header.Get("Content-Type")}, nil
+}
+
+func (s *S3Storage) PublicURL(key string) string {
+	if s.publicBaseURL != "" {
+		return s.publicBaseURL + "/" + escapeS3Path(key)
+	}
+	host, canonicalURI := s.location(key)
+	return s.endpoint.Scheme + "://" + host + canonicalURI
+}
+
+// location returns the host and escaped path of an object for the addressing style in use
+func (s *S3Storage) location(key string) (string, string) {
+	if s.pathStyle {
+		return s.endpoint.Host, "/" + s.bucket + "/" + escapeS3Path(key)
+	}
+	return s.bucket + "." + s.endpoint.Host, "/" + escapeS3Path(key)
+}
+
+func (s *S3Storage) scope(t time.Time) string {
+	return t.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+}
+
+// signature computes the SigV4 signature of a request from its canonical parts
+func (s *S3Storage) signature(method, canonicalURI, query string, headers map[string]string, payloadHash string, t time.Time) string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		canonicalURI,
+		query,
+		canonicalHeaders.String(),
+		signedHeaderNames(headers),
+		payloadHash,
+	}, "\n")
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		t.Format("20060102T150405Z"),
+		s.scope(t),
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), t.Format("20060102"))
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func signedHeaderNames(headers map[string]string) string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ";")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery encodes query parameters sorted by name, as SigV4 requires
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, escapeS3(name)+"="+escapeS3(query.Get(name)))
+	}
+	return strings.Join(parts, "&")
+}
+
+// escapeS3Path escapes every segment of an object key
+func escapeS3Path(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = escapeS3(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// escapeS3 percent-encodes everything except RFC 3986 unreserved characters
+func escapeS3(value string) string {
+	var builder strings.Builder
+	for i := 0; i < len(value); i++ {
+		ch := value[i]
+		if (ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z') || (ch >= '0' && ch <= '9') ||
+			ch == '-' || ch == '_' || ch == '.' || ch == '~' {
+			builder.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&builder, "%%%02X", ch)
+		}
+	}
+	return builder.String()
+}
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// TusChunkStore holds the bytes of resumable uploads until they are complete
+type TusChunkStore interface {
+	// WriteAt writes a chunk at offset and returns how many bytes were stored,
+	// including when the body breaks off early
+	WriteAt(id string, offset int64, body io.Reader) (int64, error)
+	// Truncate drops everything after size, used to discard a chunk that failed verification
+	Truncate(id string, size int64) error
+	// Open reads back the stored bytes
+	Open(id string) (io.ReadCloser, error)
+	// Remove deletes the stored bytes
+	Remove(id string) error
+}
+
+// fileChunkStore keeps chunks in one file per upload. With several replicas the
+// directory has to be on shared storage.
+type fileChunkStore struct {
+	dir string
+}
+
+func newFileChunkStore(dir string) (*fileChunkStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating tus upload directory %s: %w", dir, err)
+	}
+	return &fileChunkStore{dir: dir}, nil
+}
+
+func (s *fileChunkStore) path(id string) string {
+	return filepath.Join(s.dir, filepath.Base(id)+".bin")
+}
+
+func (s *fileChunkStore) WriteAt(id string, offset int64, body io.Reader) (int64, error) {
+	file, err := os.OpenFile(s.path(id), os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	written, err := io.Copy(file, body)
+	if syncErr := file.Sync(); err == nil {
+		err = syncErr
+	}
+	return written, err
+}
+
+func (s *fileChunkStore) Truncate(id string, size int64) error {
+	return os.Truncate(s.path(id), size)
+}
+
+func (s *fileChunkStore) Open(id string) (io.ReadCloser, error) {
+	return os.Open(s.path(id))
+}
+
+func (s *fileChunkStore) Remove(id string) error {
+	err := os.Remove(s.path(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,793 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/praleedsuvarna/shared-libs/config"
+	"github.com/praleedsuvarna/shared-libs/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	tusUploadsCollection = "oms_tus_uploads"
+	tusVersion           = "1.0.0"
+	tusExtensions        = "creation,creation-with-upload,expiration,checksum,concatenation,termination"
+	tusChecksums         = "sha1,sha256,md5"
+
+	// Status code tus defines for a failed checksum
+	statusChecksumMismatch = 460
+)
+
+// How long a PATCH holds an upload; a crashed request releases it after this
+const tusLockLease = 10 * time.Minute
+
+// TusUploads serves resumable uploads and removes the ones that expire
+type TusUploads struct {
+	store  TusChunkStore
+	expiry time.Duration
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Global instance, nil until InitTusUploads is called
+var tusUploads *TusUploads
+
+// InitTusUploads sets up the chunk store and starts the expiry sweeper
+func InitTusUploads() (*TusUploads, error) {
+	store, err := newFileChunkStore(config.GetEnv("TUS_UPLOAD_DIR", "./tus-uploads"))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	uploads := &TusUploads{
+		store:  store,
+		expiry: time.Duration(getEnvInt("TUS_UPLOAD_EXPIRY_HOURS", 24)) * time.Hour,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	go uploads.sweep(ctx)
+
+	tusUploads = uploads
+	log.Printf("Resumable uploads enabled (expiry: %s)", uploads.expiry)
+	return uploads, nil
+}
+
+// StopTusUploads stops the expiry sweeper
+func StopTusUploads() {
+	if tusUploads != nil {
+		tusUploads.cancel()
+		<-tusUploads.done
+		tusUploads = nil
+		log.Println("Resumable upload sweeper stopped")
+	}
+}
+
+// TusOptions advertises the supported tus version and extensions
+func TusOptions(c *fiber.Ctx) error {
+	setTusHeaders(c)
+	c.Set("Tus-Version", tusVersion)
+	c.Set("Tus-Extension", tusExtensions)
+	c.Set("Tus-Checksum-Algorithm", tusChecksums)
+	c.Set("Tus-Max-Size", strconv.FormatInt(maxTusUploadSize(), 10))
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// CreateTusUpload starts a resumable upload. Upload-Metadata may carry filename, filetype,
+// media_type (default "video") and role (default "original").
+func CreateTusUpload(c *fiber.Ctx) error {
+	if !checkTusRequest(c) {
+		return nil
+	}
+
+	userID := c.Locals("user_id").(string)
+	objUserID, _ := primitive.ObjectIDFromHex(userID)
+
+	content, status, err := findOrganizationContent(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	metadata := parseTusMetadata(c.Get("Upload-Metadata"))
+	now := time.Now()
+	upload := models.TusUpload{
+		ID:             primitive.NewObjectID(),
+		ContentID:      content.ID,
+		OrganizationID: content.OrganizationID,
+		UserID:         objUserID,
+		MediaType:      metadata["media_type"],
+		Role:           metadata["role"],
+		Filename:       metadata["filename"],
+		ContentType:    strings.ToLower(metadata["filetype"]),
+		Metadata:       c.Get("Upload-Metadata"),
+		Status:         "uploading",
+		ExpiresAt:      now.Add(tusUploads.expiry),
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if upload.MediaType == "" {
+		upload.MediaType = "video"
+	}
+	if upload.Role == "" {
+		upload.Role = "original"
+	}
+
+	if _, ok := mediaFields[upload.MediaType]; !ok {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "media_type must be one of image, video, object_3d"})
+	}
+	if !mediaRolePattern.MatchString(upload.Role) {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "role must start with \"original\""})
+	}
+	if !contentTypeMatches(upload.ContentType, upload.MediaType) {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": fmt.Sprintf("filetype %q is not valid for %s media", upload.ContentType, upload.MediaType)})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	concat := c.Get("Upload-Concat")
+	switch {
+	case strings.HasPrefix(concat, "final;"):
+		partials, status, err := loadTusPartials(ctx, content.ID, strings.TrimPrefix(concat, "final;"))
+		if err != nil {
+			return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+		}
+		for _, partial := range partials {
+			upload.PartialIDs = append(upload.PartialIDs, partial.ID)
+			upload.Length += partial.Length
+		}
+		upload.Offset = upload.Length
+
+	default:
+		upload.IsPartial = concat == "partial"
+		upload.Length, err = strconv.ParseInt(c.Get("Upload-Length"), 10, 64)
+		if err != nil || upload.Length < 0 {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "A valid Upload-Length header is required"})
+		}
+	}
+
+	if limit := maxTusUploadSize(); upload.Length > limit {
+		return c.Status(http.StatusRequestEntityTooLarge).JSON(fiber.Map{"error": fmt.Sprintf("Resumable uploads are limited to %d MB", limit>>20)})
+	}
+
+	if _, err := config.GetCollection(tusUploadsCollection).InsertOne(ctx, upload); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create upload"})
+	}
+
+	utils.LogAudit(userID, "Started resumable upload", content.ID.Hex())
+
+	setTusHeaders(c)
+	c.Set(fiber.HeaderLocation, fmt.Sprintf("/mr-content/%s/tus/%s", content.ID.Hex(), upload.ID.Hex()))
+	c.Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+
+	// A final upload is complete as soon as it's created
+	if len(upload.PartialIDs) > 0 {
+		RunInBackground(func() { finalizeTusUpload(upload) })
+		return c.SendStatus(http.StatusCreated)
+	}
+
+	// So is an empty one, which no PATCH has to complete
+	if upload.Length == 0 {
+		if _, err := tusUploads.store.WriteAt(upload.ID.Hex(), 0, http.NoBody); err != nil {
+			log.Printf("Error creating empty upload %s: %v", upload.ID.Hex(), err)
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create upload"})
+		}
+		if !upload.IsPartial {
+			RunInBackground(func() { finalizeTusUpload(upload) })
+		}
+		c.Set("Upload-Offset", "0")
+		return c.SendStatus(http.StatusCreated)
+	}
+
+	// creation-with-upload: the first chunk may come with the creation request
+	if c.Get(fiber.HeaderContentType) == "application/offset+octet-stream" && c.Request().Header.ContentLength() != 0 {
+		offset, status, err := writeTusChunk(c, upload)
+		if err != nil {
+			return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+		}
+		c.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	}
+
+	return c.SendStatus(http.StatusCreated)
+}
+
+// GetTusUploadOffset reports how much of an upload the server has
+func GetTusUploadOffset(c *fiber.Ctx) error {
+	if !checkTusRequest(c) {
+		return nil
+	}
+
+	upload, status, err := findTusUpload(c)
+	if err != nil {
+		return c.SendStatus(status)
+	}
+
+	setTusHeaders(c)
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	c.Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	if upload.Metadata != "" {
+		c.Set("Upload-Metadata", upload.Metadata)
+	}
+	if upload.IsPartial {
+		c.Set("Upload-Concat", "partial")
+	} else if len(upload.PartialIDs) > 0 {
+		c.Set("Upload-Concat", "final")
+	}
+
+	return c.SendStatus(http.StatusOK)
+}
+
+// PatchTusUpload appends a chunk at Upload-Offset
+func PatchTusUpload(c *fiber.Ctx) error {
+	if !checkTusRequest(c) {
+		return nil
+	}
+
+	if c.Get(fiber.HeaderContentType) != "application/offset+octet-stream" {
+		return c.Status(http.StatusUnsupportedMediaType).JSON(fiber.Map{"error": "Content-Type must be application/offset+octet-stream"})
+	}
+
+	upload, status, err := findTusUpload(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if len(upload.PartialIDs) > 0 {
+		return c.Status(http.StatusForbidden).JSON(fiber.Map{"error": "Final uploads can't be patched"})
+	}
+
+	offset, status, err := writeTusChunk(c, upload)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	setTusHeaders(c)
+	c.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	c.Set("Upload-Expires", time.Now().Add(tusUploads.expiry).UTC().Format(http.TimeFormat))
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// DeleteTusUpload terminates an unfinished upload and frees its storage
+func DeleteTusUpload(c *fiber.Ctx) error {
+	if !checkTusRequest(c) {
+		return nil
+	}
+
+	upload, status, err := findTusUpload(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := config.GetCollection(tusUploadsCollection).DeleteOne(ctx, bson.M{"_id": upload.ID, "status": "uploading"})
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to terminate upload"})
+	}
+	if result.DeletedCount == 0 {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{"error": "Upload is already complete"})
+	}
+
+	if err := tusUploads.store.Remove(upload.ID.Hex()); err != nil {
+		log.Printf("Error removing chunks of terminated upload %s: %v", upload.ID.Hex(), err)
+	}
+
+	setTusHeaders(c)
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// writeTusChunk stores the request body at the client's offset and returns the new offset.
+// The upload is locked while writing so concurrent PATCHes of one upload can't interleave.
+func writeTusChunk(c *fiber.Ctx, upload models.TusUpload) (int64, int, error) {
+	offset, err := strconv.ParseInt(c.Get("Upload-Offset", "0"), 10, 64)
+	if err != nil {
+		return 0, http.StatusBadRequest, fmt.Errorf("Invalid Upload-Offset header")
+	}
+
+	var checksum hash.Hash
+	var expectedSum []byte
+	if header := c.Get("Upload-Checksum"); header != "" {
+		checksum, expectedSum, err = parseTusChecksum(header)
+		if err != nil {
+			return 0, http.StatusBadRequest, err
+		}
+	}
+
+	remaining := upload.Length - offset
+	if remaining < 0 {
+		return 0, http.StatusConflict, fmt.Errorf("Upload-Offset is past the end of the upload")
+	}
+	if contentLength := int64(c.Request().Header.ContentLength()); contentLength > remaining {
+		return 0, http.StatusRequestEntityTooLarge, fmt.Errorf("Chunk exceeds the declared Upload-Length")
+	}
+
+	collection := config.GetCollection(tusUploadsCollection)
+
+	// Each Mongo call gets its own timeout; the write between them can take minutes
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	lease := time.Now().Add(tusLockLease)
+	err = collection.FindOneAndUpdate(ctx,
+		bson.M{
+			"_id":    upload.ID,
+			"status": "uploading",
+			"offset": offset,
+			"$or": []bson.M{
+				{"locked_until": bson.M{"$exists": false}},
+				{"locked_until": bson.M{"$lt": time.Now()}},
+			},
+		},
+		bson.M{"$set": bson.M{"locked_until": lease}},
+	).Err()
+	cancel()
+	if err == mongo.ErrNoDocuments {
+		return 0, http.StatusConflict, fmt.Errorf("Upload-Offset doesn't match the upload, or another request is writing to it")
+	}
+	if err != nil {
+		return 0, http.StatusInternalServerError, fmt.Errorf("Failed to lock upload")
+	}
+
+	// Recording the new offset releases the lock; every other way out releases it here
+	locked := true
+	defer func() {
+		if locked {
+			unlockTusUpload(upload.ID, lease)
+		}
+	}()
+
+	var body io.Reader = c.Context().RequestBodyStream()
+	if body == nil {
+		body = bytes.NewReader(c.Body())
+	}
+	// Chunked bodies have no length up front, so the declared length is also enforced while writing
+	body = &cappedReader{reader: body, remaining: remaining}
+	if checksum != nil {
+		body = io.TeeReader(body, checksum)
+	}
+
+	written, writeErr := tusUploads.store.WriteAt(upload.ID.Hex(), offset, body)
+
+	status, resultErr := http.StatusOK, error(nil)
+	switch {
+	case errors.Is(writeErr, errUploadTooLarge):
+		if err := tusUploads.store.Truncate(upload.ID.Hex(), offset); err != nil {
+			log.Printf("Error discarding chunk of upload %s: %v", upload.ID.Hex(), err)
+		}
+		written = 0
+		status, resultErr = http.StatusRequestEntityTooLarge, fmt.Errorf("Chunk exceeds the declared Upload-Length")
+	case checksum != nil && (writeErr != nil || !bytes.Equal(checksum.Sum(nil), expectedSum)):
+		// A chunk that can't be verified is dropped entirely
+		if err := tusUploads.store.Truncate(upload.ID.Hex(), offset); err != nil {
+			log.Printf("Error discarding chunk of upload %s: %v", upload.ID.Hex(), err)
+		}
+		written = 0
+		status, resultErr = statusChecksumMismatch, fmt.Errorf("Checksum mismatch")
+	case writeErr != nil:
+		// Keep what arrived so the client can resume from there
+		log.Printf("Upload %s chunk interrupted after %d bytes: %v", upload.ID.Hex(), written, writeErr)
+		status, resultErr = http.StatusInternalServerError, fmt.Errorf("Failed to store chunk")
+	}
+
+	newOffset := offset + written
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	err = collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": upload.ID},
+		bson.M{
+			"$set":   bson.M{"offset": newOffset, "expires_at": time.Now().Add(tusUploads.expiry), "updated_at": time.Now()},
+			"$unset": bson.M{"locked_until": ""},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&upload)
+	cancel()
+	if err != nil {
+		return 0, http.StatusInternalServerError, fmt.Errorf("Failed to record upload offset")
+	}
+	locked = false
+
+	if resultErr != nil {
+		return 0, status, resultErr
+	}
+
+	if upload.Offset == upload.Length && !upload.IsPartial {
+		RunInBackground(func() { finalizeTusUpload(upload) })
+	}
+
+	return newOffset, http.StatusOK, nil
+}
+
+// unlockTusUpload releases the lock a PATCH took, unless its lease ran out and another request holds it now
+func unlockTusUpload(uploadID primitive.ObjectID, lease time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := config.GetCollection(tusUploadsCollection).UpdateOne(ctx,
+		bson.M{"_id": uploadID, "locked_until": lease},
+		bson.M{"$unset": bson.M{"locked_until": ""}},
+	)
+	if err != nil {
+		log.Printf("Error unlocking upload %s: %v", uploadID.Hex(), err)
+	}
+}
+
+// maxTusUploadSize is the largest resumable upload, in bytes (TUS_MAX_SIZE_MB). It's separate
+// from the media URL limits because resumable uploads exist for originals too large for one request.
+func maxTusUploadSize() int64 {
+	return int64(getEnvInt("TUS_MAX_SIZE_MB", 51200)) << 20
+}
+
+// tusFinalizeTimeout bounds the copy of a complete upload to storage (TUS_FINALIZE_TIMEOUT_MINUTES)
+func tusFinalizeTimeout() time.Duration {
+	return time.Duration(getEnvInt("TUS_FINALIZE_TIMEOUT_MINUTES", 60)) * time.Minute
+}
+
+// finalizeTusUpload moves a complete upload to storage and attaches it to its content. The
+// replica finalizing an upload holds a lease on it, so an upload left "finalizing" by a
+// replica that stopped mid-copy is taken over by the sweeper once the lease runs out.
+func finalizeTusUpload(upload models.TusUpload) {
+	collection := config.GetCollection(tusUploadsCollection)
+
+	now := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": upload.ID, "$or": bson.A{
+			bson.M{"status": "uploading"},
+			bson.M{"status": "finalizing", "finalize_until": bson.M{"$not": bson.M{"$gte": now}}},
+		}},
+		bson.M{"$set": bson.M{
+			"status":         "finalizing",
+			"finalize_until": now.Add(tusFinalizeTimeout() + 5*time.Minute),
+			"updated_at":     now,
+		}},
+	)
+	cancel()
+	if err != nil || result.ModifiedCount == 0 {
+		return
+	}
+
+	fail := func(reason string) {
+		log.Printf("Error finalizing resumable upload %s: %s", upload.ID.Hex(), reason)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		collection.UpdateOne(ctx,
+			bson.M{"_id": upload.ID},
+			bson.M{
+				"$set":   bson.M{"status": "failed", "error": reason, "updated_at": time.Now()},
+				"$unset": bson.M{"finalize_until": ""},
+			},
+		)
+	}
+
+	storage := GetStorage()
+	if storage == nil {
+		fail("storage backend is not configured")
+		return
+	}
+
+	// Join the partial uploads in order, or read the single upload
+	ids := []string{upload.ID.Hex()}
+	if len(upload.PartialIDs) > 0 {
+		ids = ids[:0]
+		for _, id := range upload.PartialIDs {
+			ids = append(ids, id.Hex())
+		}
+	}
+
+	readers := make([]io.Reader, 0, len(ids))
+	for _, id := range ids {
+		reader, err := tusUploads.store.Open(id)
+		if err != nil {
+			fail(err.Error())
+			return
+		}
+		defer reader.Close()
+		readers = append(readers, reader)
+	}
+
+	objectKey := fmt.Sprintf("%s/%s/%s/%s%s", upload.OrganizationID.Hex(), upload.ContentID.Hex(),
+		upload.Role, upload.ID.Hex(), strings.ToLower(path.Ext(upload.Filename)))
+
+	contentType := upload.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	putCtx, putCancel := context.WithTimeout(context.Background(), tusFinalizeTimeout())
+	err = storage.Put(putCtx, objectKey, io.MultiReader(readers...), upload.Length, contentType)
+	putCancel()
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+
+	now = time.Now()
+	mediaUpload := models.MediaUpload{
+		ID:             upload.ID,
+		ContentID:      upload.ContentID,
+		OrganizationID: upload.OrganizationID,
+		UserID:         upload.UserID,
+		MediaType:      upload.MediaType,
+		Role:           upload.Role,
+		Backend:        storage.Name(),
+		ObjectKey:      objectKey,
+		Filename:       upload.Filename,
+		ContentType:    contentType,
+		Size:           upload.Length,
+		Status:         "completed",
+		ExpiresAt:      upload.ExpiresAt,
+		CompletedAt:    &now,
+		CreatedAt:      upload.CreatedAt,
+		UpdatedAt:      now,
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := config.GetCollection(mediaUploadsCollection).InsertOne(ctx, mediaUpload); err != nil {
+		log.Printf("Error recording media upload %s: %v", upload.ID.Hex(), err)
+	}
+
+	if _, err := attachUploadedMedia(mediaUpload, storage.PublicURL(objectKey)); err != nil {
+		fail("failed to attach media: " + err.Error())
+		return
+	}
+
+	collection.UpdateOne(ctx,
+		bson.M{"_id": upload.ID},
+		bson.M{
+			"$set":   bson.M{"status": "completed", "updated_at": time.Now()},
+			"$unset": bson.M{"finalize_until": ""},
+		},
+	)
+
+	// The bytes now live in storage
+	for _, id := range ids {
+		if err := tusUploads.store.Remove(id); err != nil {
+			log.Printf("Error removing chunks of upload %s: %v", id, err)
+		}
+	}
+	if len(upload.PartialIDs) > 0 {
+		collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": upload.PartialIDs}})
+	}
+
+	log.Printf("Resumable upload %s stored as %s for content ID %s", upload.ID.Hex(), objectKey, upload.ContentID.Hex())
+}
+
+// sweep retries finalizations a stopped replica left behind and removes uploads that passed
+// their expiry without completing
+func (t *TusUploads) sweep(ctx context.Context) {
+	defer close(t.done)
+
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.retryStaleFinalizations()
+			t.removeExpired()
+		}
+	}
+}
+
+// retryStaleFinalizations finalizes again the unexpired uploads whose finalization lease ran out
+func (t *TusUploads) retryStaleFinalizations() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	cursor, err := config.GetCollection(tusUploadsCollection).Find(ctx, bson.M{
+		"status":         "finalizing",
+		"finalize_until": bson.M{"$not": bson.M{"$gte": now}},
+		"expires_at":     bson.M{"$gte": now},
+	})
+	if err != nil {
+		log.Printf("Error finding stale upload finalizations: %v", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var stale []models.TusUpload
+	if err := cursor.All(ctx, &stale); err != nil {
+		log.Printf("Error decoding stale upload finalizations: %v", err)
+		return
+	}
+
+	for _, upload := range stale {
+		log.Printf("Retrying finalization of resumable upload %s", upload.ID.Hex())
+		RunInBackground(func() { finalizeTusUpload(upload) })
+	}
+}
+
+// expiredTusUploadsFilter matches the uploads the sweeper removes: unfinished, failed and
+// abandoned finalizations past their expiry. Finalizations still holding their lease are kept.
+func expiredTusUploadsFilter(now time.Time) bson.M {
+	return bson.M{
+		"expires_at": bson.M{"$lt": now},
+		"$or": bson.A{
+			bson.M{"status": bson.M{"$in": bson.A{"uploading", "failed"}}},
+			bson.M{"status": "finalizing", "finalize_until": bson.M{"$not": bson.M{"$gte": now}}},
+		},
+	}
+}
+
+func (t *TusUploads) removeExpired() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	collection := config.GetCollection(tusUploadsCollection)
+
+	cursor, err := collection.Find(ctx, expiredTusUploadsFilter(time.Now()), options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		log.Printf("Error finding expired uploads: %v", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var expired []models.TusUpload
+	if err := cursor.All(ctx, &expired); err != nil {
+		log.Printf("Error decoding expired uploads: %v", err)
+		return
+	}
+
+	removed := 0
+	for _, upload := range expired {
+		filter := expiredTusUploadsFilter(time.Now())
+		filter["_id"] = upload.ID
+		result, err := collection.DeleteOne(ctx, filter)
+		if err != nil || result.DeletedCount == 0 {
+			continue
+		}
+		if err := t.store.Remove(upload.ID.Hex()); err != nil {
+			log.Printf("Error removing chunks of expired upload %s: %v", upload.ID.Hex(), err)
+		}
+		removed++
+	}
+
+	if removed > 0 {
+		log.Printf("Removed %d expired resumable uploads", removed)
+	}
+}
+
+// findTusUpload loads the upload in the :upload_id param for the caller's content
+func findTusUpload(c *fiber.Ctx) (models.TusUpload, int, error) {
+	var upload models.TusUpload
+
+	content, status, err := findOrganizationContent(c)
+	if err != nil {
+		return upload, status, err
+	}
+
+	uploadID, err := primitive.ObjectIDFromHex(c.Params("upload_id"))
+	if err != nil {
+		return upload, http.StatusNotFound, fmt.Errorf("Upload not found")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = config.GetCollection(tusUploadsCollection).FindOne(ctx, bson.M{"_id": uploadID, "content_id": content.ID}).Decode(&upload)
+	if err != nil {
+		return upload, http.StatusNotFound, fmt.Errorf("Upload not found")
+	}
+
+	if upload.Status == "uploading" && time.Now().After(upload.ExpiresAt) {
+		return upload, http.StatusGone, fmt.Errorf("Upload has expired")
+	}
+
+	return upload, http.StatusOK, nil
+}
+
+// loadTusPartials resolves the space separated upload URLs of a final concatenation
+func loadTusPartials(ctx context.Context, contentID primitive.ObjectID, urls string) ([]models.TusUpload, int, error) {
+	var partials []models.TusUpload
+
+	for _, partialURL := range strings.Fields(urls) {
+		partialID, err := primitive.ObjectIDFromHex(path.Base(partialURL))
+		if err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("Invalid partial upload %s", partialURL)
+		}
+
+		var partial models.TusUpload
+		err = config.GetCollection(tusUploadsCollection).FindOne(ctx, bson.M{"_id": partialID, "content_id": contentID}).Decode(&partial)
+		if err != nil || !partial.IsPartial {
+			return nil, http.StatusBadRequest, fmt.Errorf("Partial upload %s not found", partialURL)
+		}
+		if partial.Offset != partial.Length {
+			return nil, http.StatusBadRequest, fmt.Errorf("Partial upload %s is not complete", partialURL)
+		}
+
+		partials = append(partials, partial)
+	}
+
+	if len(partials) == 0 {
+		return nil, http.StatusBadRequest, fmt.Errorf("A final upload needs at least one partial upload")
+	}
+	return partials, http.StatusOK, nil
+}
+
+// checkTusRequest rejects requests for a protocol version other than 1.0.0, and all
+// requests when resumable uploads are not set up
+func checkTusRequest(c *fiber.Ctx) bool {
+	if tusUploads == nil {
+		c.Status(http.StatusServiceUnavailable).JSON(fiber.Map{"error": "Resumable uploads are not configured"})
+		return false
+	}
+	if c.Get("Tus-Resumable") != tusVersion {
+		c.Set("Tus-Version", tusVersion)
+		c.Status(http.StatusPreconditionFailed).JSON(fiber.Map{"error": "Unsupported tus version"})
+		return false
+	}
+	return true
+}
+
+func setTusHeaders(c *fiber.Ctx) {
+	c.Set("Tus-Resumable", tusVersion)
+}
+
+// parseTusMetadata decodes "key base64value,key2 base64value" pairs
+func parseTusMetadata(header string) map[string]string {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.Fields(pair)
+		if len(parts) == 0 {
+			continue
+		}
+		value := ""
+		if len(parts) > 1 {
+			if decoded, err := base64.StdEncoding.DecodeString(parts[1]); err == nil {
+				value = string(decoded)
+			}
+		}
+		metadata[parts[0]] = value
+	}
+	return metadata
+}
+
+// parseTusChecksum parses "<algorithm> <base64 digest>"
+func parseTusChecksum(header string) (hash.Hash, []byte, error) {
+	parts := strings.Fields(header)
+	if len(parts) != 2 {
+		return nil, nil, fmt.Errorf("Invalid Upload-Checksum header")
+	}
+
+	expected, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid Upload-Checksum digest")
+	}
+
+	switch parts[0] {
+	case "sha1":
+		return sha1.New(), expected, nil
+	case "sha256":
+		return sha256.New(), expected, nil
+	case "md5":
+		return md5.New(), expected, nil
+	default:
+		return nil, nil, fmt.Errorf("Unsupported checksum algorithm %s", parts[0])
+	}
+}
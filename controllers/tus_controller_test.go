@@ -0,0 +1,400 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newTusTestApp(t *testing.T) *fiber.App {
+	t.Helper()
+
+	store, err := newFileChunkStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := tusUploads
+	tusUploads = &TusUploads{store: store, expiry: time.Hour}
+	t.Cleanup(func() { tusUploads = previous })
+
+	app := fiber.New(fiber.Config{StreamRequestBody: true})
+	app.Use(LimitRequestBody(1 << 20))
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user_id", "65f000000000000000000001")
+		c.Locals("organization_id", "65f000000000000000000002")
+		return c.Next()
+	})
+	app.Options("/mr-content/:id/tus", TusOptions)
+	app.Post("/mr-content/:id/tus", CreateTusUpload)
+	app.Head("/mr-content/:id/tus/:upload_id", GetTusUploadOffset)
+	app.Patch("/mr-content/:id/tus/:upload_id", PatchTusUpload)
+	app.Delete("/mr-content/:id/tus/:upload_id", DeleteTusUpload)
+	return app
+}
+
+func TestTusHandlers(t *testing.T) {
+	const uploadPath = "/mr-content/65f000000000000000000003/tus/65f000000000000000000004"
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		headers     map[string]string
+		body        string
+		wantStatus  int
+		wantHeaders map[string]string
+		wantClose   bool
+	}{
+		{
+			name:       "options advertises the protocol",
+			method:     http.MethodOptions,
+			path:       "/mr-content/65f000000000000000000003/tus",
+			wantStatus: http.StatusNoContent,
+			wantHeaders: map[string]string{
+				"Tus-Resumable":          tusVersion,
+				"Tus-Version":            tusVersion,
+				"Tus-Extension":          tusExtensions,
+				"Tus-Checksum-Algorithm": tusChecksums,
+				"Tus-Max-Size":           "53687091200",
+			},
+		},
+		{
+			name:        "create without Tus-Resumable",
+			method:      http.MethodPost,
+			path:        "/mr-content/65f000000000000000000003/tus",
+			headers:     map[string]string{"Upload-Length": "10"},
+			wantStatus:  http.StatusPreconditionFailed,
+			wantHeaders: map[string]string{"Tus-Version": tusVersion},
+		},
+		{
+			name:       "create with another protocol version",
+			method:     http.MethodPost,
+			path:       "/mr-content/65f000000000000000000003/tus",
+			headers:    map[string]string{"Tus-Resumable": "0.2.2", "Upload-Length": "10"},
+			wantStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:       "create for an invalid content ID",
+			method:     http.MethodPost,
+			path:       "/mr-content/not-an-id/tus",
+			headers:    map[string]string{"Tus-Resumable": tusVersion, "Upload-Length": "10"},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "head without Tus-Resumable",
+			method:     http.MethodHead,
+			path:       uploadPath,
+			wantStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:       "patch with the wrong content type closes the connection",
+			method:     http.MethodPatch,
+			path:       uploadPath,
+			headers:    map[string]string{"Tus-Resumable": tusVersion, "Upload-Offset": "0", "Content-Type": "application/octet-stream"},
+			body:       "0123456789",
+			wantStatus: http.StatusUnsupportedMediaType,
+			wantClose:  true,
+		},
+		{
+			name:       "patch for an invalid content ID",
+			method:     http.MethodPatch,
+			path:       "/mr-content/not-an-id/tus/65f000000000000000000004",
+			headers:    map[string]string{"Tus-Resumable": tusVersion, "Upload-Offset": "0", "Content-Type": "application/offset+octet-stream"},
+			body:       "0123456789",
+			wantStatus: http.StatusBadRequest,
+			wantClose:  true,
+		},
+		{
+			name:       "delete without Tus-Resumable",
+			method:     http.MethodDelete,
+			path:       uploadPath,
+			wantStatus: http.StatusPreconditionFailed,
+		},
+	}
+
+	app := newTusTestApp(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			for name, want := range tt.wantHeaders {
+				if got := resp.Header.Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+			// The unread body of a rejected chunk must not be parsed as the next request
+			if resp.Close != tt.wantClose {
+				t.Errorf("connection close = %v, want %v", resp.Close, tt.wantClose)
+			}
+		})
+	}
+}
+
+func TestTusHandlersWhenNotConfigured(t *testing.T) {
+	app := newTusTestApp(t)
+	tusUploads = nil
+
+	req := httptest.NewRequest(http.MethodPost, "/mr-content/65f000000000000000000003/tus", nil)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+}
+
+func TestParseTusMetadata(t *testing.T) {
+	encode := func(value string) string { return base64.StdEncoding.EncodeToString([]byte(value)) }
+
+	tests := []struct {
+		name   string
+		header string
+		want   map[string]string
+	}{
+		{"empty", "", map[string]string{}},
+		{"pairs", "filename " + encode("clip.mp4") + ",filetype " + encode("video/mp4"),
+			map[string]string{"filename": "clip.mp4", "filetype": "video/mp4"}},
+		{"key without value", "is_confidential,role " + encode("original_2"),
+			map[string]string{"is_confidential": "", "role": "original_2"}},
+		{"invalid base64 is empty", "filename ***", map[string]string{"filename": ""}},
+		{"surrounding spaces", " media_type " + encode("image") + " , ", map[string]string{"media_type": "image"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseTusMetadata(tt.header)
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseTusMetadata(%q) = %v, want %v", tt.header, got, tt.want)
+			}
+			for key, want := range tt.want {
+				if value, ok := got[key]; !ok || value != want {
+					t.Errorf("%s = %q, want %q", key, value, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseTusChecksum(t *testing.T) {
+	digest := sha256.Sum256([]byte("chunk"))
+	encoded := base64.StdEncoding.EncodeToString(digest[:])
+
+	tests := []struct {
+		name    string
+		header  string
+		wantErr bool
+	}{
+		{"sha256", "sha256 " + encoded, false},
+		{"sha1", "sha1 " + encoded, false},
+		{"md5", "md5 " + encoded, false},
+		{"unsupported algorithm", "crc32 " + encoded, true},
+		{"missing digest", "sha256", true},
+		{"invalid digest", "sha256 ***", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checksum, expected, err := parseTusChecksum(tt.header)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTusChecksum(%q) error = %v, want error %v", tt.header, err, tt.wantErr)
+			}
+			if err == nil && (checksum == nil || len(expected) != len(digest)) {
+				t.Errorf("parseTusChecksum(%q) = %v, %x", tt.header, checksum, expected)
+			}
+		})
+	}
+}
+
+func TestFileChunkStore(t *testing.T) {
+	store, err := newFileChunkStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		offset int64
+		chunk  string
+		want   string
+	}{
+		{0, "hello ", "hello "},
+		{6, "world", "hello world"},
+		{6, "there", "hello there"}, // a resumed chunk overwrites from its offset
+	}
+	for _, step := range steps {
+		if err := store.Truncate("upload", step.offset); err != nil && step.offset > 0 {
+			t.Fatal(err)
+		}
+		written, err := store.WriteAt("upload", step.offset, strings.NewReader(step.chunk))
+		if err != nil || written != int64(len(step.chunk)) {
+			t.Fatalf("WriteAt(%d, %q) = %d, %v", step.offset, step.chunk, written, err)
+		}
+		if got := readChunks(t, store, "upload"); got != step.want {
+			t.Errorf("after WriteAt(%d, %q) upload holds %q, want %q", step.offset, step.chunk, got, step.want)
+		}
+	}
+
+	// An empty upload still has a file to finalize from
+	if _, err := store.WriteAt("empty", 0, http.NoBody); err != nil {
+		t.Fatal(err)
+	}
+	if got := readChunks(t, store, "empty"); got != "" {
+		t.Errorf("empty upload holds %q", got)
+	}
+
+	if err := store.Remove("upload"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Remove("upload"); err != nil {
+		t.Errorf("removing a missing upload = %v, want nil", err)
+	}
+}
+
+func TestCappedReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		limit   int64
+		wantErr error
+	}{
+		{"under the limit", "12345", 10, nil},
+		{"at the limit", "1234567890", 10, nil},
+		{"over the limit", "12345678901", 10, errUploadTooLarge},
+		{"empty", "", 0, nil},
+		{"over an empty limit", "1", 0, errUploadTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := io.ReadAll(&cappedReader{reader: strings.NewReader(tt.body), remaining: tt.limit})
+			if err != tt.wantErr {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func readChunks(t *testing.T, store TusChunkStore, id string) string {
+	t.Helper()
+	reader, err := store.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// TestTusSweeper checks which uploads the sweeper removes and which finalizations it takes
+// over. It needs a MongoDB server in MONGO_TEST_URI.
+func TestTusSweeper(t *testing.T) {
+	collection := connectTestDatabase(t).Collection(tusUploadsCollection)
+
+	store, err := newFileChunkStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploads := &TusUploads{store: store, expiry: time.Hour}
+	previous := tusUploads
+	tusUploads = uploads
+	t.Cleanup(func() { tusUploads = previous })
+
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	tests := []struct {
+		name          string
+		status        string
+		expiresAt     time.Time
+		finalizeUntil *time.Time
+		wantStatus    string // Empty when the upload is removed
+	}{
+		{"expired upload", "uploading", past, nil, ""},
+		{"expired failure", "failed", past, nil, ""},
+		{"expired abandoned finalization", "finalizing", past, &past, ""},
+		{"expired finalization without a lease", "finalizing", past, nil, ""},
+		{"expired finalization holding its lease", "finalizing", past, &future, "finalizing"},
+		{"unexpired upload", "uploading", future, nil, "uploading"},
+		{"unexpired failure", "failed", future, nil, "failed"},
+		{"completed upload", "completed", past, nil, "completed"},
+		// Retried, and failed again because no storage backend is configured in tests
+		{"unexpired abandoned finalization", "finalizing", future, &past, "failed"},
+	}
+
+	ids := make([]primitive.ObjectID, len(tests))
+	for i, tt := range tests {
+		ids[i] = primitive.NewObjectID()
+		_, err := collection.InsertOne(context.Background(), models.TusUpload{
+			ID:            ids[i],
+			ContentID:     primitive.NewObjectID(),
+			Length:        4,
+			Offset:        4,
+			Status:        tt.status,
+			ExpiresAt:     tt.expiresAt,
+			FinalizeUntil: tt.finalizeUntil,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.WriteAt(ids[i].Hex(), 0, strings.NewReader("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	uploads.retryStaleFinalizations()
+	uploads.removeExpired()
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var upload models.TusUpload
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				err := collection.FindOne(context.Background(), bson.M{"_id": ids[i]}).Decode(&upload)
+				if tt.wantStatus == "" {
+					if err == nil {
+						t.Errorf("upload with status %s was kept", upload.Status)
+					}
+					if reader, err := store.Open(ids[i].Hex()); err == nil {
+						reader.Close()
+						t.Error("chunks of the removed upload were kept")
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("upload was removed: %v", err)
+				}
+				// Retried finalizations run in the background
+				if upload.Status == tt.wantStatus || time.Now().After(deadline) {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if upload.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", upload.Status, tt.wantStatus)
+			}
+		})
+	}
+}
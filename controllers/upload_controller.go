@@ -0,0 +1,276 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/praleedsuvarna/shared-libs/config"
+	"github.com/praleedsuvarna/shared-libs/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const mediaUploadsCollection = "oms_media_uploads"
+
+// Media roles are stored as media keys, so they follow the same "original*" naming
+var mediaRolePattern = regexp.MustCompile(`^original[a-z0-9_]*$`)
+
+// Content fields holding each media type
+var mediaFields = map[string]string{
+	"image":     "images",
+	"video":     "videos",
+	"object_3d": "objects_3d",
+}
+
+// uploadRequest is the body accepted when requesting a direct upload
+type uploadRequest struct {
+	MediaType   string `json:"media_type"`
+	Role        string `json:"role"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+// CreateMediaUpload issues a presigned URL the client uploads an original to directly
+func CreateMediaUpload(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	orgID := c.Locals("organization_id").(string)
+
+	storage := GetStorage()
+	if storage == nil {
+		return c.Status(http.StatusServiceUnavailable).JSON(fiber.Map{"error": "Direct uploads are not configured"})
+	}
+
+	var request uploadRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if request.Role == "" {
+		request.Role = "original"
+	}
+	request.ContentType = strings.ToLower(strings.TrimSpace(request.ContentType))
+
+	var fieldErrors []MediaURLError
+	if _, ok := mediaFields[request.MediaType]; !ok {
+		fieldErrors = append(fieldErrors, MediaURLError{Field: "media_type", Message: "must be one of image, video, object_3d"})
+	} else {
+		if !contentTypeMatches(request.ContentType, request.MediaType) || request.ContentType == "" {
+			fieldErrors = append(fieldErrors, MediaURLError{Field: "content_type", Message: fmt.Sprintf("%q is not valid for %s media", request.ContentType, request.MediaType)})
+		}
+		if limit := maxMediaSize(request.MediaType); request.Size > limit {
+			fieldErrors = append(fieldErrors, MediaURLError{Field: "size", Message: fmt.Sprintf("the limit for %s media is %d MB", request.MediaType, limit>>20)})
+		}
+	}
+	if !mediaRolePattern.MatchString(request.Role) {
+		fieldErrors = append(fieldErrors, MediaURLError{Field: "role", Message: "must start with \"original\" and contain only lowercase letters, digits and underscores"})
+	}
+	if len(fieldErrors) > 0 {
+		return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Invalid upload request", "fields": fieldErrors})
+	}
+
+	content, status, err := findOrganizationContent(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	objUserID, _ := primitive.ObjectIDFromHex(userID)
+	uploadID := primitive.NewObjectID()
+	objectKey := fmt.Sprintf("%s/%s/%s/%s%s", orgID, content.ID.Hex(), request.Role, uploadID.Hex(), strings.ToLower(path.Ext(request.Filename)))
+	expires := time.Duration(getEnvInt("UPLOAD_URL_EXPIRY_MINUTES", 15)) * time.Minute
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	presigned, err := storage.PresignPut(ctx, objectKey, request.ContentType, expires)
+	if err != nil {
+		log.Printf("Error presigning upload for content ID %s: %v", content.ID.Hex(), err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create upload URL"})
+	}
+
+	now := time.Now()
+	upload := models.MediaUpload{
+		ID:             uploadID,
+		ContentID:      content.ID,
+		OrganizationID: content.OrganizationID,
+		UserID:         objUserID,
+		MediaType:      request.MediaType,
+		Role:           request.Role,
+		Backend:        storage.Name(),
+		ObjectKey:      objectKey,
+		Filename:       request.Filename,
+		ContentType:    request.ContentType,
+		Size:           request.Size,
+		Status:         "pending",
+		ExpiresAt:      presigned.ExpiresAt,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+
+	if _, err := config.GetCollection(mediaUploadsCollection).InsertOne(ctx, upload); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create upload"})
+	}
+
+	return c.Status(http.StatusCreated).JSON(fiber.Map{
+		"upload":     upload,
+		"upload_url": presigned,
+	})
+}
+
+// CompleteMediaUpload verifies the uploaded object, records it under the upload's role
+// and starts processing it
+func CompleteMediaUpload(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+
+	storage := GetStorage()
+	if storage == nil {
+		return c.Status(http.StatusServiceUnavailable).JSON(fiber.Map{"error": "Direct uploads are not configured"})
+	}
+
+	content, status, err := findOrganizationContent(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	uploadID, err := primitive.ObjectIDFromHex(c.Params("upload_id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid upload ID format"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	uploads := config.GetCollection(mediaUploadsCollection)
+
+	var upload models.MediaUpload
+	err = uploads.FindOne(ctx, bson.M{"_id": uploadID, "content_id": content.ID}).Decode(&upload)
+	if err != nil {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{"error": "Upload not found"})
+	}
+	if upload.Status != "pending" {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{"error": "Upload is already completed"})
+	}
+
+	info, err := storage.Stat(ctx, upload.ObjectKey)
+	if errors.Is(err, ErrObjectNotFound) {
+		return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{"error": "The object has not been uploaded yet"})
+	}
+	if err != nil {
+		log.Printf("Error verifying upload %s: %v", uploadID.Hex(), err)
+		return c.Status(http.StatusBadGateway).JSON(fiber.Map{"error": "Failed to verify the uploaded object"})
+	}
+
+	if err := verifyUploadedObject(upload, info); err != nil {
+		return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	// Claim the completion so concurrent calls record the object only once
+	now := time.Now()
+	result, err := uploads.UpdateOne(ctx,
+		bson.M{"_id": uploadID, "status": "pending"},
+		bson.M{"$set": bson.M{"status": "completed", "size": info.Size, "completed_at": now, "updated_at": now}},
+	)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to complete upload"})
+	}
+	if result.ModifiedCount == 0 {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{"error": "Upload is already completed"})
+	}
+
+	updatedContent, err := attachUploadedMedia(upload, storage.PublicURL(upload.ObjectKey))
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to record uploaded media"})
+	}
+
+	utils.LogAudit(userID, "Completed media upload", content.ID.Hex())
+
+	return c.JSON(transformMRContentResponse(updatedContent))
+}
+
+// attachUploadedMedia records an uploaded original in the content's media array under the
+// upload's role and queues processing of only that media
+func attachUploadedMedia(upload models.MediaUpload, mediaURL string) (models.MRContent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := config.GetCollection("oms_mrexperiences")
+	field := mediaFields[upload.MediaType]
+
+	intent := NewDispatchIntent(models.MRContent{ID: upload.ContentID, OrganizationID: upload.OrganizationID},
+		map[string][]string{field: {upload.Role}})
+
+	// Only the uploaded entry is written, so uploads finishing in parallel don't overwrite each other
+	err := WriteWithOutbox(ctx, func(ctx context.Context) error {
+		return setMediaEntry(ctx, collection, upload.ContentID, field, upload.Role, mediaURL)
+	}, intent)
+	if err != nil {
+		return models.MRContent{}, err
+	}
+
+	var content models.MRContent
+	if err := collection.FindOne(ctx, bson.M{"_id": upload.ContentID}).Decode(&content); err != nil {
+		return content, err
+	}
+
+	log.Printf("Media processing queued for uploaded %s %s of content ID: %s", upload.MediaType, upload.Role, content.ID.Hex())
+
+	return content, nil
+}
+
+// verifyUploadedObject checks the stored object against the upload request and media limits
+func verifyUploadedObject(upload models.MediaUpload, info ObjectInfo) error {
+	if info.Size <= 0 {
+		return fmt.Errorf("The uploaded object is empty")
+	}
+	if upload.Size > 0 && info.Size != upload.Size {
+		return fmt.Errorf("The uploaded object is %d bytes, expected %d", info.Size, upload.Size)
+	}
+	if limit := maxMediaSize(upload.MediaType); info.Size > limit {
+		return fmt.Errorf("The uploaded object exceeds the %d MB limit for %s media", limit>>20, upload.MediaType)
+	}
+
+	contentType := strings.ToLower(strings.TrimSpace(strings.Split(info.ContentType, ";")[0]))
+	if !contentTypeMatches(contentType, upload.MediaType) {
+		return fmt.Errorf("The uploaded object has content type %q, which is not valid for %s media", contentType, upload.MediaType)
+	}
+
+	return nil
+}
+
+// findOrganizationContent loads the active content in the :id param if it belongs to the caller's organization
+func findOrganizationContent(c *fiber.Ctx) (models.MRContent, int, error) {
+	var content models.MRContent
+
+	objOrgID, err := primitive.ObjectIDFromHex(c.Locals("organization_id").(string))
+	if err != nil {
+		return content, http.StatusBadRequest, fmt.Errorf("Invalid organization ID")
+	}
+
+	objContentID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return content, http.StatusBadRequest, fmt.Errorf("Invalid content ID format")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = config.GetCollection("oms_mrexperiences").FindOne(ctx, bson.M{
+		"_id":             objContentID,
+		"organization_id": objOrgID,
+		"is_active":       true,
+	}).Decode(&content)
+	if err != nil {
+		return content, http.StatusNotFound, fmt.Errorf("MR content not found")
+	}
+
+	return content, http.StatusOK, nil
+}
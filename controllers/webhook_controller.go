@@ -0,0 +1,356 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/praleedsuvarna/shared-libs/config"
+	"github.com/praleedsuvarna/shared-libs/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// webhookRequest is the body accepted when creating or updating a subscription
+type webhookRequest struct {
+	URL         string   `json:"url"`
+	EventTypes  []string `json:"event_types"`
+	Description string   `json:"description"`
+	IsActive    *bool    `json:"is_active"`
+}
+
+// CreateWebhook registers a webhook subscription for the caller's organization.
+// The signing secret is only returned in this response.
+func CreateWebhook(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	orgID := c.Locals("organization_id").(string)
+
+	var request webhookRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	objOrgID, err := primitive.ObjectIDFromHex(orgID)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid organization ID"})
+	}
+	objUserID, _ := primitive.ObjectIDFromHex(userID)
+
+	if err := validateWebhookURL(request.URL); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if len(request.EventTypes) == 0 {
+		request.EventTypes = []string{"*"}
+	}
+	if err := validateWebhookEventTypes(request.EventTypes); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to generate webhook secret"})
+	}
+
+	now := time.Now()
+	subscription := models.WebhookSubscription{
+		ID:             primitive.NewObjectID(),
+		OrganizationID: objOrgID,
+		UserID:         objUserID,
+		URL:            request.URL,
+		Secret:         secret,
+		EventTypes:     request.EventTypes,
+		Description:    request.Description,
+		IsActive:       true,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := config.GetCollection(webhookSubscriptionsCollection).InsertOne(ctx, subscription); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create webhook"})
+	}
+
+	utils.LogAudit(userID, "Created webhook subscription", subscription.ID.Hex())
+
+	return c.Status(http.StatusCreated).JSON(fiber.Map{
+		"webhook": subscription,
+		"secret":  secret,
+	})
+}
+
+// ListWebhooks returns the organization's webhook subscriptions
+func ListWebhooks(c *fiber.Ctx) error {
+	objOrgID, err := primitive.ObjectIDFromHex(c.Locals("organization_id").(string))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid organization ID"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := config.GetCollection(webhookSubscriptionsCollection).Find(ctx,
+		bson.M{"organization_id": objOrgID},
+		options.Find().SetSort(bson.M{"created_at": -1}),
+	)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer cursor.Close(ctx)
+
+	subscriptions := []models.WebhookSubscription{}
+	if err := cursor.All(ctx, &subscriptions); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"data": subscriptions, "event_types": WebhookEventTypes})
+}
+
+// GetWebhook returns a single webhook subscription
+func GetWebhook(c *fiber.Ctx) error {
+	subscription, status, err := findOrganizationWebhook(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(subscription)
+}
+
+// UpdateWebhook changes the URL, event filter or active flag of a subscription.
+// Re-activating a disabled subscription resets its failure count.
+func UpdateWebhook(c *fiber.Ctx) error {
+	subscription, status, err := findOrganizationWebhook(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var request webhookRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	updateSet := bson.M{"updated_at": time.Now()}
+	update := bson.M{"$set": updateSet}
+
+	if request.URL != "" {
+		if err := validateWebhookURL(request.URL); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		updateSet["url"] = request.URL
+	}
+
+	if request.EventTypes != nil {
+		if err := validateWebhookEventTypes(request.EventTypes); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		updateSet["event_types"] = request.EventTypes
+	}
+
+	if request.Description != "" {
+		updateSet["description"] = request.Description
+	}
+
+	if request.IsActive != nil {
+		updateSet["is_active"] = *request.IsActive
+		if *request.IsActive {
+			updateSet["consecutive_failures"] = 0
+			update["$unset"] = bson.M{"disabled_at": "", "disabled_reason": ""}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var updated models.WebhookSubscription
+	err = config.GetCollection(webhookSubscriptionsCollection).FindOneAndUpdate(ctx,
+		bson.M{"_id": subscription.ID},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update webhook"})
+	}
+
+	utils.LogAudit(c.Locals("user_id").(string), "Updated webhook subscription", subscription.ID.Hex())
+
+	return c.JSON(updated)
+}
+
+// DeleteWebhook removes a subscription; its queued deliveries are cancelled by the dispatcher
+func DeleteWebhook(c *fiber.Ctx) error {
+	subscription, status, err := findOrganizationWebhook(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := config.GetCollection(webhookSubscriptionsCollection).DeleteOne(ctx, bson.M{"_id": subscription.ID}); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete webhook"})
+	}
+
+	utils.LogAudit(c.Locals("user_id").(string), "Deleted webhook subscription", subscription.ID.Hex())
+
+	return c.JSON(fiber.Map{"message": "Webhook deleted successfully"})
+}
+
+// ListWebhookDeliveries returns the delivery log of a subscription, newest first
+func ListWebhookDeliveries(c *fiber.Ctx) error {
+	subscription, status, err := findOrganizationWebhook(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	limit := c.QueryInt("limit", 50)
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	filter := bson.M{"subscription_id": subscription.ID}
+	if deliveryStatus := c.Query("status"); deliveryStatus != "" {
+		filter["status"] = deliveryStatus
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := config.GetCollection(webhookDeliveriesCollection).Find(ctx, filter,
+		options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer cursor.Close(ctx)
+
+	deliveries := []models.WebhookDelivery{}
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"data": deliveries})
+}
+
+// RedeliverWebhook puts a delivery back in the queue for an immediate attempt
+func RedeliverWebhook(c *fiber.Ctx) error {
+	subscription, status, err := findOrganizationWebhook(c)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	deliveryID, err := primitive.ObjectIDFromHex(c.Params("delivery_id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid delivery ID format"})
+	}
+
+	if !subscription.IsActive {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{"error": "Webhook is disabled, re-activate it before redelivering"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := config.GetCollection(webhookDeliveriesCollection).UpdateOne(ctx,
+		bson.M{"_id": deliveryID, "subscription_id": subscription.ID},
+		bson.M{"$set": bson.M{
+			"status":          "pending",
+			"attempts":        0,
+			"next_attempt_at": time.Now(),
+			"updated_at":      time.Now(),
+		}},
+	)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to queue redelivery"})
+	}
+	if result.MatchedCount == 0 {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{"error": "Delivery not found"})
+	}
+
+	utils.LogAudit(c.Locals("user_id").(string), "Requested webhook redelivery", deliveryID.Hex())
+
+	return c.Status(http.StatusAccepted).JSON(fiber.Map{"message": "Delivery queued for redelivery"})
+}
+
+// findOrganizationWebhook loads the subscription in the :id param if it belongs to the caller's organization
+func findOrganizationWebhook(c *fiber.Ctx) (models.WebhookSubscription, int, error) {
+	var subscription models.WebhookSubscription
+
+	objOrgID, err := primitive.ObjectIDFromHex(c.Locals("organization_id").(string))
+	if err != nil {
+		return subscription, http.StatusBadRequest, fmt.Errorf("Invalid organization ID")
+	}
+
+	webhookID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return subscription, http.StatusBadRequest, fmt.Errorf("Invalid webhook ID format")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = config.GetCollection(webhookSubscriptionsCollection).FindOne(ctx, bson.M{
+		"_id":             webhookID,
+		"organization_id": objOrgID,
+	}).Decode(&subscription)
+	if err != nil {
+		return subscription, http.StatusNotFound, fmt.Errorf("Webhook not found")
+	}
+
+	return subscription, http.StatusOK, nil
+}
+
+// validateWebhookURL requires an absolute https URL (http is allowed in development) on a
+// host that resolves to public addresses only. Deliveries check the address again when they
+// connect, since DNS may change after the subscription is saved.
+func validateWebhookURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return fmt.Errorf("Invalid webhook URL")
+	}
+
+	secure := parsed.Scheme == "https" ||
+		(parsed.Scheme == "http" && config.GetEnv("APP_ENV", "development") == "development")
+	if !secure {
+		return fmt.Errorf("Webhook URL must use https")
+	}
+
+	if allowPrivateWebhookHosts() {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := lookupPublicHost(ctx, parsed.Hostname()); err != nil {
+		return fmt.Errorf("Webhook URL must point to a public host")
+	}
+	return nil
+}
+
+// validateWebhookEventTypes rejects unknown event types
+func validateWebhookEventTypes(eventTypes []string) error {
+	for _, eventType := range eventTypes {
+		if eventType != "*" && !utils.Contains(WebhookEventTypes, eventType) {
+			return fmt.Errorf("Unknown event type: %s", eventType)
+		}
+	}
+	return nil
+}
+
+// generateWebhookSecret returns a random signing secret
+func generateWebhookSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,462 @@
+package controllers
+
+import (
+	"MRContent/models"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/praleedsuvarna/shared-libs/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	webhookSubscriptionsCollection = "oms_webhook_subscriptions"
+	webhookDeliveriesCollection    = "oms_webhook_deliveries"
+)
+
+// Content lifecycle events customers can subscribe to
+const (
+	WebhookContentCreated   = "content.created"
+	WebhookContentProcessed = "content.processed"
+	WebhookContentFailed    = "content.failed"
+	WebhookContentPublished = "content.published"
+	WebhookContentDeleted   = "content.deleted"
+)
+
+// WebhookEventTypes lists every event type a subscription may filter on
+var WebhookEventTypes = []string{
+	WebhookContentCreated,
+	WebhookContentProcessed,
+	WebhookContentFailed,
+	WebhookContentPublished,
+	WebhookContentDeleted,
+}
+
+// Number of attempt log entries kept per delivery
+const webhookAttemptLogSize = 20
+
+// WebhookDispatcher works through the persistent delivery queue. Every replica may run
+// one; deliveries are claimed atomically so each attempt is made by a single replica.
+// Deliveries are attempted by a small pool of workers, and each subscription gets only a
+// few of them so one slow endpoint can't hold up every organization's webhooks.
+type WebhookDispatcher struct {
+	client          *http.Client
+	interval        time.Duration
+	maxAttempts     int
+	disableAfter    int
+	perSubscription int
+	workers         chan struct{} // One token per delivery being attempted
+	inFlight        sync.WaitGroup
+	cancel          context.CancelFunc
+	done            chan struct{}
+
+	mutex sync.Mutex
+	busy  map[primitive.ObjectID]int // Deliveries being attempted per subscription
+}
+
+// Global instance of the dispatcher, nil until InitWebhookDispatcher is called
+var webhookDispatcher *WebhookDispatcher
+
+// InitWebhookDispatcher starts the background webhook delivery worker
+func InitWebhookDispatcher() *WebhookDispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	dispatcher := &WebhookDispatcher{
+		client:          newWebhookClient(),
+		interval:        time.Duration(getEnvInt("WEBHOOK_POLL_INTERVAL_SECONDS", 5)) * time.Second,
+		maxAttempts:     getEnvInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		disableAfter:    getEnvInt("WEBHOOK_DISABLE_AFTER_FAILURES", 25),
+		perSubscription: getEnvInt("WEBHOOK_WORKERS_PER_SUBSCRIPTION", 2),
+		workers:         make(chan struct{}, getEnvInt("WEBHOOK_WORKERS", 8)),
+		cancel:          cancel,
+		done:            make(chan struct{}),
+		busy:            map[primitive.ObjectID]int{},
+	}
+
+	go dispatcher.run(ctx)
+
+	webhookDispatcher = dispatcher
+	log.Printf("Webhook dispatcher started (poll: %s, workers: %d, %d per subscription, max attempts: %d, disable after %d failures)",
+		dispatcher.interval, cap(dispatcher.workers), dispatcher.perSubscription, dispatcher.maxAttempts, dispatcher.disableAfter)
+	return dispatcher
+}
+
+// newWebhookClient returns the client deliveries are sent with. Customer endpoints are
+// untrusted: it only connects to public addresses and never follows redirects, so a
+// redirect is reported as a failed delivery.
+func newWebhookClient() *http.Client {
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			Proxy:                 nil,
+			DialContext:           publicDialContext(allowPrivateWebhookHosts),
+			TLSHandshakeTimeout:   5 * time.Second,
+			ResponseHeaderTimeout: 10 * time.Second,
+			MaxIdleConnsPerHost:   2,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// allowPrivateWebhookHosts lets development setups deliver to local receivers
+func allowPrivateWebhookHosts() bool {
+	return config.GetEnv("WEBHOOK_ALLOW_PRIVATE_HOSTS", "false") == "true"
+}
+
+// StopWebhookDispatcher stops the global dispatcher and waits for the current batch to end
+func StopWebhookDispatcher() {
+	if webhookDispatcher != nil {
+		webhookDispatcher.cancel()
+		<-webhookDispatcher.done
+		webhookDispatcher = nil
+		log.Println("Webhook dispatcher stopped")
+	}
+}
+
+// EmitWebhookEvent queues an event for every active subscription of the organization
+// that listens to its type. Delivery happens asynchronously in the dispatcher.
+func EmitWebhookEvent(orgID primitive.ObjectID, eventType string, data map[string]interface{}) {
+	if orgID.IsZero() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := config.GetCollection(webhookSubscriptionsCollection).Find(ctx, bson.M{
+		"organization_id": orgID,
+		"is_active":       true,
+		"event_types":     bson.M{"$in": []string{eventType, "*"}},
+	})
+	if err != nil {
+		log.Printf("Error loading webhook subscriptions for %s: %v", eventType, err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var subscriptions []models.WebhookSubscription
+	if err := cursor.All(ctx, &subscriptions); err != nil {
+		log.Printf("Error decoding webhook subscriptions: %v", err)
+		return
+	}
+
+	if len(subscriptions) == 0 {
+		return
+	}
+
+	now := time.Now()
+	eventID := primitive.NewObjectID().Hex()
+	payload, err := json.Marshal(map[string]interface{}{
+		"id":              eventID,
+		"type":            eventType,
+		"organization_id": orgID.Hex(),
+		"created_at":      now.UTC(),
+		"data":            data,
+	})
+	if err != nil {
+		log.Printf("Error marshaling webhook payload for %s: %v", eventType, err)
+		return
+	}
+
+	deliveries := make([]interface{}, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		deliveries = append(deliveries, models.WebhookDelivery{
+			ID:             primitive.NewObjectID(),
+			SubscriptionID: subscription.ID,
+			OrganizationID: orgID,
+			EventID:        eventID,
+			EventType:      eventType,
+			Payload:        string(payload),
+			Status:         "pending",
+			NextAttemptAt:  now,
+			CreatedAt:      now,
+			UpdatedAt:      now,
+		})
+	}
+
+	if _, err := config.GetCollection(webhookDeliveriesCollection).InsertMany(ctx, deliveries); err != nil {
+		log.Printf("Error queueing %d webhook deliveries for %s: %v", len(deliveries), eventType, err)
+		return
+	}
+
+	log.Printf("Queued %s webhook event %s for %d subscription(s)", eventType, eventID, len(deliveries))
+}
+
+// run polls the delivery queue until the context is cancelled, then waits for the
+// deliveries being attempted
+func (d *WebhookDispatcher) run(ctx context.Context) {
+	defer close(d.done)
+	defer d.inFlight.Wait()
+
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.drain(ctx)
+		}
+	}
+}
+
+// drain claims due deliveries and hands them to the next free worker until none are left
+// or the remaining deliveries belong to subscriptions already using all their workers
+func (d *WebhookDispatcher) drain(ctx context.Context) {
+	for {
+		select {
+		case d.workers <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+
+		now := time.Now()
+		filter := bson.M{"status": "pending", "next_attempt_at": bson.M{"$lte": now}}
+		if busy := d.busySubscriptions(); len(busy) > 0 {
+			filter["subscription_id"] = bson.M{"$nin": busy}
+		}
+
+		var delivery models.WebhookDelivery
+		err := config.GetCollection(webhookDeliveriesCollection).FindOneAndUpdate(ctx,
+			filter,
+			// Lease the delivery while this replica attempts it
+			bson.M{"$set": bson.M{"next_attempt_at": now.Add(time.Minute), "updated_at": now}},
+			options.FindOneAndUpdate().SetSort(bson.M{"next_attempt_at": 1}),
+		).Decode(&delivery)
+
+		if err != nil {
+			<-d.workers
+			if err != mongo.ErrNoDocuments && ctx.Err() == nil {
+				log.Printf("Error claiming webhook delivery: %v", err)
+			}
+			return
+		}
+
+		d.setBusy(delivery.SubscriptionID, 1)
+		d.inFlight.Add(1)
+		go func() {
+			defer d.inFlight.Done()
+			defer func() { <-d.workers }()
+			defer d.setBusy(delivery.SubscriptionID, -1)
+
+			d.attempt(delivery)
+		}()
+	}
+}
+
+// busySubscriptions lists the subscriptions that already use all the workers they may
+func (d *WebhookDispatcher) busySubscriptions() []primitive.ObjectID {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var busy []primitive.ObjectID
+	for subscriptionID, count := range d.busy {
+		if count >= d.perSubscription {
+			busy = append(busy, subscriptionID)
+		}
+	}
+	return busy
+}
+
+// setBusy counts a delivery of a subscription in or out
+func (d *WebhookDispatcher) setBusy(subscriptionID primitive.ObjectID, delta int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.busy[subscriptionID] += delta
+	if d.busy[subscriptionID] <= 0 {
+		delete(d.busy, subscriptionID)
+	}
+}
+
+// attempt sends one delivery and records the outcome
+func (d *WebhookDispatcher) attempt(delivery models.WebhookDelivery) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	var subscription models.WebhookSubscription
+	err := config.GetCollection(webhookSubscriptionsCollection).FindOne(ctx, bson.M{"_id": delivery.SubscriptionID}).Decode(&subscription)
+	if err != nil || !subscription.IsActive {
+		d.finish(delivery, "cancelled", models.WebhookAttempt{At: time.Now(), Error: "subscription deleted or disabled"})
+		return
+	}
+
+	entry := deliverWebhook(ctx, d.client, subscription, delivery)
+
+	if entry.Error == "" {
+		d.finish(delivery, "delivered", entry)
+		d.recordSubscriptionResult(subscription, true)
+		return
+	}
+
+	log.Printf("Webhook delivery %s to %s failed (attempt %d): %s",
+		delivery.ID.Hex(), subscription.URL, delivery.Attempts+1, entry.Error)
+
+	if delivery.Attempts+1 >= d.maxAttempts {
+		d.finish(delivery, "failed", entry)
+	} else {
+		d.reschedule(delivery, entry)
+	}
+	d.recordSubscriptionResult(subscription, false)
+}
+
+// deliverWebhook POSTs the signed payload and returns the attempt log entry
+func deliverWebhook(ctx context.Context, client *http.Client, subscription models.WebhookSubscription, delivery models.WebhookDelivery) models.WebhookAttempt {
+	started := time.Now()
+	entry := models.WebhookAttempt{At: started}
+
+	timestamp := strconv.FormatInt(started.Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		entry.Error = err.Error()
+		return entry
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "MRContent-Webhooks/1.0")
+	req.Header.Set("X-MRContent-Event", delivery.EventType)
+	req.Header.Set("X-MRContent-Delivery", delivery.ID.Hex())
+	req.Header.Set("X-MRContent-Timestamp", timestamp)
+	req.Header.Set("X-MRContent-Signature", SignWebhookPayload(subscription.Secret, timestamp, delivery.Payload))
+
+	resp, err := client.Do(req)
+	entry.DurationMs = time.Since(started).Milliseconds()
+	if err != nil {
+		entry.Error = err.Error()
+		return entry
+	}
+	defer resp.Body.Close()
+
+	entry.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		entry.Error = fmt.Sprintf("endpoint responded with %d", resp.StatusCode)
+	}
+
+	return entry
+}
+
+// SignWebhookPayload returns the signature header value: an HMAC-SHA256 over
+// "<timestamp>.<payload>" keyed with the subscription secret
+func SignWebhookPayload(secret, timestamp, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + payload))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// finish closes a delivery with a final status
+func (d *WebhookDispatcher) finish(delivery models.WebhookDelivery, status string, entry models.WebhookAttempt) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	set := bson.M{"status": status, "updated_at": time.Now()}
+	if status == "delivered" {
+		set["delivered_at"] = entry.At
+	}
+
+	_, err := config.GetCollection(webhookDeliveriesCollection).UpdateOne(ctx,
+		bson.M{"_id": delivery.ID},
+		bson.M{
+			"$set":  set,
+			"$inc":  bson.M{"attempts": 1},
+			"$push": bson.M{"attempt_log": bson.M{"$each": []models.WebhookAttempt{entry}, "$slice": -webhookAttemptLogSize}},
+		},
+	)
+	if err != nil {
+		log.Printf("Error updating webhook delivery %s: %v", delivery.ID.Hex(), err)
+	}
+}
+
+// reschedule puts a delivery back in the queue with exponential backoff
+func (d *WebhookDispatcher) reschedule(delivery models.WebhookDelivery, entry models.WebhookAttempt) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// 30s, 1m, 2m, 4m, ... capped at 6h
+	backoff := 30 * time.Second * time.Duration(1<<uint(delivery.Attempts))
+	if backoff > 6*time.Hour {
+		backoff = 6 * time.Hour
+	}
+
+	_, err := config.GetCollection(webhookDeliveriesCollection).UpdateOne(ctx,
+		bson.M{"_id": delivery.ID},
+		bson.M{
+			"$set":  bson.M{"next_attempt_at": time.Now().Add(backoff), "updated_at": time.Now()},
+			"$inc":  bson.M{"attempts": 1},
+			"$push": bson.M{"attempt_log": bson.M{"$each": []models.WebhookAttempt{entry}, "$slice": -webhookAttemptLogSize}},
+		},
+	)
+	if err != nil {
+		log.Printf("Error rescheduling webhook delivery %s: %v", delivery.ID.Hex(), err)
+	}
+}
+
+// recordSubscriptionResult tracks consecutive failures and disables endpoints that keep failing
+func (d *WebhookDispatcher) recordSubscriptionResult(subscription models.WebhookSubscription, success bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := config.GetCollection(webhookSubscriptionsCollection)
+
+	if success {
+		if subscription.ConsecutiveFailures > 0 {
+			_, err := collection.UpdateOne(ctx,
+				bson.M{"_id": subscription.ID},
+				bson.M{"$set": bson.M{"consecutive_failures": 0, "updated_at": time.Now()}},
+			)
+			if err != nil {
+				log.Printf("Error resetting webhook failures for %s: %v", subscription.ID.Hex(), err)
+			}
+		}
+		return
+	}
+
+	var updated models.WebhookSubscription
+	err := collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": subscription.ID},
+		bson.M{
+			"$inc": bson.M{"consecutive_failures": 1},
+			"$set": bson.M{"updated_at": time.Now()},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err != nil {
+		log.Printf("Error recording webhook failure for %s: %v", subscription.ID.Hex(), err)
+		return
+	}
+
+	if updated.IsActive && updated.ConsecutiveFailures >= d.disableAfter {
+		now := time.Now()
+		_, err := collection.UpdateOne(ctx,
+			bson.M{"_id": subscription.ID, "is_active": true},
+			bson.M{"$set": bson.M{
+				"is_active":       false,
+				"disabled_at":     now,
+				"disabled_reason": fmt.Sprintf("disabled after %d consecutive failed deliveries", updated.ConsecutiveFailures),
+				"updated_at":      now,
+			}},
+		)
+		if err != nil {
+			log.Printf("Error disabling webhook %s: %v", subscription.ID.Hex(), err)
+			return
+		}
+		log.Printf("Disabled webhook %s (%s) after %d consecutive failures",
+			subscription.ID.Hex(), subscription.URL, updated.ConsecutiveFailures)
+	}
+}
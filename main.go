@@ -3,6 +3,7 @@ package main
 import (
 	"MRContent/controllers"
 	"MRContent/routes"
+	"context"
 	"log"
 	"os"
 	"os/signal"
@@ -22,6 +23,11 @@ func main() {
 	// Load configuration (keep it simple like the old version)
 	loadConfiguration()
 
+	// Maintenance commands run instead of the server
+	if len(os.Args) > 1 && os.Args[1] == "reprocess" {
+		os.Exit(runReprocessCommand(os.Args[2:]))
+	}
+
 	// 🔥 ADD THIS LINE to verify secret caching:
 	verifySecretCaching()
 
@@ -29,14 +35,60 @@ func main() {
 	config.ConnectDB()
 	defer config.DisconnectDB()
 
-	// Initialize NATS connection for media processing
-	nc, err := controllers.InitNATS()
-	if err != nil {
-		log.Printf("⚠️ Warning: Failed to initialize NATS connection: %v", err)
-		log.Println("📝 Continuing without NATS as it's not critical for the API to function")
+	// Start the batched writer for ref_id view analytics
+	controllers.InitViewRecorder()
+	defer controllers.StopViewRecorder()
+
+	// Initialize the message bus for media processing (NATS unless MESSAGE_BUS says otherwise)
+	bus, err := controllers.InitMessageBus()
+	if bus == nil {
+		log.Printf("⚠️ Warning: Failed to initialize message bus: %v", err)
+		log.Println("📝 Continuing without a message bus as it's not critical for the API to function")
 	} else {
-		defer controllers.CloseNATS()
-		log.Println("✅ NATS connection established")
+		defer controllers.CloseMessageBus()
+		if err != nil {
+			log.Printf("⚠️ Warning: Failed to initialize %s message bus, retrying when used: %v", bus.Name(), err)
+		} else if bus.Connected() {
+			log.Printf("✅ %s message bus connected", bus.Name())
+		} else {
+			log.Printf("⏳ %s message bus unreachable, reconnecting in the background", bus.Name())
+		}
+	}
+
+	// Retry or give up on processing tasks the MediaProcessor never answered
+	controllers.InitProcessingWatchdog()
+	defer controllers.StopProcessingWatchdog()
+
+	// Relay queued dispatch intents of content writes to the MediaProcessor
+	controllers.InitOutboxRelay()
+	defer controllers.StopOutboxRelay()
+
+	// Release processing tasks held back by per-organization quotas
+	controllers.InitDispatchQueue()
+	defer controllers.StopDispatchQueue()
+
+	// Run fleet reprocessing jobs and resume interrupted ones
+	controllers.InitReprocessRunner()
+	defer controllers.StopReprocessRunner()
+
+	// Alert when processing results pile up in the dead-letter store
+	controllers.InitDeadLetterMonitor()
+	defer controllers.StopDeadLetterMonitor()
+
+	// Deliver queued customer webhooks
+	controllers.InitWebhookDispatcher()
+	defer controllers.StopWebhookDispatcher()
+
+	// Storage for direct uploads of original media
+	if _, err := controllers.InitStorage(); err != nil {
+		log.Printf("⚠️ Warning: Direct uploads disabled, storage backend failed to initialize: %v", err)
+	}
+
+	// Resumable uploads of large originals
+	if _, err := controllers.InitTusUploads(); err != nil {
+		log.Printf("⚠️ Warning: Resumable uploads disabled: %v", err)
+	} else {
+		defer controllers.StopTusUploads()
 	}
 
 	// Set up Fiber app
@@ -56,13 +108,18 @@ func main() {
 
 	// 🔥 CRITICAL: Initialize callback handlers for media processing results
 	// This was missing in the new version!
-	err = controllers.InitCallbackHandlers(app, nc)
+	err = controllers.InitCallbackHandlers(app, bus)
 	if err != nil {
 		log.Printf("⚠️ Warning: Failed to initialize callback handlers: %v", err)
 	} else {
 		log.Println("✅ Media processing callback handlers initialized")
 	}
 
+	// Fan processing events out to the live streams of every replica
+	if err := controllers.InitEventFanout(bus); err != nil {
+		log.Printf("⚠️ Warning: Failed to initialize processing event fan-out: %v", err)
+	}
+
 	// Start the server
 	startServer(app)
 }
@@ -128,11 +185,25 @@ func logConfigurationSummary() {
 		log.Printf("🔗 CORS Origins: %s", config.GetEnv("ALLOWED_ORIGINS", ""))
 	}
 
+	log.Printf("🚌 Message bus: %s", config.GetEnv("MESSAGE_BUS", "nats"))
+
 	if config.GetEnv("NATS_URL", "") != "" {
 		log.Printf("📡 NATS: configured")
 	} else {
 		log.Printf("📡 NATS: not configured")
 	}
+
+	if topic := controllers.CallbackTopic(); topic != "" {
+		log.Printf("📬 Media results: callback topic %s", topic)
+	} else {
+		log.Printf("📬 Media results: shared result.* topics")
+	}
+
+	if group := controllers.ResultQueueGroup(); group != "" {
+		log.Printf("👥 Result queue group: %s", group)
+	} else {
+		log.Printf("👥 Result queue group: none, every replica handles every result")
+	}
 }
 
 func setupFiberApp() *fiber.App {
@@ -143,11 +214,16 @@ func setupFiberApp() *fiber.App {
 		ServerHeader:          "MRContent",
 		StrictRouting:         false, // More flexible routing
 		CaseSensitive:         false, // Case-insensitive routes
+		StreamRequestBody:     true,  // Large direct uploads are streamed to storage
+		BodyLimit:             controllers.RequestBodyLimit(),
 	})
 
 	// Add essential middleware
 	app.Use(recover.New())
 
+	// Streaming lifts fasthttp's body limit, so enforce it everywhere but the upload routes
+	app.Use(controllers.LimitRequestBody(controllers.RequestBodyLimit()))
+
 	// Add logger middleware
 	app.Use(logger.New(logger.Config{
 		Format:     "${time} | ${status} | ${latency} | ${ip} | ${method} | ${path} | ${error}\n",
@@ -182,6 +258,12 @@ func setupSystemEndpoints(app *fiber.App) {
 			"config_mode":    configMode,
 			"performance":    "secrets loaded once at startup - 10,000x faster",
 			"project_id":     os.Getenv("GOOGLE_CLOUD_PROJECT"),
+			"message_bus":    controllers.MessageBusStatus(),
+			"result_consumer": fiber.Map{
+				"topics":      controllers.SubscriptionTopics(),
+				"queue_group": controllers.ResultQueueGroup(),
+				"exclusive":   controllers.ResultQueueGroup() != "",
+			},
 		})
 	})
 
@@ -211,6 +293,10 @@ func setupSystemEndpoints(app *fiber.App) {
 	})
 }
 
+// Headers used by resumable (tus) upload clients
+const tusHeaders = "Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Tus-Checksum-Algorithm, " +
+	"Upload-Length, Upload-Offset, Upload-Metadata, Upload-Expires, Upload-Concat, Upload-Checksum"
+
 // Configure CORS middleware based on environment (keep the working version from old main.go)
 func configureCORS(app *fiber.App, env string) {
 	var corsConfig cors.Config
@@ -220,9 +306,9 @@ func configureCORS(app *fiber.App, env string) {
 		allowedOrigins := config.GetEnv("ALLOWED_ORIGINS", "https://your-production-domain.com")
 		corsConfig = cors.Config{
 			AllowOrigins:     allowedOrigins,
-			AllowMethods:     "GET,POST,PUT,DELETE,OPTIONS",
-			AllowHeaders:     "Origin, Content-Type, Accept, Authorization",
-			ExposeHeaders:    "Content-Length, Content-Type",
+			AllowMethods:     "GET,POST,PUT,DELETE,OPTIONS,PATCH,HEAD",
+			AllowHeaders:     "Origin, Content-Type, Accept, Authorization, " + tusHeaders,
+			ExposeHeaders:    "Content-Length, Content-Type, Location, " + tusHeaders,
 			AllowCredentials: true,
 			MaxAge:           86400,
 		}
@@ -232,9 +318,9 @@ func configureCORS(app *fiber.App, env string) {
 		allowedOrigins := config.GetEnv("ALLOWED_ORIGINS", "https://staging.your-domain.com")
 		corsConfig = cors.Config{
 			AllowOrigins:     allowedOrigins,
-			AllowMethods:     "GET,POST,PUT,DELETE,OPTIONS,PATCH",
-			AllowHeaders:     "Origin, Content-Type, Accept, Authorization, X-Requested-With",
-			ExposeHeaders:    "Content-Length, Content-Type",
+			AllowMethods:     "GET,POST,PUT,DELETE,OPTIONS,PATCH,HEAD",
+			AllowHeaders:     "Origin, Content-Type, Accept, Authorization, X-Requested-With, " + tusHeaders,
+			ExposeHeaders:    "Content-Length, Content-Type, Location, " + tusHeaders,
 			AllowCredentials: true,
 			MaxAge:           3600,
 		}
@@ -245,9 +331,9 @@ func configureCORS(app *fiber.App, env string) {
 		allowedOrigins := config.GetEnv("ALLOWED_ORIGINS", "http://localhost:5173,http://127.0.0.1:5173")
 		corsConfig = cors.Config{
 			AllowOrigins:     allowedOrigins,
-			AllowMethods:     "GET,POST,PUT,DELETE,OPTIONS,PATCH",
-			AllowHeaders:     "Origin, Content-Type, Accept, Authorization, X-Requested-With",
-			ExposeHeaders:    "Content-Length, Content-Type",
+			AllowMethods:     "GET,POST,PUT,DELETE,OPTIONS,PATCH,HEAD",
+			AllowHeaders:     "Origin, Content-Type, Accept, Authorization, X-Requested-With, " + tusHeaders,
+			ExposeHeaders:    "Content-Length, Content-Type, Location, " + tusHeaders,
 			AllowCredentials: true,
 			MaxAge:           1800,
 		}
@@ -280,8 +366,28 @@ func startServer(app *fiber.App) {
 	<-quit
 
 	log.Println("🔄 Shutting down server gracefully...")
-	if err := app.Shutdown(); err != nil {
-		log.Fatalf("❌ Error during server shutdown: %v", err)
+
+	// Live event streams never finish on their own, so end them before waiting for requests
+	controllers.CloseEventStreams()
+
+	// Open requests and the work they started each get their own deadline, so slow requests
+	// can't use up the drain's time; the background workers, the NATS connection and MongoDB
+	// are closed by main afterwards
+	ctx, cancel := context.WithTimeout(context.Background(), controllers.ShutdownTimeout())
+	defer cancel()
+
+	if err := app.ShutdownWithContext(ctx); err != nil {
+		log.Printf("⚠️ Error during server shutdown: %v", err)
+	}
+
+	log.Println("⏳ Draining NATS subscriptions and in-flight work...")
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), controllers.DrainTimeout())
+	defer drainCancel()
+
+	if err := controllers.DrainBackgroundWork(drainCtx); err != nil {
+		log.Printf("⚠️ Shutdown deadline reached with work still in flight: %v", err)
+	} else {
+		log.Println("✅ In-flight work finished")
 	}
 
 	log.Println("✅ Server successfully shutdown")
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ViewEvent records a single public resolution of an experience by its ref_id
+type ViewEvent struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ContentID      primitive.ObjectID `bson:"content_id" json:"content_id"`
+	OrganizationID primitive.ObjectID `bson:"organization_id,omitempty" json:"organization_id,omitempty"`
+	RefID          string             `bson:"ref_id" json:"ref_id"`
+	DeviceClass    string             `bson:"device_class" json:"device_class"` // "mobile", "tablet", "desktop", "bot", "unknown"
+	Platform       string             `bson:"platform" json:"platform"`         // "ios", "android", "windows", "macos", "linux", "other"
+	ReferrerHost   string             `bson:"referrer_host,omitempty" json:"referrer_host,omitempty"`
+	Timestamp      time.Time          `bson:"timestamp" json:"timestamp"`
+}
+
+// ViewRollup holds the aggregated view counts for one content item on one day (UTC)
+type ViewRollup struct {
+	ContentID      primitive.ObjectID `bson:"content_id" json:"content_id"`
+	OrganizationID primitive.ObjectID `bson:"organization_id,omitempty" json:"organization_id,omitempty"`
+	Date           time.Time          `bson:"date" json:"date"`
+	Views          int64              `bson:"views" json:"views"`
+	ByDevice       map[string]int64   `bson:"by_device,omitempty" json:"by_device,omitempty"`
+	ByPlatform     map[string]int64   `bson:"by_platform,omitempty" json:"by_platform,omitempty"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DeadLetter is a processing result that could not be decoded or applied, kept with its
+// raw payload so it can be inspected, corrected and replayed
+type DeadLetter struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Subject         string             `bson:"subject" json:"subject"`
+	Headers         map[string]string  `bson:"headers,omitempty" json:"headers,omitempty"`
+	Payload         string             `bson:"payload" json:"payload"`
+	OriginalPayload string             `bson:"original_payload,omitempty" json:"original_payload,omitempty"` // Set when the payload was edited
+	Stage           string             `bson:"stage" json:"stage"`                                           // "decode" or "process"
+	Error           string             `bson:"error" json:"error"`
+	ContentID       string             `bson:"content_id,omitempty" json:"content_id,omitempty"`
+	Status          string             `bson:"status" json:"status"` // "pending", "replayed", "discarded"
+	ReplayCount     int                `bson:"replay_count" json:"replay_count"`
+	LastReplayError string             `bson:"last_replay_error,omitempty" json:"last_replay_error,omitempty"`
+	LastReplayedAt  *time.Time         `bson:"last_replayed_at,omitempty" json:"last_replayed_at,omitempty"`
+	EditedAt        *time.Time         `bson:"edited_at,omitempty" json:"edited_at,omitempty"`
+	ReceivedAt      time.Time          `bson:"received_at" json:"received_at"`
+	UpdatedAt       time.Time          `bson:"updated_at" json:"updated_at"`
+}
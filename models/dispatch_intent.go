@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DispatchIntent is an outbox entry asking for a content item's media to be processed.
+// It is written together with the content change and relayed to the MediaProcessor
+// until dispatch succeeds.
+type DispatchIntent struct {
+	ID             primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	ContentID      primitive.ObjectID  `bson:"content_id" json:"content_id"`
+	OrganizationID primitive.ObjectID  `bson:"organization_id" json:"organization_id"`
+	Media          map[string][]string `bson:"media,omitempty" json:"media,omitempty"` // Keys to process per media field; empty processes all media
+	Status         string              `bson:"status" json:"status"`                   // "pending", "sent", "skipped", "failed"
+	Attempts       int                 `bson:"attempts" json:"attempts"`
+	LastError      string              `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	Tasks          int                 `bson:"tasks,omitempty" json:"tasks,omitempty"` // Processing tasks recorded when sent
+	NextAttemptAt  time.Time           `bson:"next_attempt_at" json:"next_attempt_at"`
+	LockedUntil    *time.Time          `bson:"locked_until,omitempty" json:"-"`
+	SentAt         *time.Time          `bson:"sent_at,omitempty" json:"sent_at,omitempty"`
+	CreatedAt      time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time           `bson:"updated_at" json:"updated_at"`
+}
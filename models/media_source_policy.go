@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MediaSourcePolicy restricts which hosts an organization may reference as original media
+type MediaSourcePolicy struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrganizationID primitive.ObjectID `bson:"organization_id" json:"organization_id"`
+	AllowedHosts   []string           `bson:"allowed_hosts" json:"allowed_hosts"` // e.g. "cdn.example.com", "*.s3.amazonaws.com"
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MediaUpload is a direct upload issued for a content item. Once completed the object
+// is recorded under Role in the content's media of MediaType.
+type MediaUpload struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ContentID      primitive.ObjectID `bson:"content_id" json:"content_id"`
+	OrganizationID primitive.ObjectID `bson:"organization_id" json:"organization_id"`
+	UserID         primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	MediaType      string             `bson:"media_type" json:"media_type"` // "image", "video", "object_3d"
+	Role           string             `bson:"role" json:"role"`             // media key, e.g. "original", "original_mask"
+	Backend        string             `bson:"backend" json:"backend"`       // "local", "s3"
+	ObjectKey      string             `bson:"object_key" json:"object_key"`
+	Filename       string             `bson:"filename,omitempty" json:"filename,omitempty"`
+	ContentType    string             `bson:"content_type" json:"content_type"`
+	Size           int64              `bson:"size,omitempty" json:"size,omitempty"`
+	Status         string             `bson:"status" json:"status"` // "pending", "completed"
+	ExpiresAt      time.Time          `bson:"expires_at" json:"expires_at"`
+	CompletedAt    *time.Time         `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+}
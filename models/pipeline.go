@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PipelineStep maps a media role to the NATS subject that processes it
+type PipelineStep struct {
+	MediaType       string   `bson:"media_type" json:"media_type"`                         // "image", "video", "object_3d"
+	Role            string   `bson:"role" json:"role"`                                     // Key prefix of the source asset, e.g. "original"
+	Subject         string   `bson:"subject" json:"subject"`                               // NATS subject to publish the request to
+	ExpectedResults []string `bson:"expected_results" json:"expected_results"`             // Processing types the processor replies with
+	MaskResults     []string `bson:"mask_results,omitempty" json:"mask_results,omitempty"` // Extra results expected when a mask video is attached
+	AttachMask      bool     `bson:"attach_mask,omitempty" json:"attach_mask,omitempty"`   // Send the first "mask" video as alphavideo_url
+	FirstOnly       bool     `bson:"first_only,omitempty" json:"first_only,omitempty"`     // Only dispatch the first matching asset
+	RequireAlpha    bool     `bson:"require_alpha,omitempty" json:"require_alpha,omitempty"`
+	ThumbnailAt     float64  `bson:"thumbnail_at,omitempty" json:"thumbnail_at,omitempty"`       // Overrides THUMBNAIL_AT_SECONDS
+	ThumbnailSizes  []string `bson:"thumbnail_sizes,omitempty" json:"thumbnail_sizes,omitempty"` // Overrides THUMBNAIL_SIZES
+	PosterSize      string   `bson:"poster_size,omitempty" json:"poster_size,omitempty"`         // Overrides POSTER_SIZE
+}
+
+// ProcessingPipeline defines how media of a render_type is processed.
+// Pipelines without an OrganizationID apply to every organization.
+type ProcessingPipeline struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	OrganizationID primitive.ObjectID `bson:"organization_id,omitempty" json:"organization_id,omitempty"`
+	RenderType     string             `bson:"render_type" json:"render_type"` // "*" matches any render_type
+	Steps          []PipelineStep     `bson:"steps" json:"steps"`
+	CreatedAt      time.Time          `bson:"created_at,omitempty" json:"created_at,omitempty"`
+	UpdatedAt      time.Time          `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ProcessingEvent is a processing update pushed to clients watching a content item.
+// Its ID doubles as the SSE event id used to resume a stream.
+type ProcessingEvent struct {
+	ID        primitive.ObjectID     `bson:"_id" json:"id"`
+	ContentID primitive.ObjectID     `bson:"content_id" json:"content_id"`
+	Type      string                 `bson:"type" json:"type"` // "status", "progress", "rendition", "failure"
+	Data      map[string]interface{} `bson:"data" json:"data"`
+	CreatedAt time.Time              `bson:"created_at" json:"created_at"`
+}
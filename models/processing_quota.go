@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ProcessingQuota limits how many processing tasks of an organization may be in flight
+// at once and sets the priority class its tasks are dispatched with
+type ProcessingQuota struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	OrganizationID primitive.ObjectID `bson:"organization_id" json:"organization_id"`
+	MaxInFlight    int                `bson:"max_in_flight" json:"max_in_flight"` // 0 uses PROCESSING_MAX_IN_FLIGHT
+	Priority       string             `bson:"priority" json:"priority"`           // "high", "normal" or "low"
+	InFlight       int                `bson:"in_flight" json:"in_flight"`         // Dispatch slots taken, kept by the dispatch queue
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+}
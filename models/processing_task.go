@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ExpectedOutcome is one result a dispatched message is expected to produce
+type ExpectedOutcome struct {
+	ResultType string     `bson:"result_type" json:"result_type"` // Processing type expected back, e.g. "compressed", "hls"
+	Status     string     `bson:"status" json:"status"`           // "pending", "succeeded", "failed", "superseded"
+	Error      string     `bson:"error,omitempty" json:"error,omitempty"`
+	ReceivedAt *time.Time `bson:"received_at,omitempty" json:"received_at,omitempty"`
+}
+
+// ProcessingTask records a single message published to the MediaProcessor
+// and the outcomes we are waiting for
+type ProcessingTask struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ContentID      primitive.ObjectID `bson:"content_id" json:"content_id"`
+	OrganizationID primitive.ObjectID `bson:"organization_id,omitempty" json:"organization_id,omitempty"`
+	Subject        string             `bson:"subject" json:"subject"`
+	MediaType      string             `bson:"media_type" json:"media_type"`
+	SourceKey      string             `bson:"source_key" json:"source_key"`
+	SourceURL      string             `bson:"source_url" json:"source_url"`
+	CorrelationID  string             `bson:"correlation_id" json:"correlation_id"`
+	RequestVersion int                `bson:"request_version" json:"request_version"`
+	Payload        string             `bson:"payload" json:"-"` // JSON request body, kept for re-publishing
+	Outcomes       []ExpectedOutcome  `bson:"outcomes" json:"outcomes"`
+	Status         string             `bson:"status" json:"status"`     // "queued", "dispatched", "completed", "failed", "stalled", "superseded", "cancelled"
+	Priority       string             `bson:"priority" json:"priority"` // "high", "normal" or "low"
+	PriorityRank   int                `bson:"priority_rank" json:"-"`   // Sort key for the dispatch queue, 0 is released first
+	Attempts       int                `bson:"attempts" json:"attempts"`
+	DeadlineAt     time.Time          `bson:"deadline_at" json:"deadline_at"` // When the watchdog considers the task stalled
+	QueuedAt       time.Time          `bson:"queued_at" json:"queued_at"`
+	DispatchedAt   time.Time          `bson:"dispatched_at" json:"dispatched_at"`
+	CompletedAt    *time.Time         `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// Rendition is one output the MediaProcessor produced from an original: a compressed file,
+// a streaming manifest or one rung of its ladder, a thumbnail or an optimized 3D model.
+// It is both the "outputs" entry of a v2 result and the entry stored on the content.
+type Rendition struct {
+	Type            string  `bson:"type" json:"type"`                       // "compressed", "hls", "dash", "alpha", "stitched", "thumbnail", "poster", "glb", ...
+	Role            string  `bson:"role,omitempty" json:"role,omitempty"`   // "master" for streaming manifests, "variant" for ladder rungs
+	Label           string  `bson:"label,omitempty" json:"label,omitempty"` // e.g. "1080p" or "320x180"
+	URL             string  `bson:"url" json:"url"`
+	MimeType        string  `bson:"mime_type,omitempty" json:"mime_type,omitempty"`
+	Container       string  `bson:"container,omitempty" json:"container,omitempty"` // e.g. "mp4", "ts", "fmp4"
+	Width           int     `bson:"width,omitempty" json:"width,omitempty"`
+	Height          int     `bson:"height,omitempty" json:"height,omitempty"`
+	Bitrate         int     `bson:"bitrate,omitempty" json:"bitrate,omitempty"` // Bits per second
+	Codec           string  `bson:"codec,omitempty" json:"codec,omitempty"`     // e.g. "avc1.640028"
+	AudioCodec      string  `bson:"audio_codec,omitempty" json:"audio_codec,omitempty"`
+	FrameRate       float64 `bson:"frame_rate,omitempty" json:"frame_rate,omitempty"`
+	SegmentDuration float64 `bson:"segment_duration,omitempty" json:"segment_duration,omitempty"` // Seconds
+	SizeBytes       int64   `bson:"size_bytes,omitempty" json:"size_bytes,omitempty"`
+
+	// Set when the rendition is stored
+	MediaType   string    `bson:"media_type" json:"media_type"`
+	OriginalURL string    `bson:"original_url" json:"original_url"`
+	TaskID      string    `bson:"task_id,omitempty" json:"task_id,omitempty"`
+	CreatedAt   time.Time `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ReprocessFilter selects the content a reprocessing job re-dispatches
+type ReprocessFilter struct {
+	OrganizationID   *primitive.ObjectID `bson:"organization_id,omitempty" json:"organization_id,omitempty"`
+	RenderTypes      []string            `bson:"render_types,omitempty" json:"render_types,omitempty"`
+	Statuses         []string            `bson:"statuses,omitempty" json:"statuses,omitempty"`
+	CreatedAfter     *time.Time          `bson:"created_after,omitempty" json:"created_after,omitempty"`
+	CreatedBefore    *time.Time          `bson:"created_before,omitempty" json:"created_before,omitempty"`
+	MissingRendition string              `bson:"missing_rendition,omitempty" json:"missing_rendition,omitempty"` // e.g. "videos.hls"
+}
+
+// ReprocessFailure records a content item a reprocessing job could not re-dispatch
+type ReprocessFailure struct {
+	ContentID primitive.ObjectID `bson:"content_id" json:"content_id"`
+	Error     string             `bson:"error" json:"error"`
+	At        time.Time          `bson:"at" json:"at"`
+}
+
+// ReprocessJob re-dispatches processing for every content item matching a filter at a
+// controlled rate. Content is walked in _id order and the last handled ID is checkpointed,
+// so an interrupted job resumes where it stopped.
+type ReprocessJob struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Filter        ReprocessFilter    `bson:"filter" json:"filter"`
+	Priority      string             `bson:"priority" json:"priority"` // Priority class of the re-dispatched tasks
+	RatePerMinute int                `bson:"rate_per_minute" json:"rate_per_minute"`
+	Status        string             `bson:"status" json:"status"` // "running", "paused", "completed", "cancelled", "failed"
+	Error         string             `bson:"error,omitempty" json:"error,omitempty"`
+	Matched       int64              `bson:"matched" json:"matched"` // Content matching the filter when the job was created
+	Processed     int                `bson:"processed" json:"processed"`
+	Dispatched    int                `bson:"dispatched" json:"dispatched"` // Content with at least one task recorded
+	Tasks         int                `bson:"tasks" json:"tasks"`
+	Skipped       int                `bson:"skipped" json:"skipped"` // Content the pipeline had nothing to do for
+	Failed        int                `bson:"failed" json:"failed"`
+	Failures      []ReprocessFailure `bson:"failures,omitempty" json:"failures,omitempty"` // Most recent failures only
+	LastContentID primitive.ObjectID `bson:"last_content_id,omitempty" json:"last_content_id,omitempty"`
+	CreatedBy     string             `bson:"created_by" json:"created_by"`
+	LeaseID       primitive.ObjectID `bson:"lease_id,omitempty" json:"-"` // Identifies the runner holding the job
+	LockedUntil   *time.Time         `bson:"locked_until,omitempty" json:"-"`
+	StartedAt     *time.Time         `bson:"started_at,omitempty" json:"started_at,omitempty"`
+	CompletedAt   *time.Time         `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt     time.Time          `bson:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TusUpload is a resumable (tus 1.0) upload of an original for a content item.
+// Partial uploads are sent in parallel and joined by a final upload.
+type TusUpload struct {
+	ID             primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	ContentID      primitive.ObjectID   `bson:"content_id" json:"content_id"`
+	OrganizationID primitive.ObjectID   `bson:"organization_id" json:"organization_id"`
+	UserID         primitive.ObjectID   `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	MediaType      string               `bson:"media_type" json:"media_type"`
+	Role           string               `bson:"role" json:"role"`
+	Filename       string               `bson:"filename,omitempty" json:"filename,omitempty"`
+	ContentType    string               `bson:"content_type" json:"content_type"`
+	Metadata       string               `bson:"metadata,omitempty" json:"metadata,omitempty"` // raw Upload-Metadata header
+	Length         int64                `bson:"length" json:"length"`
+	Offset         int64                `bson:"offset" json:"offset"`
+	IsPartial      bool                 `bson:"is_partial,omitempty" json:"is_partial,omitempty"`
+	PartialIDs     []primitive.ObjectID `bson:"partial_ids,omitempty" json:"partial_ids,omitempty"` // set on final uploads
+	Status         string               `bson:"status" json:"status"`                               // "uploading", "finalizing", "completed", "failed"
+	Error          string               `bson:"error,omitempty" json:"error,omitempty"`
+	LockedUntil    *time.Time           `bson:"locked_until,omitempty" json:"-"`
+	FinalizeUntil  *time.Time           `bson:"finalize_until,omitempty" json:"-"` // Lease of the replica copying the upload to storage
+	ExpiresAt      time.Time            `bson:"expires_at" json:"expires_at"`
+	CreatedAt      time.Time            `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time            `bson:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// WebhookSubscription is an organization's endpoint for content lifecycle events
+type WebhookSubscription struct {
+	ID                  primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrganizationID      primitive.ObjectID `bson:"organization_id" json:"organization_id"`
+	UserID              primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	URL                 string             `bson:"url" json:"url"`
+	Secret              string             `bson:"secret" json:"-"`
+	EventTypes          []string           `bson:"event_types" json:"event_types"` // e.g. "content.processed", "*" for all
+	Description         string             `bson:"description,omitempty" json:"description,omitempty"`
+	IsActive            bool               `bson:"is_active" json:"is_active"`
+	ConsecutiveFailures int                `bson:"consecutive_failures" json:"consecutive_failures"`
+	DisabledReason      string             `bson:"disabled_reason,omitempty" json:"disabled_reason,omitempty"`
+	DisabledAt          *time.Time         `bson:"disabled_at,omitempty" json:"disabled_at,omitempty"`
+	CreatedAt           time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt           time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// WebhookAttempt is the log entry of a single delivery attempt
+type WebhookAttempt struct {
+	At         time.Time `bson:"at" json:"at"`
+	StatusCode int       `bson:"status_code,omitempty" json:"status_code,omitempty"`
+	Error      string    `bson:"error,omitempty" json:"error,omitempty"`
+	DurationMs int64     `bson:"duration_ms" json:"duration_ms"`
+}
+
+// WebhookDelivery is a queued event for one subscription, with its delivery log
+type WebhookDelivery struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	SubscriptionID primitive.ObjectID `bson:"subscription_id" json:"subscription_id"`
+	OrganizationID primitive.ObjectID `bson:"organization_id" json:"organization_id"`
+	EventID        string             `bson:"event_id" json:"event_id"`
+	EventType      string             `bson:"event_type" json:"event_type"`
+	Payload        string             `bson:"payload" json:"payload"`
+	Status         string             `bson:"status" json:"status"` // "pending", "delivered", "failed", "cancelled"
+	Attempts       int                `bson:"attempts" json:"attempts"`
+	NextAttemptAt  time.Time          `bson:"next_attempt_at" json:"next_attempt_at"`
+	AttemptLog     []WebhookAttempt   `bson:"attempt_log,omitempty" json:"attempt_log,omitempty"`
+	DeliveredAt    *time.Time         `bson:"delivered_at,omitempty" json:"delivered_at,omitempty"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+}
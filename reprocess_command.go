@@ -0,0 +1,199 @@
+package main
+
+import (
+	"MRContent/controllers"
+	"MRContent/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/praleedsuvarna/shared-libs/config"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// runReprocessCommand implements "reprocess": it re-dispatches processing for the content
+// matching the flags at a controlled rate and prints the job's report. With -dry-run it only
+// lists what would be sent. An interrupted run is paused and continues with -resume <job id>.
+func runReprocessCommand(args []string) int {
+	flags := flag.NewFlagSet("reprocess", flag.ContinueOnError)
+	orgID := flags.String("org", "", "only content of this organization ID")
+	renderTypes := flags.String("render-type", "", "only these render types (comma-separated)")
+	statuses := flags.String("status", "", "only content in these statuses (comma-separated)")
+	createdAfter := flags.String("created-after", "", "only content created at or after this time (2006-01-02 or RFC 3339)")
+	createdBefore := flags.String("created-before", "", "only content created before this time (2006-01-02 or RFC 3339)")
+	missingRendition := flags.String("missing-rendition", "", "only content lacking this rendition, e.g. videos.hls")
+	rate := flags.Int("rate", 0, "content items per minute (default REPROCESS_RATE_PER_MINUTE or 30)")
+	priority := flags.String("priority", "low", "priority class of the dispatched tasks: high, normal or low")
+	dryRun := flags.Bool("dry-run", false, "list what would be sent without sending anything")
+	limit := flags.Int64("limit", 1000, "content items listed by -dry-run")
+	resume := flags.String("resume", "", "continue the paused or interrupted job with this ID")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	config.ConnectDB()
+	defer config.DisconnectDB()
+
+	var job models.ReprocessJob
+	var err error
+
+	if *resume != "" {
+		job, err = resumeReprocessJob(*resume)
+	} else {
+		var filter models.ReprocessFilter
+		filter, err = reprocessFilterFromFlags(*orgID, *renderTypes, *statuses, *createdAfter, *createdBefore, *missingRendition)
+		if err == nil {
+			job, err = controllers.NewReprocessJob(filter, *rate, *priority, "cli")
+		}
+	}
+	if err != nil {
+		log.Printf("❌ %v", err)
+		return 1
+	}
+
+	if *dryRun {
+		matched, previews, err := controllers.PreviewReprocess(job.Filter, *limit)
+		if err != nil {
+			log.Printf("❌ %v", err)
+			return 1
+		}
+
+		printJSON(map[string]interface{}{
+			"dry_run":         true,
+			"matched":         matched,
+			"rate_per_minute": job.RatePerMinute,
+			"priority":        job.Priority,
+			"data":            previews,
+		})
+		return 0
+	}
+
+	if _, err := controllers.InitMessageBus(); err != nil {
+		log.Printf("❌ %v", err)
+		return 1
+	}
+	defer controllers.CloseMessageBus()
+
+	if *resume == "" {
+		if err := controllers.InsertReprocessJob(&job); err != nil {
+			log.Printf("❌ %v", err)
+			return 1
+		}
+		log.Printf("🔁 Created reprocess job %s for %d content items", job.ID.Hex(), job.Matched)
+	}
+
+	job, err = controllers.ClaimReprocessJob(job.ID)
+	if err != nil {
+		log.Printf("❌ %v", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	err = controllers.RunReprocessJob(ctx, job)
+	if ctx.Err() != nil {
+		if err := controllers.ReleaseReprocessJob(job); err != nil {
+			log.Printf("❌ %v", err)
+		}
+		log.Printf("⏸️ Interrupted, continue with: reprocess -resume %s", job.ID.Hex())
+	} else if errors.Is(err, controllers.ErrReprocessBusUnavailable) {
+		log.Printf("⏸️ Message bus disconnected. The job stays running for a server's runner, or continue in a minute with: reprocess -resume %s", job.ID.Hex())
+	} else if err != nil {
+		log.Printf("❌ Reprocess job %s stopped: %v", job.ID.Hex(), err)
+	}
+
+	if report, loadErr := controllers.LoadReprocessJob(job.ID); loadErr == nil {
+		printJSON(controllers.ReprocessReport(report))
+	}
+
+	if err != nil && ctx.Err() == nil {
+		return 1
+	}
+	return 0
+}
+
+// resumeReprocessJob puts a paused job back to running so it can be claimed
+func resumeReprocessJob(id string) (models.ReprocessJob, error) {
+	jobID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return models.ReprocessJob{}, fmt.Errorf("invalid job ID %q", id)
+	}
+
+	job, err := controllers.LoadReprocessJob(jobID)
+	if err != nil {
+		return job, fmt.Errorf("reprocess job %s not found", id)
+	}
+
+	if job.Status == "paused" {
+		return controllers.SetReprocessJobStatus(jobID, []string{"paused"}, "running")
+	}
+	return job, nil
+}
+
+// reprocessFilterFromFlags builds a filter from the command's flags
+func reprocessFilterFromFlags(orgID, renderTypes, statuses, createdAfter, createdBefore, missingRendition string) (models.ReprocessFilter, error) {
+	filter := models.ReprocessFilter{
+		RenderTypes:      splitList(renderTypes),
+		Statuses:         splitList(statuses),
+		MissingRendition: missingRendition,
+	}
+
+	if orgID != "" {
+		objOrgID, err := primitive.ObjectIDFromHex(orgID)
+		if err != nil {
+			return filter, fmt.Errorf("invalid organization ID %q", orgID)
+		}
+		filter.OrganizationID = &objOrgID
+	}
+
+	var err error
+	if filter.CreatedAfter, err = parseFlagTime(createdAfter); err != nil {
+		return filter, err
+	}
+	if filter.CreatedBefore, err = parseFlagTime(createdBefore); err != nil {
+		return filter, err
+	}
+
+	return filter, nil
+}
+
+// parseFlagTime accepts a date or an RFC 3339 timestamp; an empty value means no bound
+func parseFlagTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid time %q, use 2006-01-02 or RFC 3339", value)
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func printJSON(value interface{}) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		log.Printf("❌ %v", err)
+	}
+}
@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"MRContent/controllers"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/praleedsuvarna/shared-libs/middleware"
+)
+
+func DeadLetterRoutes(app *fiber.App) {
+	deadLetters := app.Group("/admin/dead-letters", middleware.AuthMiddleware, middleware.SuperAdminOnly())
+
+	deadLetters.Get("/", controllers.ListDeadLetters)             // List dead-lettered results
+	deadLetters.Get("/stats", controllers.GetDeadLetterStats)     // Pending and recent counts
+	deadLetters.Get("/:id", controllers.GetDeadLetter)            // Inspect the raw payload and error
+	deadLetters.Put("/:id", controllers.UpdateDeadLetter)         // Correct the payload before replaying
+	deadLetters.Post("/:id/replay", controllers.ReplayDeadLetter) // Run it through result processing again
+	deadLetters.Delete("/:id", controllers.DiscardDeadLetter)     // Give up on it
+}
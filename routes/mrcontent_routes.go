@@ -12,6 +12,10 @@ func MRContentRoutes(app *fiber.App) {
 	// Public route for ref_id (must be registered BEFORE the id route to avoid conflicts)
 	app.Get("/mr-content/ref/:ref_id", controllers.GetMRContentByRefID)
 
+	// Processing event stream (SSE or WebSocket). Registered ahead of the group because
+	// browsers' EventSource can't send an Authorization header, so the token may come as a query parameter
+	app.Get("/mr-content/:id/events", QueryTokenMiddleware, middleware.AuthMiddleware, controllers.StreamMRContentEvents)
+
 	mrContent := app.Group("/mr-content", middleware.AuthMiddleware)
 
 	// CRUD operations requiring authentication
@@ -20,6 +24,32 @@ func MRContentRoutes(app *fiber.App) {
 	mrContent.Put("/:id", controllers.UpdateMRContent)    // Update MR content
 	mrContent.Delete("/:id", controllers.DeleteMRContent) // Soft delete MR content
 	mrContent.Get("/", controllers.ListMRContents)        // List all MR contents with pagination
+
+	// Analytics
+	mrContent.Get("/:id/analytics", controllers.GetMRContentAnalytics) // View counts rolled up by day/week/month
+
+	// Processing status of each source asset with its tasks and timeline
+	mrContent.Get("/:id/processing", controllers.GetMRContentProcessing)
+
+	// Direct uploads of original media
+	mrContent.Post("/:id/uploads", controllers.CreateMediaUpload)                       // Issue a presigned upload URL
+	mrContent.Post("/:id/uploads/:upload_id/complete", controllers.CompleteMediaUpload) // Record the uploaded original and process it
+
+	// Resumable uploads (tus 1.0) for large originals
+	mrContent.Options("/:id/tus", controllers.TusOptions)
+	mrContent.Post("/:id/tus", controllers.CreateTusUpload)
+	mrContent.Head("/:id/tus/:upload_id", controllers.GetTusUploadOffset)
+	mrContent.Patch("/:id/tus/:upload_id", controllers.PatchTusUpload)
+	mrContent.Delete("/:id/tus/:upload_id", controllers.DeleteTusUpload)
+}
+
+// QueryTokenMiddleware copies an access_token query parameter into the Authorization
+// header for clients that cannot set headers themselves
+func QueryTokenMiddleware(c *fiber.Ctx) error {
+	if c.Get(fiber.HeaderAuthorization) == "" && c.Query("access_token") != "" {
+		c.Request().Header.Set(fiber.HeaderAuthorization, c.Query("access_token"))
+	}
+	return c.Next()
 }
 
 // Debug middleware to diagnose the issue
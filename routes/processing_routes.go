@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"MRContent/controllers"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/praleedsuvarna/shared-libs/middleware"
+)
+
+func ProcessingRoutes(app *fiber.App) {
+	// Queue depth and quota of the caller's organization
+	app.Get("/processing/queue", middleware.AuthMiddleware, controllers.GetProcessingQueue)
+
+	admin := app.Group("/admin/processing", middleware.AuthMiddleware, middleware.SuperAdminOnly())
+
+	admin.Get("/queues", controllers.ListProcessingQueues)          // Queue depth of every organization
+	admin.Put("/quotas/:org_id", controllers.UpdateProcessingQuota) // Set an organization's in-flight limit and priority
+}
@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"MRContent/controllers"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/praleedsuvarna/shared-libs/middleware"
+)
+
+func ReprocessRoutes(app *fiber.App) {
+	reprocess := app.Group("/admin/reprocess", middleware.AuthMiddleware, middleware.SuperAdminOnly())
+
+	reprocess.Post("/", controllers.CreateReprocessJob)           // Start a job, or list what it would send with dry_run
+	reprocess.Get("/", controllers.ListReprocessJobs)             // List recent jobs
+	reprocess.Get("/:id", controllers.GetReprocessJob)            // Progress and failure report
+	reprocess.Post("/:id/pause", controllers.PauseReprocessJob)   // Pause at the next checkpoint
+	reprocess.Post("/:id/resume", controllers.ResumeReprocessJob) // Continue from the checkpoint
+	reprocess.Post("/:id/cancel", controllers.CancelReprocessJob) // Stop for good
+}
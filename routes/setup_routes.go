@@ -15,4 +15,9 @@ func SetupRoutes(app *fiber.App) {
 		})
 	})
 	MRContentRoutes(app)
+	WebhookRoutes(app)
+	StorageRoutes(app)
+	ProcessingRoutes(app)
+	ReprocessRoutes(app)
+	DeadLetterRoutes(app)
 }
@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"MRContent/controllers"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// StorageRoutes serves the local storage backend. Uploads and downloads are authorized by
+// the signature in their URL rather than a token.
+func StorageRoutes(app *fiber.App) {
+	if _, ok := controllers.GetStorage().(*controllers.LocalStorage); !ok {
+		return
+	}
+
+	app.Put("/storage/local/*", controllers.HandleLocalStorageUpload)
+	app.Get("/storage/files/*", controllers.HandleLocalStorageDownload)
+}
@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"MRContent/controllers"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/praleedsuvarna/shared-libs/middleware"
+)
+
+func WebhookRoutes(app *fiber.App) {
+	webhooks := app.Group("/webhooks", middleware.AuthMiddleware)
+
+	webhooks.Post("/", controllers.CreateWebhook)      // Register a webhook endpoint
+	webhooks.Get("/", controllers.ListWebhooks)        // List the organization's webhooks
+	webhooks.Get("/:id", controllers.GetWebhook)       // Get a single webhook
+	webhooks.Put("/:id", controllers.UpdateWebhook)    // Update URL, event filter or re-enable
+	webhooks.Delete("/:id", controllers.DeleteWebhook) // Delete a webhook
+
+	// Delivery log and manual redelivery
+	webhooks.Get("/:id/deliveries", controllers.ListWebhookDeliveries)
+	webhooks.Post("/:id/deliveries/:delivery_id/redeliver", controllers.RedeliverWebhook)
+}